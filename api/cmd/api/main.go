@@ -145,6 +145,7 @@ func autoMigrate(db *gorm.DB) error {
 		&model.Permission{},
 		&model.RolePermission{},
 		&model.UserRole{},
+		&model.DeviceCommand{},
 	)
 }
 
@@ -1,10 +1,11 @@
-// JT808 扩展指令 - 参数查询/设置、终端控制、位置查询
+// JT808 扩展指令 - 参数查询/设置、终端控制、位置查询、指令历史
 
 package handler
 
 import (
 	"context"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -41,20 +42,44 @@ func (h *JT808ExtendedHandler) RegisterRoutes(r *gin.RouterGroup) {
 		
 		// 位置查询
 		jt808.POST("/location/query", h.QueryLocation)  // 0x8201
+		jt808.POST("/location/track", h.TrackLocation)  // 0x8202
+		
+		// 文本信息
+		jt808.POST("/text", h.SendText)                 // 0x8300
 		
 		// 车辆控制
 		jt808.POST("/vehicle/control", h.ControlVehicle) // 0x8500
+		
+		// 指令历史（含离线排队状态）
+		jt808.GET("/commands", h.GetCommandHistory)
 	}
 }
 
-// QueryParams 查询终端参数 (0x8104)
+// QueryParams 查询终端参数 (0x8104)，指定 ids 时查询指定参数 (0x8106)
 func (h *JT808ExtendedHandler) QueryParams(c *gin.Context) {
 	deviceID := c.Param("id")
 	
+	var req struct {
+		IDs []string `json:"ids"` // 参数名或参数ID，如 heartbeat_interval / 0x0001
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	
+	command := model.CmdGetParams
+	var params map[string]interface{}
+	if len(req.IDs) > 0 {
+		command = model.CmdQueryParams
+		params = map[string]interface{}{"ids": req.IDs}
+	}
+	
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
 	defer cancel()
 	
-	resp, err := h.commandService.SendCommand(ctx, deviceID, model.CmdGetParams, nil, 30*time.Second)
+	resp, err := h.commandService.SendCommand(ctx, deviceID, command, params, 30*time.Second)
 	if err != nil {
 		c.JSON(http.StatusGatewayTimeout, gin.H{"error": err.Error()})
 		return
@@ -100,9 +125,9 @@ func (h *JT808ExtendedHandler) ControlTerminal(c *gin.Context) {
 	}
 	
 	commandMap := map[string]string{
-		"reboot":  "1", // 终端复位
-		"reset":   "2", // 终端复位
-		"factory": "3", // 恢复出厂设置
+		"reboot":  "4", // 终端复位
+		"reset":   "4", // 终端复位
+		"factory": "5", // 恢复出厂设置
 	}
 	
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
@@ -135,6 +160,75 @@ func (h *JT808ExtendedHandler) QueryLocation(c *gin.Context) {
 	c.JSON(http.StatusOK, resp)
 }
 
+// TrackLocation 临时位置跟踪 (0x8202)
+func (h *JT808ExtendedHandler) TrackLocation(c *gin.Context) {
+	deviceID := c.Param("id")
+	
+	var req struct {
+		Interval int `json:"interval" binding:"min=0,max=65535"` // 秒，0 表示停止跟踪
+		Duration int `json:"duration" binding:"min=0"`           // 跟踪有效期，秒
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+	
+	resp, err := h.commandService.SendCommand(ctx, deviceID, model.CmdTempTracking, map[string]interface{}{
+		"interval": req.Interval,
+		"duration": req.Duration,
+	}, 30*time.Second)
+	if err != nil {
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": err.Error()})
+		return
+	}
+	
+	c.JSON(http.StatusOK, resp)
+}
+
+// SendText 文本信息下发 (0x8300)
+func (h *JT808ExtendedHandler) SendText(c *gin.Context) {
+	deviceID := c.Param("id")
+	
+	var req struct {
+		Text      string `json:"text" binding:"required"`
+		Emergency bool   `json:"emergency"`
+		Display   *bool  `json:"display"` // 终端显示器显示，默认 true
+		TTS       *bool  `json:"tts"`     // 终端TTS播读，默认 true
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	flag := 0
+	if req.Emergency {
+		flag |= 0x01
+	}
+	if req.Display == nil || *req.Display {
+		flag |= 0x04
+	}
+	if req.TTS == nil || *req.TTS {
+		flag |= 0x08
+	}
+	
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+	
+	resp, err := h.commandService.SendCommand(ctx, deviceID, model.CmdTextMessage, map[string]interface{}{
+		"text": req.Text,
+		"flag": flag,
+	}, 30*time.Second)
+	if err != nil {
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": err.Error()})
+		return
+	}
+	
+	c.JSON(http.StatusOK, resp)
+}
+
 // ControlVehicle 车辆控制 (0x8500)
 func (h *JT808ExtendedHandler) ControlVehicle(c *gin.Context) {
 	deviceID := c.Param("id")
@@ -165,3 +259,17 @@ func (h *JT808ExtendedHandler) ControlVehicle(c *gin.Context) {
 	
 	c.JSON(http.StatusOK, resp)
 }
+
+// GetCommandHistory 指令历史，包含排队、下发、应答、过期等状态
+func (h *JT808ExtendedHandler) GetCommandHistory(c *gin.Context) {
+	deviceID := c.Param("id")
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	
+	commands, err := h.commandService.GetCommandHistory(deviceID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{"data": commands})
+}
@@ -18,6 +18,33 @@ const (
 	AlarmTypePowerCut      AlarmType = "POWER_CUT"
 	AlarmTypeVibration     AlarmType = "VIBRATION"
 	AlarmTypeIllegalMove   AlarmType = "ILLEGAL_MOVE"
+
+	// 终端上报的 JT808 报警标志位
+	AlarmTypeFatigueDriving    AlarmType = "FATIGUE_DRIVING"
+	AlarmTypeDangerWarning     AlarmType = "DANGER_WARNING"
+	AlarmTypeGNSSFault         AlarmType = "GNSS_FAULT"
+	AlarmTypeGNSSAntennaCut    AlarmType = "GNSS_ANTENNA_CUT"
+	AlarmTypeGNSSAntennaShort  AlarmType = "GNSS_ANTENNA_SHORT"
+	AlarmTypePowerUndervoltage AlarmType = "POWER_UNDERVOLTAGE"
+	AlarmTypeLCDFault          AlarmType = "LCD_FAULT"
+	AlarmTypeTTSFault          AlarmType = "TTS_FAULT"
+	AlarmTypeCameraFault       AlarmType = "CAMERA_FAULT"
+	AlarmTypeICCardFault       AlarmType = "IC_CARD_FAULT"
+	AlarmTypeOverspeedWarning  AlarmType = "OVERSPEED_WARNING"
+	AlarmTypeFatigueWarning    AlarmType = "FATIGUE_WARNING"
+	AlarmTypeDrivingTimeout    AlarmType = "DRIVING_TIMEOUT"
+	AlarmTypeParkingTimeout    AlarmType = "PARKING_TIMEOUT"
+	AlarmTypeAreaInOut         AlarmType = "AREA_IN_OUT"
+	AlarmTypeRouteInOut        AlarmType = "ROUTE_IN_OUT"
+	AlarmTypeRouteDrivingTime  AlarmType = "ROUTE_DRIVING_TIME"
+	AlarmTypeRouteDeviation    AlarmType = "ROUTE_DEVIATION"
+	AlarmTypeVSSFault          AlarmType = "VSS_FAULT"
+	AlarmTypeFuelAbnormal      AlarmType = "FUEL_ABNORMAL"
+	AlarmTypeTheft             AlarmType = "THEFT"
+	AlarmTypeIllegalIgnition   AlarmType = "ILLEGAL_IGNITION"
+	AlarmTypeCollision         AlarmType = "COLLISION"
+	AlarmTypeRollover          AlarmType = "ROLLOVER"
+	AlarmTypeIllegalDoorOpen   AlarmType = "ILLEGAL_DOOR_OPEN"
 )
 
 // AlarmLevel 报警级别
@@ -6,16 +6,19 @@ import (
 
 // DeviceCommand 设备指令记录
 type DeviceCommand struct {
-	ID        int       `json:"id" gorm:"primaryKey"`
-	DeviceID  string    `json:"device_id" gorm:"column:device_id;type:varchar(20);not null;index"`
-	Command   string    `json:"command" gorm:"type:varchar(50);not null"`
-	Params    string    `json:"params,omitempty" gorm:"type:jsonb"`
-	Status    string    `json:"status" gorm:"type:varchar(20);not null;default:'pending'"` // pending, sent, success, failed, timeout
-	Response  string    `json:"response,omitempty" gorm:"type:jsonb"`
-	ErrorMsg  string    `json:"error_msg,omitempty" gorm:"column:error_msg;type:text"`
-	SentBy    *int      `json:"sent_by,omitempty" gorm:"column:sent_by"`
-	CreatedAt time.Time `json:"created_at" gorm:"not null;default:now()"`
-	UpdatedAt time.Time `json:"updated_at" gorm:"not null;default:now()"`
+	ID        int        `json:"id" gorm:"primaryKey"`
+	CommandID string     `json:"command_id" gorm:"column:command_id;type:varchar(64);index"` // 网关响应关联ID
+	DeviceID  string     `json:"device_id" gorm:"column:device_id;type:varchar(20);not null;index"`
+	Command   string     `json:"command" gorm:"type:varchar(50);not null"`
+	Params    string     `json:"params,omitempty" gorm:"type:jsonb"`
+	Status    string     `json:"status" gorm:"type:varchar(20);not null;default:'pending'"` // pending, queued, sent, success, failed, timeout, expired
+	Response  string     `json:"response,omitempty" gorm:"type:jsonb"`
+	ErrorMsg  string     `json:"error_msg,omitempty" gorm:"column:error_msg;type:text"`
+	SentBy    *int       `json:"sent_by,omitempty" gorm:"column:sent_by"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty" gorm:"column:expires_at;index"` // 离线排队过期时间
+	SentAt    *time.Time `json:"sent_at,omitempty" gorm:"column:sent_at"`
+	CreatedAt time.Time  `json:"created_at" gorm:"not null;default:now()"`
+	UpdatedAt time.Time  `json:"updated_at" gorm:"not null;default:now()"`
 }
 
 func (DeviceCommand) TableName() string {
@@ -27,9 +30,12 @@ const (
 	CmdLocationQuery   = "LOCATION_QUERY"   // 位置查询 0x8201
 	CmdSetParams       = "SET_PARAMS"       // 设置参数 0x8103
 	CmdGetParams       = "GET_PARAMS"       // 查询参数 0x8104
+	CmdQueryParams     = "QUERY_PARAMS"     // 查询指定参数 0x8106
 	CmdTerminalControl = "TERMINAL_CONTROL" // 终端控制 0x8105
+	CmdTempTracking    = "TEMP_TRACKING"    // 临时位置跟踪 0x8202
 	CmdVehicleControl  = "VEHICLE_CONTROL"  // 车辆控制 0x8500
 	CmdTextMessage     = "TEXT_MESSAGE"     // 文本信息下发 0x8300
+	CmdCustom          = "CUSTOM"           // 自定义文本指令 (GT06 0x80 等)
 )
 
 // SendCommandRequest 发送指令请求
@@ -53,4 +59,5 @@ type CommandResponse struct {
 	Success   bool                   `json:"success"`
 	Data      map[string]interface{} `json:"data,omitempty"`
 	Error     string                 `json:"error,omitempty"`
+	Offline   bool                   `json:"offline,omitempty"` // 设备离线，指令已排队
 }
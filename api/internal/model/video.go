@@ -114,6 +114,7 @@ type SnapshotResponse struct {
 
 // JT1078Command JT1078相关指令
 type JT1078Command struct {
+	CommandID  string `json:"command_id,omitempty"` // 网关按此ID回复 device.<id>.command.response
 	DeviceID   string `json:"device_id"`
 	Channel    int    `json:"channel"`
 	Command    string `json:"command"` // play, stop, pause, resume, speed
@@ -69,6 +69,7 @@ func (s *Server) Setup() {
 	geofenceService := service.NewGeofenceService(s.db, s.redis)
 	alarmService := service.NewAlarmService(s.db, s.nats, s.wsHub, s.jetstream)
 	webhookService := service.NewWebhookService(s.db)
+	commandService := service.NewCommandService(s.db, s.nats)
 	s.alarmService = alarmService
 
 	// Initialize handlers
@@ -79,6 +80,14 @@ func (s *Server) Setup() {
 	geofenceHandler := handler.NewGeofenceHandler(geofenceService)
 	alarmHandler := handler.NewAlarmHandler(s.db, alarmService)
 	webhookHandler := handler.NewWebhookHandler(s.db, webhookService)
+	jt808Handler := handler.NewJT808ExtendedHandler(s.db, commandService)
+
+	// Mirror devices into the registry the gateway checks terminal registration against
+	go func() {
+		if err := deviceService.SyncAllRegistry(context.Background()); err != nil {
+			log.Printf("[Server] Failed to sync device registry: %v", err)
+		}
+	}()
 
 	// Start WebSocket hub in background
 	go s.wsHub.Run()
@@ -203,6 +212,9 @@ func (s *Server) Setup() {
 
 		// Webhooks
 		webhookHandler.RegisterRoutes(api)
+
+		// JT808 extended commands
+		jt808Handler.RegisterRoutes(api)
 	}
 }
 
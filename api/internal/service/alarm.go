@@ -51,6 +51,11 @@ func (s *AlarmService) Start() error {
 		}
 	}
 
+	// 订阅终端上报的报警 (JT808 报警标志位等)
+	if _, err := s.natsConn.Subscribe("fms.uplink.ALARM", s.handleDeviceAlarm); err != nil {
+		return fmt.Errorf("subscribe fms.uplink.ALARM failed: %w", err)
+	}
+
 	// 启动 JetStream 消费者（如果启用）
 	if s.jetstream != nil && s.jetstream.IsEnabled() {
 		ctx := context.Background()
@@ -69,25 +74,70 @@ func (s *AlarmService) Start() error {
 	return nil
 }
 
+// alarmMessage fms.alarm.* 报警消息
+type alarmMessage struct {
+	Type         model.AlarmType `json:"type"`
+	DeviceID     string          `json:"device_id"`
+	DeviceName   string          `json:"device_name"`
+	Lat          float64         `json:"lat"`
+	Lon          float64         `json:"lon"`
+	Speed        int16           `json:"speed"`
+	GeofenceID   int             `json:"geofence_id"`
+	GeofenceName string          `json:"geofence_name"`
+	Extras       json.RawMessage `json:"extras"`
+}
+
 // handleAlarmMessage 处理报警消息 (NATS Core)
 func (s *AlarmService) handleAlarmMessage(msg *nats.Msg) {
-	var alarmMsg struct {
-		Type         model.AlarmType `json:"type"`
-		DeviceID     string          `json:"device_id"`
-		DeviceName   string          `json:"device_name"`
-		Lat          float64         `json:"lat"`
-		Lon          float64         `json:"lon"`
-		Speed        int16           `json:"speed"`
-		GeofenceID   int             `json:"geofence_id"`
-		GeofenceName string          `json:"geofence_name"`
-		Extras       json.RawMessage `json:"extras"`
-	}
-
+	var alarmMsg alarmMessage
 	if err := json.Unmarshal(msg.Data, &alarmMsg); err != nil {
 		fmt.Printf("parse alarm message error: %v\n", err)
 		return
 	}
 
+	s.createAlarm(alarmMsg)
+}
+
+// handleDeviceAlarm 处理网关上报的终端报警 (fms.uplink.ALARM)，
+// 仅在报警产生 (rising) 时创建报警记录
+func (s *AlarmService) handleDeviceAlarm(msg *nats.Msg) {
+	var uplink struct {
+		DeviceID string                 `json:"device_id"`
+		Lat      float64                `json:"lat"`
+		Lon      float64                `json:"lon"`
+		Speed    float64                `json:"speed"`
+		Extras   map[string]interface{} `json:"extras"`
+	}
+	if err := json.Unmarshal(msg.Data, &uplink); err != nil {
+		fmt.Printf("parse device alarm error: %v\n", err)
+		return
+	}
+
+	alarmType, _ := uplink.Extras["alarm_type"].(string)
+	if edge, _ := uplink.Extras["alarm_edge"].(string); alarmType == "" || edge != "rising" {
+		return
+	}
+
+	var deviceName string
+	var device model.Device
+	if err := s.db.Where("device_id = ?", uplink.DeviceID).First(&device).Error; err == nil {
+		deviceName = device.Name
+	}
+
+	extras, _ := json.Marshal(uplink.Extras)
+	s.createAlarm(alarmMessage{
+		Type:       model.AlarmType(alarmType),
+		DeviceID:   uplink.DeviceID,
+		DeviceName: deviceName,
+		Lat:        uplink.Lat,
+		Lon:        uplink.Lon,
+		Speed:      int16(uplink.Speed),
+		Extras:     extras,
+	})
+}
+
+// createAlarm 创建报警记录并推送通知
+func (s *AlarmService) createAlarm(alarmMsg alarmMessage) {
 	// 检查是否需要静默
 	if s.isSilenced(alarmMsg.DeviceID, alarmMsg.Type) {
 		return
@@ -290,10 +340,18 @@ func (s *AlarmService) isRuleEnabled(alarmType model.AlarmType, deviceID string)
 // getAlarmLevel 获取报警级别
 func (s *AlarmService) getAlarmLevel(alarmType model.AlarmType) model.AlarmLevel {
 	switch alarmType {
-	case model.AlarmTypeSOS, model.AlarmTypeOverspeed, model.AlarmTypeIllegalMove:
+	case model.AlarmTypeSOS, model.AlarmTypeOverspeed, model.AlarmTypeIllegalMove,
+		model.AlarmTypeCollision, model.AlarmTypeRollover, model.AlarmTypeTheft,
+		model.AlarmTypeIllegalIgnition, model.AlarmTypeIllegalDoorOpen,
+		model.AlarmTypeFatigueDriving, model.AlarmTypeDangerWarning:
 		return model.AlarmLevelCritical
 	case model.AlarmTypeGeofenceEnter, model.AlarmTypeGeofenceExit, 
-		 model.AlarmTypeLowBattery, model.AlarmTypePowerCut:
+		 model.AlarmTypeLowBattery, model.AlarmTypePowerCut,
+		model.AlarmTypePowerUndervoltage, model.AlarmTypeGNSSAntennaCut,
+		model.AlarmTypeGNSSAntennaShort, model.AlarmTypeAreaInOut,
+		model.AlarmTypeRouteInOut, model.AlarmTypeRouteDeviation,
+		model.AlarmTypeRouteDrivingTime, model.AlarmTypeDrivingTimeout,
+		model.AlarmTypeParkingTimeout, model.AlarmTypeFuelAbnormal:
 		return model.AlarmLevelWarning
 	default:
 		return model.AlarmLevelInfo
@@ -312,6 +370,32 @@ func (s *AlarmService) getAlarmTitle(alarmType model.AlarmType) string {
 		model.AlarmTypePowerCut:      "断电报警",
 		model.AlarmTypeVibration:     "震动报警",
 		model.AlarmTypeIllegalMove:   "非法移动",
+
+		model.AlarmTypeFatigueDriving:    "疲劳驾驶",
+		model.AlarmTypeDangerWarning:     "危险预警",
+		model.AlarmTypeGNSSFault:         "GNSS模块故障",
+		model.AlarmTypeGNSSAntennaCut:    "GNSS天线未接或被剪断",
+		model.AlarmTypeGNSSAntennaShort:  "GNSS天线短路",
+		model.AlarmTypePowerUndervoltage: "主电源欠压",
+		model.AlarmTypeLCDFault:          "LCD故障",
+		model.AlarmTypeTTSFault:          "TTS故障",
+		model.AlarmTypeCameraFault:       "摄像头故障",
+		model.AlarmTypeICCardFault:       "IC卡模块故障",
+		model.AlarmTypeOverspeedWarning:  "超速预警",
+		model.AlarmTypeFatigueWarning:    "疲劳驾驶预警",
+		model.AlarmTypeDrivingTimeout:    "当天累计驾驶超时",
+		model.AlarmTypeParkingTimeout:    "超时停车",
+		model.AlarmTypeAreaInOut:         "进出区域",
+		model.AlarmTypeRouteInOut:        "进出路线",
+		model.AlarmTypeRouteDrivingTime:  "路段行驶时间不足/过长",
+		model.AlarmTypeRouteDeviation:    "路线偏离",
+		model.AlarmTypeVSSFault:          "VSS故障",
+		model.AlarmTypeFuelAbnormal:      "油量异常",
+		model.AlarmTypeTheft:             "车辆被盗",
+		model.AlarmTypeIllegalIgnition:   "非法点火",
+		model.AlarmTypeCollision:         "碰撞预警",
+		model.AlarmTypeRollover:          "侧翻预警",
+		model.AlarmTypeIllegalDoorOpen:   "非法开门",
 	}
 	if title, ok := titles[alarmType]; ok {
 		return title
@@ -320,17 +404,7 @@ func (s *AlarmService) getAlarmTitle(alarmType model.AlarmType) string {
 }
 
 // getAlarmContent 获取报警内容
-func (s *AlarmService) getAlarmContent(msg struct {
-	Type         model.AlarmType `json:"type"`
-	DeviceID     string          `json:"device_id"`
-	DeviceName   string          `json:"device_name"`
-	Lat          float64         `json:"lat"`
-	Lon          float64         `json:"lon"`
-	Speed        int16           `json:"speed"`
-	GeofenceID   int             `json:"geofence_id"`
-	GeofenceName string          `json:"geofence_name"`
-	Extras       json.RawMessage `json:"extras"`
-}) string {
+func (s *AlarmService) getAlarmContent(msg alarmMessage) string {
 	switch msg.Type {
 	case model.AlarmTypeGeofenceEnter:
 		return fmt.Sprintf("车辆 %s 进入围栏 %s", msg.DeviceName, msg.GeofenceName)
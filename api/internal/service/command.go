@@ -1,4 +1,4 @@
-// 指令服务 - 支持超时处理、批量下发、历史记录
+// 指令服务 - 支持超时处理、批量下发、历史记录、离线排队
 
 package service
 
@@ -6,6 +6,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log"
 	"sync"
 	"time"
 
@@ -15,10 +16,20 @@ import (
 	"openfms/api/internal/model"
 )
 
+const (
+	// DefaultCommandQueueTTL 离线指令默认保留时间
+	DefaultCommandQueueTTL = 24 * time.Hour
+	// 过期排队指令的清理周期
+	commandQueueSweepInterval = time.Minute
+	// 多个API实例只由一个处理上线补发和网关排队请求
+	commandQueueGroup = "api-command-queue"
+)
+
 // CommandService 指令服务
 type CommandService struct {
 	db       *gorm.DB
 	natsConn *nats.Conn
+	queueTTL time.Duration
 	
 	// 待响应指令池
 	pendingCommands map[string]*PendingCommand
@@ -37,13 +48,29 @@ type PendingCommand struct {
 	Status     string // pending, success, timeout, error
 }
 
-// CommandResponse 指令响应
+// CommandResponse 指令响应 (网关发布在 device.<id>.command.response)
 type CommandResponse struct {
-	DeviceID  string
-	CommandID string
-	Success   bool
-	Data      map[string]interface{}
-	Error     string
+	DeviceID  string                 `json:"device_id"`
+	CommandID string                 `json:"command_id"`
+	Success   bool                   `json:"success"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	Error     string                 `json:"error,omitempty"`
+	Offline   bool                   `json:"offline,omitempty"` // 设备离线，未下发
+}
+
+// sessionEvent 网关在 fms.session.online / fms.session.offline 发布的会话事件
+type sessionEvent struct {
+	DeviceID  string `json:"device_id"`
+	GatewayID string `json:"gateway_id"`
+	ConnID    string `json:"conn_id"`
+}
+
+// queueRequest 网关HTTP接口收到离线设备指令时发布在 fms.command.queue
+type queueRequest struct {
+	CommandID string                 `json:"command_id,omitempty"` // 调用方指令ID，为空时生成
+	DeviceID  string                 `json:"device_id"`
+	Type      string                 `json:"type"`
+	Params    map[string]interface{} `json:"params,omitempty"`
 }
 
 // NewCommandService 创建指令服务
@@ -51,11 +78,15 @@ func NewCommandService(db *gorm.DB, natsConn *nats.Conn) *CommandService {
 	s := &CommandService{
 		db:              db,
 		natsConn:        natsConn,
+		queueTTL:        DefaultCommandQueueTTL,
 		pendingCommands: make(map[string]*PendingCommand),
 	}
 	
 	// 启动响应监听
 	go s.startResponseListener()
+	// 离线队列：设备上线补发、网关排队请求、过期清理
+	go s.startQueueListener()
+	go s.startQueueSweeper()
 	
 	return s
 }
@@ -80,13 +111,19 @@ func (s *CommandService) SendCommand(ctx context.Context, deviceID, command stri
 	s.mu.Lock()
 	s.pendingCommands[cmdID] = pending
 	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pendingCommands, cmdID)
+		s.mu.Unlock()
+	}()
 	
 	// 保存到数据库
 	cmdRecord := model.DeviceCommand{
-		DeviceID: deviceID,
-		Command:  command,
-		Params:   mustJSON(params),
-		Status:   "pending",
+		CommandID: cmdID,
+		DeviceID:  deviceID,
+		Command:   command,
+		Params:    mustJSON(params),
+		Status:    "pending",
 	}
 	s.db.Create(&cmdRecord)
 	
@@ -110,24 +147,38 @@ func (s *CommandService) SendCommand(ctx context.Context, deviceID, command stri
 		})
 		return nil, err
 	}
+	// 网关可能已先报告离线并转为排队，只更新仍在等待下发的记录
+	s.db.Model(&model.DeviceCommand{}).
+		Where("command_id = ? AND status = ?", cmdID, "pending").
+		Updates(map[string]interface{}{
+			"status":     "sent",
+			"updated_at": time.Now(),
+		})
 	
 	// 等待响应或超时
 	select {
 	case resp := <-pending.Response:
-		pending.Status = "success"
-		s.db.Model(&cmdRecord).Updates(map[string]interface{}{
-			"status":     "success",
-			"response":   mustJSON(resp.Data),
-			"updated_at": time.Now(),
-		})
+		switch {
+		case resp.Offline:
+			// 设备离线，指令已由 recordResponse 转入队列，上线后补发
+			pending.Status = "queued"
+			resp.Error = "device offline, command queued"
+		case resp.Success:
+			pending.Status = "success"
+		default:
+			pending.Status = "error"
+		}
 		return resp, nil
 		
 	case <-time.After(timeout):
 		pending.Status = "timeout"
-		s.db.Model(&cmdRecord).Updates(map[string]interface{}{
-			"status":     "timeout",
-			"updated_at": time.Now(),
-		})
+		// 已排队或已有结果的指令不标记超时
+		s.db.Model(&model.DeviceCommand{}).
+			Where("command_id = ? AND status IN ?", cmdID, []string{"pending", "sent"}).
+			Updates(map[string]interface{}{
+				"status":     "timeout",
+				"updated_at": time.Now(),
+			})
 		return nil, fmt.Errorf("command timeout after %v", timeout)
 		
 	case <-ctx.Done():
@@ -140,12 +191,13 @@ func (s *CommandService) SendCommand(ctx context.Context, deviceID, command stri
 func (s *CommandService) SendCommandAsync(deviceID, command string, params map[string]interface{}) (string, error) {
 	cmdID := fmt.Sprintf("%s_%d", deviceID, time.Now().UnixNano())
 	
-	// 保存到数据库
+	// 保存到数据库，响应由 startResponseListener 按 command_id 回写
 	cmdRecord := model.DeviceCommand{
-		DeviceID: deviceID,
-		Command:  command,
-		Params:   mustJSON(params),
-		Status:   "sent",
+		CommandID: cmdID,
+		DeviceID:  deviceID,
+		Command:   command,
+		Params:    mustJSON(params),
+		Status:    "sent",
 	}
 	s.db.Create(&cmdRecord)
 	
@@ -200,6 +252,37 @@ func (s *CommandService) BatchSendCommand(deviceIDs []string, command string, pa
 	return results
 }
 
+// SetQueueTTL 设置离线指令保留时间
+func (s *CommandService) SetQueueTTL(ttl time.Duration) {
+	if ttl > 0 {
+		s.queueTTL = ttl
+	}
+}
+
+// QueueCommand 直接将指令加入离线队列，设备上线后按顺序下发
+func (s *CommandService) QueueCommand(deviceID, command string, params map[string]interface{}) (string, error) {
+	cmdID := fmt.Sprintf("%s_%d", deviceID, time.Now().UnixNano())
+	if err := s.queueCommand(cmdID, deviceID, command, params); err != nil {
+		return "", err
+	}
+	return cmdID, nil
+}
+
+// queueCommand 以给定ID创建排队指令
+func (s *CommandService) queueCommand(cmdID, deviceID, command string, params map[string]interface{}) error {
+	expiresAt := time.Now().Add(s.queueTTL)
+	
+	cmdRecord := model.DeviceCommand{
+		CommandID: cmdID,
+		DeviceID:  deviceID,
+		Command:   command,
+		Params:    mustJSON(params),
+		Status:    "queued",
+		ExpiresAt: &expiresAt,
+	}
+	return s.db.Create(&cmdRecord).Error
+}
+
 // GetCommandHistory 获取指令历史
 func (s *CommandService) GetCommandHistory(deviceID string, limit int) ([]model.DeviceCommand, error) {
 	var commands []model.DeviceCommand
@@ -213,12 +296,15 @@ func (s *CommandService) GetCommandHistory(deviceID string, limit int) ([]model.
 
 // startResponseListener 启动响应监听
 func (s *CommandService) startResponseListener() {
-	s.natsConn.Subscribe("device.>.command.response", func(msg *nats.Msg) {
+	s.natsConn.Subscribe("device.*.command.response", func(msg *nats.Msg) {
 		var resp CommandResponse
-		if err := json.Unmarshal(msg.Data, &resp); err != nil {
+		if err := json.Unmarshal(msg.Data, &resp); err != nil || resp.CommandID == "" {
 			return
 		}
 		
+		// 更新指令记录（同步和异步指令均适用）
+		s.recordResponse(&resp)
+		
 		s.mu.RLock()
 		pending, exists := s.pendingCommands[resp.CommandID]
 		s.mu.RUnlock()
@@ -232,6 +318,143 @@ func (s *CommandService) startResponseListener() {
 	})
 }
 
+// recordResponse 根据网关响应更新指令状态
+func (s *CommandService) recordResponse(resp *CommandResponse) {
+	if resp.Offline {
+		s.queueOffline(resp.CommandID)
+		return
+	}
+	
+	updates := map[string]interface{}{
+		"status":     "success",
+		"response":   mustJSON(resp.Data),
+		"updated_at": time.Now(),
+	}
+	if !resp.Success {
+		updates["status"] = "failed"
+		updates["error_msg"] = resp.Error
+	}
+	
+	// 只更新仍在等待结果的指令，避免覆盖已超时的记录
+	s.db.Model(&model.DeviceCommand{}).
+		Where("command_id = ? AND status IN ?", resp.CommandID, []string{"pending", "sent"}).
+		Updates(updates)
+}
+
+// queueOffline 网关报告设备离线时将指令转为排队状态。
+// 补发后再次离线的指令保留原过期时间。
+func (s *CommandService) queueOffline(commandID string) {
+	s.db.Model(&model.DeviceCommand{}).
+		Where("command_id = ? AND status IN ? AND expires_at IS NULL", commandID, []string{"pending", "sent"}).
+		Update("expires_at", time.Now().Add(s.queueTTL))
+	s.db.Model(&model.DeviceCommand{}).
+		Where("command_id = ? AND status IN ?", commandID, []string{"pending", "sent"}).
+		Updates(map[string]interface{}{
+			"status":     "queued",
+			"error_msg":  "device offline",
+			"updated_at": time.Now(),
+		})
+}
+
+// startQueueListener 监听设备上线事件和网关排队请求
+func (s *CommandService) startQueueListener() {
+	s.natsConn.QueueSubscribe("fms.session.online", commandQueueGroup, func(msg *nats.Msg) {
+		var event sessionEvent
+		if err := json.Unmarshal(msg.Data, &event); err != nil || event.DeviceID == "" {
+			return
+		}
+		s.flushQueue(event.DeviceID, event.GatewayID)
+	})
+	
+	s.natsConn.QueueSubscribe("fms.command.queue", commandQueueGroup, func(msg *nats.Msg) {
+		var req queueRequest
+		if err := json.Unmarshal(msg.Data, &req); err != nil || req.DeviceID == "" || req.Type == "" {
+			return
+		}
+		if req.CommandID == "" {
+			if _, err := s.QueueCommand(req.DeviceID, req.Type, req.Params); err != nil {
+				log.Printf("Failed to queue command %s for %s: %v", req.Type, req.DeviceID, err)
+			}
+			return
+		}
+		// 本服务下发的指令已由离线响应转入队列
+		var count int64
+		s.db.Model(&model.DeviceCommand{}).Where("command_id = ?", req.CommandID).Count(&count)
+		if count > 0 {
+			return
+		}
+		if err := s.queueCommand(req.CommandID, req.DeviceID, req.Type, req.Params); err != nil {
+			log.Printf("Failed to queue command %s for %s: %v", req.CommandID, req.DeviceID, err)
+		}
+	})
+}
+
+// flushQueue 设备上线后按创建顺序补发排队指令。
+// 直接发往持有连接的网关节点，保证同一设备的指令顺序。
+func (s *CommandService) flushQueue(deviceID, gatewayID string) {
+	var commands []model.DeviceCommand
+	if err := s.db.Where("device_id = ? AND status = ?", deviceID, "queued").
+		Order("id ASC").Find(&commands).Error; err != nil {
+		log.Printf("Failed to load queued commands for %s: %v", deviceID, err)
+		return
+	}
+	
+	subject := fmt.Sprintf("device.%s.command", deviceID)
+	if gatewayID != "" {
+		subject = fmt.Sprintf("gateway.downlink.%s", gatewayID)
+	}
+	
+	for _, cmd := range commands {
+		now := time.Now()
+		if cmd.ExpiresAt != nil && now.After(*cmd.ExpiresAt) {
+			s.db.Model(&model.DeviceCommand{}).
+				Where("id = ? AND status = ?", cmd.ID, "queued").
+				Updates(map[string]interface{}{"status": "expired", "updated_at": now})
+			continue
+		}
+		
+		// 条件更新抢占指令，避免多次上线事件重复下发
+		res := s.db.Model(&model.DeviceCommand{}).
+			Where("id = ? AND status = ?", cmd.ID, "queued").
+			Updates(map[string]interface{}{"status": "sent", "sent_at": now, "updated_at": now})
+		if res.Error != nil || res.RowsAffected == 0 {
+			continue
+		}
+		
+		var params map[string]interface{}
+		if cmd.Params != "" {
+			json.Unmarshal([]byte(cmd.Params), &params)
+		}
+		msgData, _ := json.Marshal(map[string]interface{}{
+			"command_id": cmd.CommandID,
+			"device_id":  deviceID,
+			"command":    cmd.Command,
+			"params":     params,
+			"timestamp":  now.Unix(),
+		})
+		if err := s.natsConn.Publish(subject, msgData); err != nil {
+			// 保持排队，等待下次上线
+			s.db.Model(&model.DeviceCommand{}).Where("id = ?", cmd.ID).
+				Updates(map[string]interface{}{"status": "queued", "updated_at": time.Now()})
+			log.Printf("Failed to flush queued command %s: %v", cmd.CommandID, err)
+			return
+		}
+	}
+}
+
+// startQueueSweeper 定期将超过有效期的排队指令标记为过期
+func (s *CommandService) startQueueSweeper() {
+	ticker := time.NewTicker(commandQueueSweepInterval)
+	defer ticker.Stop()
+	
+	for range ticker.C {
+		now := time.Now()
+		s.db.Model(&model.DeviceCommand{}).
+			Where("status = ? AND expires_at < ?", "queued", now).
+			Updates(map[string]interface{}{"status": "expired", "updated_at": now})
+	}
+}
+
 // mustJSON 转换为JSON
 func mustJSON(v interface{}) string {
 	data, _ := json.Marshal(v)
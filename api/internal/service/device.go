@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/nats-io/nats.go"
 	"github.com/redis/go-redis/v9"
@@ -57,17 +58,67 @@ func (s *DeviceService) GetByID(ctx context.Context, id uint) (*model.Device, er
 
 // Create creates a new device
 func (s *DeviceService) Create(ctx context.Context, device *model.Device) error {
-	return s.db.Create(device).Error
+	if err := s.db.Create(device).Error; err != nil {
+		return err
+	}
+	return s.SyncRegistry(ctx, device)
 }
 
 // Update updates a device
 func (s *DeviceService) Update(ctx context.Context, device *model.Device) error {
-	return s.db.Save(device).Error
+	if err := s.db.Save(device).Error; err != nil {
+		return err
+	}
+	return s.SyncRegistry(ctx, device)
 }
 
 // Delete deletes a device
 func (s *DeviceService) Delete(ctx context.Context, id uint) error {
-	return s.db.Delete(&model.Device{}, id).Error
+	var device model.Device
+	if err := s.db.First(&device, id).Error; err != nil {
+		return err
+	}
+	if err := s.db.Delete(&model.Device{}, id).Error; err != nil {
+		return err
+	}
+	return s.removeRegistry(ctx, device.DeviceID)
+}
+
+// SyncRegistry mirrors a device into the Redis device registry
+// (fms:device:<device_id>) the gateway checks terminal registration against.
+// The auth code issued by the gateway is kept.
+func (s *DeviceService) SyncRegistry(ctx context.Context, device *model.Device) error {
+	key := fmt.Sprintf("fms:device:%s", device.DeviceID)
+	return s.redis.HSet(ctx, key,
+		"name", device.Name,
+		"protocol", device.Protocol,
+		"status", device.Status,
+	).Err()
+}
+
+// SyncAllRegistry mirrors all devices into the Redis device registry
+func (s *DeviceService) SyncAllRegistry(ctx context.Context) error {
+	var devices []model.Device
+	if err := s.db.Find(&devices).Error; err != nil {
+		return err
+	}
+	for i := range devices {
+		if err := s.SyncRegistry(ctx, &devices[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeRegistry drops a deleted device, its plate binding and the alarm
+// flags the gateway keeps for it from the registry
+func (s *DeviceService) removeRegistry(ctx context.Context, deviceID string) error {
+	key := fmt.Sprintf("fms:device:%s", deviceID)
+	if plate, err := s.redis.HGet(ctx, key, "plate_number").Result(); err == nil && plate != "" {
+		s.redis.Del(ctx, fmt.Sprintf("fms:plate:%s", plate))
+	}
+	s.redis.Del(ctx, fmt.Sprintf("fms:alarm:%s", deviceID))
+	return s.redis.Del(ctx, key).Err()
 }
 
 // GetShadow returns device shadow from Redis
@@ -98,8 +149,7 @@ func (s *DeviceService) SendCommand(ctx context.Context, deviceID, cmdType strin
 	}
 
 	// Parse gateway ID from session info (format: gateway_id:conn_id:client_ip)
-	var gatewayID string
-	fmt.Sscanf(gatewayInfo, "%s:", &gatewayID)
+	gatewayID := strings.SplitN(gatewayInfo, ":", 2)[0]
 
 	// Publish command to NATS
 	cmd := map[string]interface{}{
@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"log"
 	"strconv"
 	"sync"
 	"time"
@@ -291,6 +292,7 @@ func (s *DeviceImportService) processImport(ctx context.Context, taskID string,
 		batch := rows[i:end]
 
 		// 使用事务处理每一批
+		var created []*model.Device
 		err := s.db.Transaction(func(tx *gorm.DB) error {
 			for _, row := range batch {
 				// 如果有验证错误，跳过
@@ -328,11 +330,21 @@ func (s *DeviceImportService) processImport(ctx context.Context, taskID string,
 					continue
 				}
 
+				created = append(created, device)
 				successCount++
 			}
 			return nil
 		})
 
+		// 同步到网关的设备注册表
+		if err == nil {
+			for _, device := range created {
+				if syncErr := s.deviceService.SyncRegistry(ctx, device); syncErr != nil {
+					log.Printf("[DeviceImport] Failed to sync device %s to registry: %v", device.DeviceID, syncErr)
+				}
+			}
+		}
+
 		if err != nil {
 			// 事务错误，记录到错误中
 			for _, row := range batch {
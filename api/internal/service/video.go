@@ -59,9 +59,10 @@ func (s *VideoService) StartRealtimeVideo(deviceID string, channel int, userID i
 
 	// 发送指令到设备 (通过 NATS)
 	cmd := model.JT1078Command{
-		DeviceID: deviceID,
-		Channel:  channel,
-		Command:  "play",
+		CommandID: newVideoCommandID(deviceID),
+		DeviceID:  deviceID,
+		Channel:   channel,
+		Command:   "play",
 		Params: map[string]interface{}{
 			"stream_id": stream.ID,
 			"media_ip":  s.getMediaServerIP(),
@@ -100,9 +101,10 @@ func (s *VideoService) StopVideo(streamID int) error {
 
 	// 发送停止指令
 	cmd := model.JT1078Command{
-		DeviceID: stream.DeviceID,
-		Channel:  stream.Channel,
-		Command:  "stop",
+		CommandID: newVideoCommandID(stream.DeviceID),
+		DeviceID:  stream.DeviceID,
+		Channel:   stream.Channel,
+		Command:   "stop",
 	}
 	cmdData, _ := json.Marshal(cmd)
 	
@@ -118,6 +120,11 @@ func (s *VideoService) StopVideo(streamID int) error {
 	return nil
 }
 
+// newVideoCommandID 生成视频指令ID，网关以此ID发布指令结果
+func newVideoCommandID(deviceID string) string {
+	return fmt.Sprintf("%s_%d", deviceID, time.Now().UnixNano())
+}
+
 // GetStreamStatus 获取流状态
 func (s *VideoService) GetStreamStatus(streamID int) (*model.VideoStreamResponse, error) {
 	var stream model.VideoStream
@@ -169,9 +176,10 @@ func (s *VideoService) StartPlayback(deviceID string, channel int, startTime, en
 
 	// 发送回放指令
 	cmd := model.JT1078Command{
-		DeviceID: deviceID,
-		Channel:  channel,
-		Command:  "playback",
+		CommandID: newVideoCommandID(deviceID),
+		DeviceID:  deviceID,
+		Channel:   channel,
+		Command:   "playback",
 		Params: map[string]interface{}{
 			"stream_id":  stream.ID,
 			"media_ip":   s.getMediaServerIP(),
@@ -202,10 +210,11 @@ func (s *VideoService) ControlPlayback(streamID int, action string, params map[s
 	}
 
 	cmd := model.JT1078Command{
-		DeviceID: stream.DeviceID,
-		Channel:  stream.Channel,
-		Command:  action, // pause, resume, speed, seek
-		Params:   params,
+		CommandID: newVideoCommandID(stream.DeviceID),
+		DeviceID:  stream.DeviceID,
+		Channel:   stream.Channel,
+		Command:   action, // pause, resume, speed, seek
+		Params:    params,
 	}
 	cmdData, _ := json.Marshal(cmd)
 	
@@ -0,0 +1,41 @@
+-- JT808 终端报警类型
+-- 创建于: 2026-10-16
+
+-- 报警类型枚举：JT808 位置信息报警标志位
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'FATIGUE_DRIVING';     -- 疲劳驾驶
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'DANGER_WARNING';      -- 危险预警
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'GNSS_FAULT';          -- GNSS模块故障
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'GNSS_ANTENNA_CUT';    -- GNSS天线未接或被剪断
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'GNSS_ANTENNA_SHORT';  -- GNSS天线短路
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'POWER_UNDERVOLTAGE';  -- 主电源欠压
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'LCD_FAULT';           -- LCD故障
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'TTS_FAULT';           -- TTS故障
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'CAMERA_FAULT';        -- 摄像头故障
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'IC_CARD_FAULT';       -- IC卡模块故障
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'OVERSPEED_WARNING';   -- 超速预警
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'FATIGUE_WARNING';     -- 疲劳驾驶预警
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'DRIVING_TIMEOUT';     -- 当天累计驾驶超时
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'PARKING_TIMEOUT';     -- 超时停车
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'AREA_IN_OUT';         -- 进出区域
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'ROUTE_IN_OUT';        -- 进出路线
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'ROUTE_DRIVING_TIME';  -- 路段行驶时间不足/过长
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'ROUTE_DEVIATION';     -- 路线偏离
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'VSS_FAULT';           -- VSS故障
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'FUEL_ABNORMAL';       -- 油量异常
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'THEFT';               -- 车辆被盗
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'ILLEGAL_IGNITION';    -- 非法点火
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'COLLISION';           -- 碰撞预警
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'ROLLOVER';            -- 侧翻预警
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'ILLEGAL_DOOR_OPEN';   -- 非法开门
+
+-- 默认报警规则
+
+INSERT INTO alarm_rules (name, type, description, conditions, all_devices, enabled) VALUES
+    ('疲劳驾驶', 'FATIGUE_DRIVING', '终端上报疲劳驾驶报警', '{}', true, true),
+    ('主电源欠压', 'POWER_UNDERVOLTAGE', '终端上报主电源欠压', '{}', true, true),
+    ('GNSS天线断开', 'GNSS_ANTENNA_CUT', '终端上报GNSS天线未接或被剪断', '{}', true, true),
+    ('碰撞预警', 'COLLISION', '终端上报碰撞预警', '{}', true, true),
+    ('侧翻预警', 'ROLLOVER', '终端上报侧翻预警', '{}', true, true),
+    ('非法点火', 'ILLEGAL_IGNITION', '终端上报车辆非法点火', '{}', true, true),
+    ('非法位移', 'ILLEGAL_MOVE', '终端上报车辆非法位移', '{}', true, true),
+    ('车辆被盗', 'THEFT', '终端上报车辆被盗', '{}', true, true);
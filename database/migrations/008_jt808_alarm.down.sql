@@ -0,0 +1,2 @@
+-- OpenFMS Database Migration Down: 008_jt808_alarm
+-- PostgreSQL cannot drop enum values; the added alarm types are kept
@@ -0,0 +1,31 @@
+-- OpenFMS JT808 Alarm Types
+-- Migration: 008_jt808_alarm
+
+-- ============================================
+-- Alarm Type Enum: JT808 location alarm flag bits
+-- ============================================
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'FATIGUE_DRIVING';     -- 疲劳驾驶
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'DANGER_WARNING';      -- 危险预警
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'GNSS_FAULT';          -- GNSS模块故障
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'GNSS_ANTENNA_CUT';    -- GNSS天线未接或被剪断
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'GNSS_ANTENNA_SHORT';  -- GNSS天线短路
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'POWER_UNDERVOLTAGE';  -- 主电源欠压
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'LCD_FAULT';           -- LCD故障
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'TTS_FAULT';           -- TTS故障
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'CAMERA_FAULT';        -- 摄像头故障
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'IC_CARD_FAULT';       -- IC卡模块故障
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'OVERSPEED_WARNING';   -- 超速预警
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'FATIGUE_WARNING';     -- 疲劳驾驶预警
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'DRIVING_TIMEOUT';     -- 当天累计驾驶超时
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'PARKING_TIMEOUT';     -- 超时停车
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'AREA_IN_OUT';         -- 进出区域
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'ROUTE_IN_OUT';        -- 进出路线
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'ROUTE_DRIVING_TIME';  -- 路段行驶时间不足/过长
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'ROUTE_DEVIATION';     -- 路线偏离
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'VSS_FAULT';           -- VSS故障
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'FUEL_ABNORMAL';       -- 油量异常
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'THEFT';               -- 车辆被盗
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'ILLEGAL_IGNITION';    -- 非法点火
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'COLLISION';           -- 碰撞预警
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'ROLLOVER';            -- 侧翻预警
+ALTER TYPE alarm_type ADD VALUE IF NOT EXISTS 'ILLEGAL_DOOR_OPEN';   -- 非法开门
@@ -0,0 +1,6 @@
+-- OpenFMS Database Migration Down: 009_jt808_alarm_rules
+
+DELETE FROM alarm_rules WHERE type IN (
+    'FATIGUE_DRIVING', 'POWER_UNDERVOLTAGE', 'GNSS_ANTENNA_CUT', 'COLLISION',
+    'ROLLOVER', 'ILLEGAL_IGNITION', 'ILLEGAL_MOVE', 'THEFT'
+) AND conditions = '{}';
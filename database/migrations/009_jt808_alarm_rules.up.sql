@@ -0,0 +1,16 @@
+-- OpenFMS JT808 Alarm Rules
+-- Migration: 009_jt808_alarm_rules
+-- Separate from 008: new enum values are usable only after their transaction commits
+
+-- ============================================
+-- Default Rules
+-- ============================================
+INSERT INTO alarm_rules (name, type, description, conditions, all_devices, enabled) VALUES
+    ('疲劳驾驶', 'FATIGUE_DRIVING', '终端上报疲劳驾驶报警', '{}', true, true),
+    ('主电源欠压', 'POWER_UNDERVOLTAGE', '终端上报主电源欠压', '{}', true, true),
+    ('GNSS天线断开', 'GNSS_ANTENNA_CUT', '终端上报GNSS天线未接或被剪断', '{}', true, true),
+    ('碰撞预警', 'COLLISION', '终端上报碰撞预警', '{}', true, true),
+    ('侧翻预警', 'ROLLOVER', '终端上报侧翻预警', '{}', true, true),
+    ('非法点火', 'ILLEGAL_IGNITION', '终端上报车辆非法点火', '{}', true, true),
+    ('非法位移', 'ILLEGAL_MOVE', '终端上报车辆非法位移', '{}', true, true),
+    ('车辆被盗', 'THEFT', '终端上报车辆被盗', '{}', true, true);
@@ -52,14 +52,21 @@ func main() {
 	}
 
 	log.Println("[Gateway] Server started successfully")
-	log.Printf("[Gateway] Listening on TCP port %d", cfg.GatewayPort)
 	log.Printf("[Gateway] HTTP API on port %d", cfg.HTTPPort)
 
 	// Wait for interrupt signal
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
-	<-sigChan
+	// SIGHUP reloads the TLS certificates and protocol scripts
+	for sig := <-sigChan; sig == syscall.SIGHUP; sig = <-sigChan {
+		if err := tcpServer.ReloadCertificates(); err != nil {
+			log.Printf("[Gateway] Failed to reload TLS certificates: %v", err)
+		}
+		if err := tcpServer.ReloadScripts(); err != nil {
+			log.Printf("[Gateway] Failed to reload protocol scripts: %v", err)
+		}
+	}
 	log.Println("[Gateway] Shutting down...")
 
 	tcpServer.Stop()
@@ -0,0 +1,117 @@
+package adapter
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"openfms/gateway/internal/protocol"
+)
+
+// DefaultMaxClockSkew is the device/receive time difference above which a
+// position is flagged with clock_skew_exceeded
+const DefaultMaxClockSkew = 5 * time.Minute
+
+// DeviceClock interprets the time reported by terminals
+type DeviceClock struct {
+	// Location is the timezone of the device clock; nil means UTC
+	Location *time.Location
+	// MaxSkew flags positions whose device time is further than this from
+	// the receive time; 0 disables the check
+	MaxSkew time.Duration
+}
+
+// UTCDeviceClock returns the clock of devices reporting UTC, such as GT06 and Wialon IPS
+func UTCDeviceClock() DeviceClock {
+	return DeviceClock{Location: time.UTC, MaxSkew: DefaultMaxClockSkew}
+}
+
+// ParseTimezone accepts an IANA name ("Asia/Shanghai") or a fixed offset
+// ("+08:00", "+8", "UTC+8", "GMT-05:30")
+func ParseTimezone(name string) (*time.Location, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return time.UTC, nil
+	}
+
+	offset := strings.TrimPrefix(strings.TrimPrefix(strings.ToUpper(name), "UTC"), "GMT")
+	if offset == "" {
+		return time.UTC, nil
+	}
+	if offset[0] == '+' || offset[0] == '-' {
+		sign := 1
+		if offset[0] == '-' {
+			sign = -1
+		}
+		hm := strings.SplitN(offset[1:], ":", 2)
+		hours, err := strconv.Atoi(hm[0])
+		if err != nil || hours > 14 {
+			return nil, fmt.Errorf("invalid timezone offset: %s", name)
+		}
+		minutes := 0
+		if len(hm) == 2 {
+			if minutes, err = strconv.Atoi(hm[1]); err != nil || minutes >= 60 {
+				return nil, fmt.Errorf("invalid timezone offset: %s", name)
+			}
+		}
+		return time.FixedZone(name, sign*(hours*3600+minutes*60)), nil
+	}
+
+	return time.LoadLocation(name)
+}
+
+func (c DeviceClock) location() *time.Location {
+	if c.Location == nil {
+		return time.UTC
+	}
+	return c.Location
+}
+
+// Date builds a device time from its calendar fields, rejecting the zeroed
+// or out-of-range values terminals send before their first fix
+func (c DeviceClock) Date(year, month, day, hour, minute, second int) (time.Time, error) {
+	if month < 1 || month > 12 || day < 1 || day > 31 ||
+		hour > 23 || minute > 59 || second > 59 {
+		return time.Time{}, errors.New("invalid device time")
+	}
+	t := time.Date(year, time.Month(month), day, hour, minute, second, 0, c.location())
+	if t.Day() != day {
+		return time.Time{}, errors.New("invalid device time")
+	}
+	return t, nil
+}
+
+// Stamp sets msg.Timestamp to the device time. The receive time stays in
+// Extras["received_at"] and the difference in Extras["clock_skew"] (seconds,
+// positive when the device is ahead). Old positions of blind area backfill
+// are not flagged, only ones ahead of the server.
+func (c DeviceClock) Stamp(msg *protocol.StandardMessage, deviceTime time.Time) {
+	received, ok := msg.Extras["received_at"].(int64)
+	if !ok {
+		received = time.Now().Unix()
+		msg.Extras["received_at"] = received
+	}
+
+	msg.Timestamp = deviceTime.Unix()
+	skew := msg.Timestamp - received
+	msg.Extras["clock_skew"] = skew
+
+	if c.MaxSkew <= 0 {
+		return
+	}
+	if backfill, _ := msg.Extras["blind_area"].(bool); backfill && skew < 0 {
+		return
+	}
+	if time.Duration(abs64(skew))*time.Second > c.MaxSkew {
+		msg.Extras["clock_skew_exceeded"] = true
+	}
+}
+
+func abs64(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
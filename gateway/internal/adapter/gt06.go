@@ -1,147 +1,591 @@
 // GT06 协议适配器
-// GT06 是常见的海外 GPS 设备协议
+// GT06 是常见的海外 GPS 设备协议 (Concox GT06/GT06N 等)
 
 package adapter
 
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"log"
+	"sync"
 	"time"
 
 	"openfms/gateway/internal/protocol"
 )
 
-// GT06Adapter GT06协议适配器
-type GT06Adapter struct{}
+// GT06 协议号
+const (
+	GT06MsgLogin         byte = 0x01 // 登录包
+	GT06MsgLocation      byte = 0x12 // GPS+LBS 定位包
+	GT06MsgStatus        byte = 0x13 // 状态信息包 (心跳)
+	GT06MsgStringReply   byte = 0x15 // 终端指令回复 (字符串)
+	GT06MsgAlarm         byte = 0x16 // GPS+LBS+状态 报警包
+	GT06MsgCommandReply  byte = 0x21 // 终端指令回复 (带编码类型)
+	GT06MsgGPSLBS        byte = 0x22 // GPS+LBS 定位包 (带 ACC/补传标志)
+	GT06MsgAlarmMulti    byte = 0x26 // 多围栏报警包
+	GT06MsgLBSMulti      byte = 0x28 // LBS 多基站定位包
+	GT06MsgOnlineCommand byte = 0x80 // 服务器在线指令
+	GT06MsgTimeRequest   byte = 0x8A // 校时包
+)
+
+// gt06Alarms 报警包中的报警类型
+var gt06Alarms = map[byte]string{
+	0x01: protocol.AlarmSOS,
+	0x02: protocol.AlarmPowerCut,
+	0x03: protocol.AlarmVibration,
+	0x04: protocol.AlarmAreaInOut, // 进围栏
+	0x05: protocol.AlarmAreaInOut, // 出围栏
+	0x06: protocol.AlarmOverspeed,
+	0x09: protocol.AlarmIllegalMove,
+	0x0E: protocol.AlarmPowerUndervoltage, // 外电低电
+	0x19: protocol.AlarmLowBattery,        // 内置电池低电
+}
+
+// gt06StatusAlarms 终端信息 bit3~bit5 的报警状态
+var gt06StatusAlarms = []string{"normal", "vibration", "power_cut", "low_battery", "sos"}
+
+// GT06Adapter GT06协议适配器，每个连接一个实例
+type GT06Adapter struct {
+	clock DeviceClock
+
+	mu          sync.Mutex
+	deviceID    string // 登录包中的终端ID，其余数据包不携带
+	write       func(packet []byte) error
+	serial      uint16                  // 平台下发序列号
+	outstanding map[uint32]gt06Outgoing // 服务器标志位 -> 待回复指令
+}
+
+func init() {
+	Register(Registration{
+		Name:   "GT06",
+		Detect: (&GT06Adapter{}).Match,
+		New: func(opts Options) protocol.ProtocolAdapter {
+			return NewGT06AdapterWithClock(opts.Clock)
+		},
+	})
+}
 
-// NewGT06Adapter 创建GT06适配器
+// NewGT06Adapter 创建GT06适配器，设备时间为 UTC
 func NewGT06Adapter() *GT06Adapter {
-	return &GT06Adapter{}
+	return NewGT06AdapterWithClock(UTCDeviceClock())
 }
 
-// Match 匹配GT06协议 (0x78 0x78 开头)
+// NewGT06AdapterWithClock 创建GT06适配器并指定设备时区
+func NewGT06AdapterWithClock(clock DeviceClock) *GT06Adapter {
+	return &GT06Adapter{clock: clock}
+}
+
+// SetWriter 实现 protocol.Outbound，用于应答登录、报警和校时
+func (a *GT06Adapter) SetWriter(write func(packet []byte) error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.write = write
+}
+
+// Match 匹配GT06协议 (0x78 0x78 或 0x79 0x79 开头)
 func (a *GT06Adapter) Match(header []byte) bool {
-	return len(header) >= 2 && header[0] == 0x78 && header[1] == 0x78
+	return len(header) >= 2 && header[1] == header[0] &&
+		(header[0] == 0x78 || header[0] == 0x79)
 }
 
-// Decode 解码GT06数据包
-func (a *GT06Adapter) Decode(packet []byte) (*protocol.StandardMessage, error) {
+// gt06Frame 校验后的GT06数据包
+type gt06Frame struct {
+	Protocol byte
+	Content  []byte
+	Serial   uint16
+}
+
+// parseGT06Frame 拆包并校验 CRC-ITU
+// 短包: 0x78 0x78 + 长度(1) + 协议号(1) + 内容(N) + 序列号(2) + 校验(2) + 0x0D 0x0A
+// 长包: 0x79 0x79 + 长度(2) + 协议号(1) + 内容(N) + 序列号(2) + 校验(2) + 0x0D 0x0A
+// 长度从协议号算到校验位，校验范围从长度到序列号
+func parseGT06Frame(packet []byte) (*gt06Frame, error) {
 	if len(packet) < 10 {
 		return nil, fmt.Errorf("packet too short")
 	}
 
-	// GT06 包结构: 0x78 0x78 + 长度(1) + 协议号(1) + 内容(N) + 序列号(2) + 校验(2) + 0x0D 0x0A
-	if packet[0] != 0x78 || packet[1] != 0x78 {
+	var length, header int
+	switch {
+	case packet[0] == 0x78 && packet[1] == 0x78:
+		length, header = int(packet[2]), 3
+	case packet[0] == 0x79 && packet[1] == 0x79:
+		length, header = int(binary.BigEndian.Uint16(packet[2:4])), 4
+	default:
 		return nil, fmt.Errorf("invalid header")
 	}
+	if length < 5 || len(packet) != header+length+2 {
+		return nil, fmt.Errorf("incomplete packet")
+	}
+
+	end := len(packet) - 2
+	checksum := binary.BigEndian.Uint16(packet[end-2 : end])
+	if calc := crcITU(packet[2 : end-2]); calc != checksum {
+		return nil, fmt.Errorf("checksum mismatch: expected %04X, got %04X", calc, checksum)
+	}
+	return &gt06Frame{
+		Protocol: packet[header],
+		Content:  packet[header+1 : end-4],
+		Serial:   binary.BigEndian.Uint16(packet[end-4 : end-2]),
+	}, nil
+}
+
+// buildGT06Packet 组装GT06数据包，内容超过短包长度时使用 0x79 0x79 长包
+func buildGT06Packet(protocolNum byte, content []byte, serial uint16) []byte {
+	length := 1 + len(content) + 4
+	var packet []byte
+	if length > 0xFF {
+		packet = []byte{0x79, 0x79, byte(length >> 8), byte(length)}
+	} else {
+		packet = []byte{0x78, 0x78, byte(length)}
+	}
+	packet = append(packet, protocolNum)
+	packet = append(packet, content...)
+	packet = binary.BigEndian.AppendUint16(packet, serial)
+	packet = binary.BigEndian.AppendUint16(packet, crcITU(packet[2:]))
+	return append(packet, 0x0D, 0x0A)
+}
+
+// crcITU 计算GT06使用的 CRC-ITU (CRC-16/X-25) 校验值
+func crcITU(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&0x0001 != 0 {
+				crc = crc>>1 ^ 0x8408
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return ^crc
+}
 
-	length := packet[2]
-	protocolNum := packet[3]
+// Decode 解码GT06数据包
+func (a *GT06Adapter) Decode(packet []byte) (*protocol.StandardMessage, error) {
+	frame, err := parseGT06Frame(packet)
+	if err != nil {
+		return nil, err
+	}
 
+	receivedAt := time.Now().Unix()
 	msg := &protocol.StandardMessage{
-		Timestamp: time.Now().Unix(),
+		DeviceID:  a.currentDeviceID(),
+		Timestamp: receivedAt,
 		Extras:    make(map[string]interface{}),
 	}
+	msg.Extras["received_at"] = receivedAt
+	msg.Extras["msg_serial"] = frame.Serial
 
-	switch protocolNum {
-	case 0x01: // 登录包
-		msg.Type = "AUTH"
-		msg.DeviceID = a.parseDeviceID(packet[4:12])
-
-	case 0x12: // 位置数据包
-		msg.Type = "LOCATION"
-		msg.DeviceID = a.parseDeviceID(packet[4:12])
-		
-		// 解析位置信息
-		if len(packet) >= 30 {
-			dateTime := packet[12:18]
-			msg.Timestamp = a.parseDateTime(dateTime)
-			
-			// GPS 信息
-			gpsLen := packet[18]
-			satellites := gpsLen & 0x0F
-			msg.Extras["satellites"] = satellites
-			
-			// 经纬度
-			lat := binary.BigEndian.Uint32(packet[19:23])
-			lon := binary.BigEndian.Uint32(packet[23:27])
-			
-			msg.Lat = float64(lat) / 30000.0 / 60.0
-			msg.Lon = float64(lon) / 30000.0 / 60.0
-			
-			// 速度
-			msg.Speed = float64(packet[27])
-			
-			// 方向
-			course := binary.BigEndian.Uint16(packet[28:30])
-			msg.Direction = float64(course & 0x3FF)
+	content := frame.Content
+	switch frame.Protocol {
+	case GT06MsgLogin:
+		msg.Type = protocol.MsgTypeAuth
+		if err := a.parseLogin(content, msg); err != nil {
+			return nil, err
 		}
+		a.reply(GT06MsgLogin, nil, frame.Serial)
 
-	case 0x13: // 心跳包
-		msg.Type = "HEARTBEAT"
-		msg.DeviceID = a.parseDeviceID(packet[4:12])
+	case GT06MsgLocation, GT06MsgGPSLBS:
+		msg.Type = protocol.MsgTypeLocation
+		if err := a.parseLocation(frame.Protocol, content, msg); err != nil {
+			return nil, err
+		}
+
+	case GT06MsgStatus: // 心跳，由网关通过 GenerateHeartbeatAck 应答
+		msg.Type = protocol.MsgTypeHeartbeat
+		if len(content) < 3 {
+			return nil, fmt.Errorf("status packet too short")
+		}
+		parseGT06Status(content, msg.Extras)
+
+	case GT06MsgAlarm, GT06MsgAlarmMulti:
+		msg.Type = protocol.MsgTypeLocation
+		if err := a.parseAlarm(frame.Protocol, content, msg); err != nil {
+			return nil, err
+		}
+		a.reply(frame.Protocol, nil, frame.Serial)
+
+	case GT06MsgLBSMulti:
+		msg.Type = protocol.MsgTypeLBS
+		if err := a.parseLBSMulti(content, msg); err != nil {
+			return nil, err
+		}
+
+	case GT06MsgStringReply, GT06MsgCommandReply:
+		msg.Type = protocol.MsgTypeCommandResponse
+		if err := a.parseCommandReply(frame.Protocol, content, msg); err != nil {
+			return nil, err
+		}
+
+	case GT06MsgTimeRequest:
+		msg.Type = "TIME_REQUEST"
+		now := time.Now().UTC()
+		a.reply(GT06MsgTimeRequest, []byte{
+			byte(now.Year() - 2000), byte(now.Month()), byte(now.Day()),
+			byte(now.Hour()), byte(now.Minute()), byte(now.Second()),
+		}, frame.Serial)
 
 	default:
 		msg.Type = "UNKNOWN"
+		msg.Extras["protocol_number"] = frame.Protocol
 	}
 
 	return msg, nil
 }
 
-// Encode 编码GT06响应
+// Encode 编码GT06响应和下发指令
 func (a *GT06Adapter) Encode(cmd protocol.StandardCommand) ([]byte, error) {
 	switch cmd.Type {
 	case "AUTH_ACK":
 		// 登录响应
-		return []byte{0x78, 0x78, 0x05, 0x01, 0x00, 0x01, 0x00, 0x00, 0x0D, 0x0A}, nil
-	
+		return a.encodeAck(GT06MsgLogin, cmd.Params)
+
 	case "HEARTBEAT_ACK":
 		// 心跳响应
-		return []byte{0x78, 0x78, 0x05, 0x13, 0x00, 0x01, 0x00, 0x00, 0x0D, 0x0A}, nil
-	
+		return a.encodeAck(GT06MsgStatus, cmd.Params)
+
+	case protocol.CmdCustom, protocol.CmdLocationQuery, protocol.CmdVehicleControl:
+		return a.encodeOnlineCommand(cmd)
+
 	default:
 		return nil, fmt.Errorf("unsupported command: %s", cmd.Type)
 	}
 }
 
+// encodeAck 生成应答包，序列号取自终端数据包
+func (a *GT06Adapter) encodeAck(protocolNum byte, params map[string]interface{}) ([]byte, error) {
+	serial, _ := paramUint(params, "serial")
+	return buildGT06Packet(protocolNum, nil, uint16(serial)), nil
+}
+
 // IsHeartbeat 判断是否心跳包
 func (a *GT06Adapter) IsHeartbeat(packet []byte) bool {
-	return len(packet) > 3 && packet[3] == 0x13
+	frame, err := parseGT06Frame(packet)
+	return err == nil && frame.Protocol == GT06MsgStatus
 }
 
 // GenerateHeartbeatAck 生成心跳响应
-func (a *GT06Adapter) GenerateHeartbeatAck(packet []byte) []byte {
-	ack, _ := a.Encode(protocol.StandardCommand{Type: "HEARTBEAT_ACK"})
-	return ack
+func (a *GT06Adapter) GenerateHeartbeatAck(packet []byte) ([]byte, error) {
+	frame, err := parseGT06Frame(packet)
+	if err != nil {
+		return nil, err
+	}
+	return buildGT06Packet(GT06MsgStatus, nil, frame.Serial), nil
+}
+
+// Protocol 返回协议标识
+func (a *GT06Adapter) Protocol() string {
+	return "GT06"
+}
+
+// Scanner 返回GT06分包器
+func (a *GT06Adapter) Scanner() protocol.PacketScanner {
+	return GT06Scanner{}
+}
+
+// GT06Scanner GT06分包器
+// 短包: 0x78 0x78 + 长度(1) + ... + 0x0D 0x0A，总长 = 长度 + 5
+// 长包: 0x79 0x79 + 长度(2) + ... + 0x0D 0x0A，总长 = 长度 + 6
+type GT06Scanner struct{}
+
+// Scan 提取下一个完整的GT06包
+func (GT06Scanner) Scan(buffer []byte) ([]byte, []byte, error) {
+	start := -1
+	for i := 0; i+1 < len(buffer); i++ {
+		if (buffer[i] == 0x78 || buffer[i] == 0x79) && buffer[i+1] == buffer[i] {
+			start = i
+			break
+		}
+	}
+	if start == -1 {
+		// 保留可能是起始位的最后一个字节
+		if n := len(buffer); n > 0 && (buffer[n-1] == 0x78 || buffer[n-1] == 0x79) {
+			return nil, buffer[n-1:], nil
+		}
+		return nil, nil, nil
+	}
+	buffer = buffer[start:]
+
+	var total int
+	if buffer[0] == 0x78 {
+		if len(buffer) < 3 {
+			return nil, buffer, nil
+		}
+		total = int(buffer[2]) + 5
+	} else {
+		if len(buffer) < 4 {
+			return nil, buffer, nil
+		}
+		total = int(binary.BigEndian.Uint16(buffer[2:4])) + 6
+	}
+	if len(buffer) < total {
+		return nil, buffer, nil
+	}
+
+	if buffer[total-2] != 0x0D || buffer[total-1] != 0x0A {
+		// 停止位错误，跳过起始位重新同步
+		return nil, buffer[1:], fmt.Errorf("invalid GT06 stop bits")
+	}
+	return buffer[:total], buffer[total:], nil
+}
+
+// 解析方法
+
+// parseLogin 解析登录包: 终端ID(8, BCD) + [型号识别码(2) + 时区语言(2)]
+func (a *GT06Adapter) parseLogin(data []byte, msg *protocol.StandardMessage) error {
+	deviceID := a.parseDeviceID(data)
+	if deviceID == "" {
+		return fmt.Errorf("login packet too short")
+	}
+	a.mu.Lock()
+	a.deviceID = deviceID
+	a.mu.Unlock()
+	msg.DeviceID = deviceID
+
+	if len(data) >= 10 {
+		msg.Extras["type_id"] = binary.BigEndian.Uint16(data[8:10])
+	}
+	if len(data) >= 12 {
+		// bit15~bit4: 时区 x100 (如 800 为 +08:00)，bit3: 0 东时区 1 西时区
+		tz := binary.BigEndian.Uint16(data[10:12])
+		offset := int(tz>>4)/100*60 + int(tz>>4)%100
+		if tz&0x0008 != 0 {
+			offset = -offset
+		}
+		msg.Extras["timezone_offset"] = offset // 分钟
+	}
+	return nil
+}
+
+// parseLocation 解析定位包 0x12/0x22: GPS信息(18) + LBS(8)
+// 0x22 之后还有 ACC(1) + 上传模式(1) + 实时补传(1) + [里程(4)]
+func (a *GT06Adapter) parseLocation(protocolNum byte, data []byte, msg *protocol.StandardMessage) error {
+	if err := parseGT06GPS(data, msg); err != nil {
+		return err
+	}
+	parseGT06LBS(data[18:], msg.Extras)
+
+	if protocolNum == GT06MsgGPSLBS && len(data) >= 29 {
+		msg.Extras["acc_on"] = data[26] == 1
+		msg.Extras["upload_mode"] = data[27]
+		if data[28] == 1 {
+			// 盲区补传
+			msg.Extras["blind_area"] = true
+		}
+		if len(data) >= 33 {
+			msg.Extras["mileage"] = float64(binary.BigEndian.Uint32(data[29:33])) / 1000.0
+		}
+	}
+	a.stamp(msg, data[0:6])
+	return nil
+}
+
+// parseAlarm 解析报警包 0x16/0x26:
+// GPS信息(18) + LBS长度(1) + LBS(8) + 终端信息(1) + 电压等级(1) + GSM信号(1) + 报警语言(2)
+// LBS长度包含自身，0x26 之后还有围栏编号(1)
+func (a *GT06Adapter) parseAlarm(protocolNum byte, data []byte, msg *protocol.StandardMessage) error {
+	if err := parseGT06GPS(data, msg); err != nil {
+		return err
+	}
+	if len(data) < 19 {
+		return fmt.Errorf("alarm packet too short")
+	}
+	lbsLength := int(data[18])
+	if lbsLength < 1 || len(data) < 18+lbsLength+5 {
+		return fmt.Errorf("alarm packet too short")
+	}
+	parseGT06LBS(data[19:18+lbsLength], msg.Extras)
+	status := data[18+lbsLength:]
+	parseGT06Status(status, msg.Extras)
+	if protocolNum == GT06MsgAlarmMulti && len(status) >= 6 {
+		msg.Extras["fence_id"] = status[5]
+	}
+	a.stamp(msg, data[0:6])
+
+	code := status[3]
+	alarmType, ok := gt06Alarms[code]
+	if !ok {
+		return nil
+	}
+	// 报警包为事件上报，只有触发沿
+	alarm := &protocol.StandardMessage{
+		DeviceID:  msg.DeviceID,
+		Type:      protocol.MsgTypeAlarm,
+		Timestamp: msg.Timestamp,
+		Lat:       msg.Lat,
+		Lon:       msg.Lon,
+		Speed:     msg.Speed,
+		Direction: msg.Direction,
+		Extras:    make(map[string]interface{}),
+	}
+	alarm.Extras["alarm_type"] = alarmType
+	alarm.Extras["alarm_edge"] = protocol.AlarmEdgeRising
+	alarm.Extras["alarm_code"] = code
+	alarm.Extras["received_at"] = msg.Extras["received_at"]
+	switch code {
+	case 0x04:
+		alarm.Extras["area_alarm_direction"] = "in"
+	case 0x05:
+		alarm.Extras["area_alarm_direction"] = "out"
+	}
+	if fenceID, ok := msg.Extras["fence_id"]; ok {
+		alarm.Extras["area_alarm_id"] = fenceID
+	}
+	msg.Alarms = append(msg.Alarms, alarm)
+	return nil
+}
+
+// parseLBSMulti 解析多基站定位包 0x28:
+// 日期时间(6) + MCC(2) + MNC(1) + 7组 [LAC(2) + CellID(3) + RSSI(1)] + 时间提前量(1) + 语言(2)
+func (a *GT06Adapter) parseLBSMulti(data []byte, msg *protocol.StandardMessage) error {
+	if len(data) < 15 {
+		return fmt.Errorf("LBS packet too short")
+	}
+	msg.Extras["mcc"] = binary.BigEndian.Uint16(data[6:8])
+	msg.Extras["mnc"] = data[8]
+
+	cells := []map[string]interface{}{}
+	offset := 9
+	for i := 0; i < 7 && offset+6 <= len(data); i++ {
+		lac := binary.BigEndian.Uint16(data[offset : offset+2])
+		cellID := uint32(data[offset+2])<<16 | uint32(binary.BigEndian.Uint16(data[offset+3:offset+5]))
+		rssi := data[offset+5]
+		offset += 6
+		if lac == 0 && cellID == 0 {
+			continue
+		}
+		cells = append(cells, map[string]interface{}{
+			"lac":     lac,
+			"cell_id": cellID,
+			"rssi":    rssi,
+		})
+	}
+	msg.Extras["cells"] = cells
+	if offset < len(data) {
+		msg.Extras["timing_advance"] = data[offset]
+	}
+	a.stamp(msg, data[0:6])
+	return nil
+}
+
+// parseGT06GPS 解析GPS信息:
+// 日期时间(6) + 卫星数(1) + 纬度(4) + 经度(4) + 速度(1) + 航向状态(2)
+func parseGT06GPS(data []byte, msg *protocol.StandardMessage) error {
+	if len(data) < 18 {
+		return fmt.Errorf("GPS info too short")
+	}
+	// 高4位为GPS信息长度，低4位为卫星数
+	msg.Extras["satellites"] = data[6] & 0x0F
+
+	// 经纬度单位为 1/30000 分
+	lat := float64(binary.BigEndian.Uint32(data[7:11])) / 30000.0 / 60.0
+	lon := float64(binary.BigEndian.Uint32(data[11:15])) / 30000.0 / 60.0
+	msg.Speed = float64(data[15])
+
+	// 航向状态: bit13 差分定位, bit12 已定位, bit11 西经, bit10 北纬, bit9~bit0 航向
+	courseStatus := binary.BigEndian.Uint16(data[16:18])
+	msg.Direction = float64(courseStatus & 0x03FF)
+	msg.Extras["location_valid"] = courseStatus&0x1000 != 0
+	msg.Extras["gps_differential"] = courseStatus&0x2000 != 0
+	if courseStatus&0x0400 == 0 {
+		lat = -lat
+	}
+	if courseStatus&0x0800 != 0 {
+		lon = -lon
+	}
+	msg.Lat = lat
+	msg.Lon = lon
+	return nil
+}
+
+// parseGT06LBS 解析基站信息: MCC(2) + MNC(1) + LAC(2) + CellID(3)
+func parseGT06LBS(data []byte, extras map[string]interface{}) {
+	if len(data) < 8 {
+		return
+	}
+	extras["mcc"] = binary.BigEndian.Uint16(data[0:2])
+	extras["mnc"] = data[2]
+	extras["lac"] = binary.BigEndian.Uint16(data[3:5])
+	extras["cell_id"] = uint32(data[5])<<16 | uint32(binary.BigEndian.Uint16(data[6:8]))
+}
+
+// parseGT06Status 解析状态信息: 终端信息(1) + 电压等级(1) + GSM信号(1) + [报警(1) + 语言(1)]
+func parseGT06Status(data []byte, extras map[string]interface{}) {
+	info := data[0]
+	extras["terminal_info"] = info
+	extras["defense"] = info&0x01 != 0
+	extras["acc_on"] = info&0x02 != 0
+	extras["charging"] = info&0x04 != 0
+	if alarm := int(info>>3) & 0x07; alarm < len(gt06StatusAlarms) {
+		extras["alarm_status"] = gt06StatusAlarms[alarm]
+	}
+	extras["gps_tracking"] = info&0x40 != 0
+	extras["oil_cut"] = info&0x80 != 0
+
+	// 电压等级 0~6: 无电 ~ 电量极高
+	extras["battery_level"] = data[1]
+	// GSM 信号 0~4: 无信号 ~ 信号最强
+	extras["gsm_signal"] = data[2]
+	if len(data) >= 5 {
+		extras["alarm_code"] = data[3]
+		extras["language"] = data[4]
+	}
 }
 
 // 辅助方法
 
+// currentDeviceID 返回连接登录时上报的终端ID
+func (a *GT06Adapter) currentDeviceID() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.deviceID
+}
+
+// reply 向终端发送应答包，序列号沿用终端数据包的序列号
+func (a *GT06Adapter) reply(protocolNum byte, content []byte, serial uint16) {
+	if err := a.send(buildGT06Packet(protocolNum, content, serial)); err != nil {
+		log.Printf("[GT06] Failed to answer 0x%02X: %v", protocolNum, err)
+	}
+}
+
+// send 通过连接写出数据包
+func (a *GT06Adapter) send(packet []byte) error {
+	a.mu.Lock()
+	write := a.write
+	a.mu.Unlock()
+	if write == nil {
+		return errors.New("no writer attached")
+	}
+	return write(packet)
+}
+
+// stamp 以终端时间作为消息时间
+func (a *GT06Adapter) stamp(msg *protocol.StandardMessage, data []byte) {
+	if deviceTime, err := a.parseDateTime(data); err == nil {
+		a.clock.Stamp(msg, deviceTime)
+	}
+}
+
 func (a *GT06Adapter) parseDeviceID(data []byte) string {
 	// GT06 设备ID是BCD编码的IMEI
 	if len(data) < 8 {
 		return ""
 	}
 	var result bytes.Buffer
-	for _, b := range data {
+	for _, b := range data[:8] {
 		result.WriteString(fmt.Sprintf("%02x", b))
 	}
 	return result.String()
 }
 
-func (a *GT06Adapter) parseDateTime(data []byte) int64 {
+func (a *GT06Adapter) parseDateTime(data []byte) (time.Time, error) {
 	if len(data) < 6 {
-		return time.Now().Unix()
+		return time.Time{}, fmt.Errorf("datetime too short")
 	}
 	// YY MM DD HH MM SS
-	year := 2000 + int(data[0])
-	month := time.Month(data[1])
-	day := int(data[2])
-	hour := int(data[3])
-	minute := int(data[4])
-	second := int(data[5])
-	
-	t := time.Date(year, month, day, hour, minute, second, 0, time.UTC)
-	return t.Unix()
+	return a.clock.Date(2000+int(data[0]), int(data[1]), int(data[2]),
+		int(data[3]), int(data[4]), int(data[5]))
 }
@@ -0,0 +1,160 @@
+package adapter
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf16"
+
+	"openfms/gateway/internal/protocol"
+)
+
+const (
+	// 等待回复的在线指令
+	gt06MaxOutstanding = 64
+	gt06OutstandingTTL = 10 * time.Minute
+
+	// 在线指令的指令长度字段为1字节，包含4字节服务器标志位
+	gt06MaxCommandLength = 0xFF - 4
+)
+
+// gt06Outgoing 记录等待终端回复的在线指令
+type gt06Outgoing struct {
+	Command   string
+	CommandID string // API 指令ID，非 API 下发时为空
+	SentAt    time.Time
+}
+
+// gt06CommandText 将平台指令转换为GT06文本指令
+func gt06CommandText(cmd protocol.StandardCommand) (string, error) {
+	switch cmd.Type {
+	case protocol.CmdCustom:
+		text, _ := cmd.Params["command"].(string)
+		if text == "" {
+			return "", errors.New("empty custom command")
+		}
+		return text, nil
+
+	case protocol.CmdLocationQuery:
+		return "WHERE#", nil
+
+	case protocol.CmdVehicleControl:
+		// GT06 只支持断油电
+		if target, _ := cmd.Params["type"].(string); target != "" && target != "oil" {
+			return "", fmt.Errorf("invalid vehicle control type: %q", target)
+		}
+		password, _ := cmd.Params["password"].(string)
+		if password == "" {
+			password = "000000"
+		}
+		switch action, _ := cmd.Params["action"].(string); action {
+		case "lock", "cut":
+			return fmt.Sprintf("DYD,%s#", password), nil
+		case "unlock", "restore":
+			return fmt.Sprintf("HFYD,%s#", password), nil
+		default:
+			return "", fmt.Errorf("invalid vehicle control action: %q", action)
+		}
+
+	default:
+		return "", fmt.Errorf("unsupported command: %s", cmd.Type)
+	}
+}
+
+// encodeOnlineCommand 生成在线指令 0x80:
+// 指令长度(1) + 服务器标志位(4) + 指令内容(ASCII)
+// 终端以 0x15/0x21 回复并带回服务器标志位，用于匹配指令
+func (a *GT06Adapter) encodeOnlineCommand(cmd protocol.StandardCommand) ([]byte, error) {
+	text, err := gt06CommandText(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if len(text) > gt06MaxCommandLength {
+		return nil, fmt.Errorf("command too long: %d bytes", len(text))
+	}
+
+	a.mu.Lock()
+	a.serial++
+	serial := a.serial
+	flag := uint32(serial)
+	if a.outstanding == nil {
+		a.outstanding = make(map[uint32]gt06Outgoing)
+	}
+	if len(a.outstanding) >= gt06MaxOutstanding {
+		for f, o := range a.outstanding {
+			if time.Since(o.SentAt) > gt06OutstandingTTL {
+				delete(a.outstanding, f)
+			}
+		}
+	}
+	if len(a.outstanding) < gt06MaxOutstanding {
+		a.outstanding[flag] = gt06Outgoing{
+			Command:   cmd.Type,
+			CommandID: cmd.CommandID,
+			SentAt:    time.Now(),
+		}
+	}
+	a.mu.Unlock()
+
+	content := []byte{byte(4 + len(text))}
+	content = binary.BigEndian.AppendUint32(content, flag)
+	content = append(content, text...)
+	return buildGT06Packet(GT06MsgOnlineCommand, content, serial), nil
+}
+
+// parseCommandReply 解析终端指令回复
+// 0x15: 指令长度(1) + 服务器标志位(4) + 回复内容 + [语言(2)]
+// 0x21: 服务器标志位(4) + 编码类型(1, 1=ASCII 2=UTF-16BE) + 回复内容
+func (a *GT06Adapter) parseCommandReply(protocolNum byte, data []byte, msg *protocol.StandardMessage) error {
+	if len(data) < 5 {
+		return fmt.Errorf("command reply too short")
+	}
+
+	var flag uint32
+	var text string
+	if protocolNum == GT06MsgStringReply {
+		flag = binary.BigEndian.Uint32(data[1:5])
+		n := int(data[0]) - 4
+		if n < 0 || 5+n > len(data) {
+			n = len(data) - 5
+		}
+		text = string(data[5 : 5+n])
+	} else {
+		flag = binary.BigEndian.Uint32(data[0:4])
+		if data[4] == 2 {
+			units := make([]uint16, 0, len(data[5:])/2)
+			for i := 5; i+1 < len(data); i += 2 {
+				units = append(units, binary.BigEndian.Uint16(data[i:i+2]))
+			}
+			text = string(utf16.Decode(units))
+		} else {
+			text = string(data[5:])
+		}
+	}
+	msg.Extras["server_flag"] = flag
+	msg.Extras["reply"] = text
+
+	a.mu.Lock()
+	out, ok := a.outstanding[flag]
+	if ok {
+		delete(a.outstanding, flag)
+	}
+	a.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	msg.Extras["command"] = out.Command
+	if out.CommandID == "" {
+		return nil
+	}
+	msg.Response = &protocol.CommandResponse{
+		CommandID: out.CommandID,
+		DeviceID:  msg.DeviceID,
+		Success:   true,
+		Data: map[string]interface{}{
+			"reply": text,
+		},
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package adapter
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"testing"
+	"time"
+
+	"openfms/gateway/internal/protocol"
+)
+
+// 协议文档中的报文
+const (
+	gt06Login    = "78780D01012345678901234500018CDD0D0A"
+	gt06LoginAck = "787805010001D9DC0D0A"
+	// 文档示例定位包，校验值按 CRC-ITU 更正为 7377 (原文为 8081)
+	gt06Location       = "78781F120B081D112E10CC027AC7EB0C46584900148F01CC00287D001FB8000373770D0A"
+	gt06LocationBadCRC = "78781F120B081D112E10CC027AC7EB0C46584900148F01CC00287D001FB8000380810D0A"
+	// 0x94 信息传输长包 (ICCID)
+	gt06InfoLong = "79790020940A0358765052019320460001234567890089860112345678901234000904520D0A"
+)
+
+func gt06Hex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("invalid hex %q: %v", s, err)
+	}
+	return b
+}
+
+func TestCRCITU(t *testing.T) {
+	tests := []struct {
+		data string
+		want uint16
+	}{
+		{"0D0101234567890123450001", 0x8CDD},
+		{"05010001", 0xD9DC},
+		{"313233343536373839", 0x906E}, // CRC-16/X-25 校验值
+	}
+	for _, tt := range tests {
+		if got := crcITU(gt06Hex(t, tt.data)); got != tt.want {
+			t.Errorf("crcITU(%s) = %04X, want %04X", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestParseGT06Frame(t *testing.T) {
+	tests := []struct {
+		name     string
+		packet   string
+		protocol byte
+		content  int
+		serial   uint16
+		wantErr  bool
+	}{
+		{"short login", gt06Login, GT06MsgLogin, 8, 1, false},
+		{"short location", gt06Location, GT06MsgLocation, 26, 3, false},
+		{"long info", gt06InfoLong, 0x94, 27, 9, false},
+		{"bad crc", gt06LocationBadCRC, 0, 0, 0, true},
+		{"truncated", gt06Login[:len(gt06Login)-4], 0, 0, 0, true},
+		{"bad header", "7879" + gt06Login[4:], 0, 0, 0, true},
+		{"too short", "78780D01", 0, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, err := parseGT06Frame(gt06Hex(t, tt.packet))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got frame %+v", frame)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if frame.Protocol != tt.protocol || len(frame.Content) != tt.content || frame.Serial != tt.serial {
+				t.Errorf("frame = {0x%02X, %d bytes, serial %d}, want {0x%02X, %d bytes, serial %d}",
+					frame.Protocol, len(frame.Content), frame.Serial, tt.protocol, tt.content, tt.serial)
+			}
+		})
+	}
+}
+
+func TestBuildGT06Packet(t *testing.T) {
+	if got := buildGT06Packet(GT06MsgLogin, nil, 1); !bytes.Equal(got, gt06Hex(t, gt06LoginAck)) {
+		t.Errorf("login ack = %X, want %s", got, gt06LoginAck)
+	}
+
+	// 超过短包长度的内容使用长包，并能被重新解析
+	content := bytes.Repeat([]byte{0xAB}, 300)
+	packet := buildGT06Packet(0x94, content, 7)
+	if packet[0] != 0x79 || packet[1] != 0x79 {
+		t.Fatalf("expected a 0x7979 packet, got %X", packet[:2])
+	}
+	frame, err := parseGT06Frame(packet)
+	if err != nil {
+		t.Fatalf("parse built packet: %v", err)
+	}
+	if !bytes.Equal(frame.Content, content) || frame.Serial != 7 {
+		t.Errorf("round trip = {%d bytes, serial %d}", len(frame.Content), frame.Serial)
+	}
+}
+
+func TestGT06ScannerSplitReads(t *testing.T) {
+	login, location, info := gt06Hex(t, gt06Login), gt06Hex(t, gt06Location), gt06Hex(t, gt06InfoLong)
+	stream := append([]byte{0x00, 0x0D, 0x0A}, login...) // 帧前的杂散字节
+	stream = append(stream, location...)
+	stream = append(stream, info...)
+
+	for _, chunk := range []int{1, 2, 3, 5, 17, len(stream)} {
+		var packets [][]byte
+		var buffer []byte
+		for offset := 0; offset < len(stream); offset += chunk {
+			end := offset + chunk
+			if end > len(stream) {
+				end = len(stream)
+			}
+			buffer = append(buffer, stream[offset:end]...)
+			for {
+				packet, rest, err := GT06Scanner{}.Scan(buffer)
+				if err != nil {
+					t.Fatalf("chunk %d: unexpected error: %v", chunk, err)
+				}
+				buffer = rest
+				if packet == nil {
+					break
+				}
+				packets = append(packets, append([]byte(nil), packet...))
+			}
+		}
+		if len(packets) != 3 || !bytes.Equal(packets[0], login) ||
+			!bytes.Equal(packets[1], location) || !bytes.Equal(packets[2], info) {
+			t.Errorf("chunk %d: got packets %X", chunk, packets)
+		}
+	}
+}
+
+func TestGT06ScannerBadStopBits(t *testing.T) {
+	packet := gt06Hex(t, gt06Login)
+	packet[len(packet)-1] = 0x00
+	next := gt06Hex(t, gt06Location)
+
+	buffer := append(packet, next...)
+	var got [][]byte
+	for len(buffer) > 0 {
+		packet, rest, _ := GT06Scanner{}.Scan(buffer)
+		if packet == nil && len(rest) == len(buffer) {
+			break
+		}
+		buffer = rest
+		if packet != nil {
+			got = append(got, packet)
+		}
+	}
+	if len(got) != 1 || !bytes.Equal(got[0], next) {
+		t.Errorf("expected to resynchronize on the next packet, got %X", got)
+	}
+}
+
+func TestGT06DecodeLogin(t *testing.T) {
+	a := NewGT06Adapter()
+	var sent []byte
+	a.SetWriter(func(packet []byte) error {
+		sent = packet
+		return nil
+	})
+
+	msg, err := a.Decode(gt06Hex(t, gt06Login))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if msg.Type != protocol.MsgTypeAuth || msg.DeviceID != "0123456789012345" {
+		t.Errorf("login = %s from %q", msg.Type, msg.DeviceID)
+	}
+	if !bytes.Equal(sent, gt06Hex(t, gt06LoginAck)) {
+		t.Errorf("login ack = %X, want %s", sent, gt06LoginAck)
+	}
+
+	// 之后的数据包沿用登录的终端ID
+	msg, err = a.Decode(gt06Hex(t, gt06Location))
+	if err != nil {
+		t.Fatalf("decode location: %v", err)
+	}
+	if msg.DeviceID != "0123456789012345" {
+		t.Errorf("location device = %q", msg.DeviceID)
+	}
+}
+
+func TestGT06DecodeLocation(t *testing.T) {
+	msg, err := NewGT06Adapter().Decode(gt06Hex(t, gt06Location))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if msg.Type != protocol.MsgTypeLocation {
+		t.Errorf("type = %s", msg.Type)
+	}
+	if math.Abs(msg.Lat-23.111668) > 1e-5 || math.Abs(msg.Lon-114.409285) > 1e-5 {
+		t.Errorf("position = %f,%f", msg.Lat, msg.Lon)
+	}
+	if msg.Direction != 143 || msg.Speed != 0 {
+		t.Errorf("direction %v speed %v", msg.Direction, msg.Speed)
+	}
+	if want := time.Date(2011, 8, 29, 17, 46, 16, 0, time.UTC).Unix(); msg.Timestamp != want {
+		t.Errorf("timestamp = %d, want %d", msg.Timestamp, want)
+	}
+	if msg.Extras["satellites"] != byte(12) || msg.Extras["location_valid"] != true {
+		t.Errorf("extras = %v", msg.Extras)
+	}
+	if msg.Extras["mcc"] != uint16(460) || msg.Extras["lac"] != uint16(0x287D) || msg.Extras["cell_id"] != uint32(0x1FB8) {
+		t.Errorf("cell = %v/%v/%v", msg.Extras["mcc"], msg.Extras["lac"], msg.Extras["cell_id"])
+	}
+
+	if _, err := NewGT06Adapter().Decode(gt06Hex(t, gt06LocationBadCRC)); err == nil {
+		t.Error("expected a checksum error")
+	}
+}
+
+func TestGT06Hemispheres(t *testing.T) {
+	tests := []struct {
+		name     string
+		course   uint16
+		lat, lon float64
+	}{
+		{"north east", 0x1400, 1, 1},
+		{"north west", 0x1C00, 1, -1},
+		{"south east", 0x1000, -1, 1},
+		{"south west", 0x1800, -1, -1},
+	}
+	location := gt06Hex(t, gt06Location)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := append([]byte(nil), location[4:30]...)
+			content[16], content[17] = byte(tt.course>>8), byte(tt.course)
+			msg, err := NewGT06Adapter().Decode(buildGT06Packet(GT06MsgLocation, content, 3))
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if math.Signbit(msg.Lat) != (tt.lat < 0) || math.Signbit(msg.Lon) != (tt.lon < 0) {
+				t.Errorf("position = %f,%f", msg.Lat, msg.Lon)
+			}
+		})
+	}
+}
@@ -0,0 +1,587 @@
+// H02 协议适配器
+// 华强/Sinotrack 等终端使用的文本协议，报文格式为 *HQ,IMEI,类型,...#
+// 支持位置 (V1)、心跳 (LINK/HTBT/XT)、基站 (NBR) 上报和指令回复 (V4)
+
+package adapter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"openfms/gateway/internal/protocol"
+)
+
+const (
+	// h02MaxFrame 单个报文的最大长度
+	h02MaxFrame = 1024
+	// h02MaxPending 等待回复的指令数
+	h02MaxPending = 16
+	// h02AccBit 状态字中的 ACC 位，为 1 时 ACC 开
+	h02AccBit = 10
+	// h02LowBattery LINK 心跳电量 (%) 低于此值时报低电量
+	h02LowBattery = 20
+)
+
+// h02AlarmBits 状态字中的报警位，为 0 时报警 (低电平有效)
+// H02 状态字没有低电量报警位，低电量由 LINK 心跳的电量判断
+var h02AlarmBits = []struct {
+	Bit  uint
+	Type string
+}{
+	{0, protocol.AlarmVibration},
+	{1, protocol.AlarmSOS},
+	{2, protocol.AlarmOverspeed},
+	{19, protocol.AlarmPowerCut},
+}
+
+// h02Outgoing 记录等待 V4 回复的指令，按指令码匹配
+type h02Outgoing struct {
+	Code      string
+	Command   string
+	CommandID string
+}
+
+// H02Adapter H02协议适配器，每个连接一个实例
+type H02Adapter struct {
+	clock DeviceClock
+
+	mu          sync.Mutex
+	deviceID    string // 报文中的IMEI，下发指令时使用
+	alarms      uint32 // 上一条位置中处于报警状态的位
+	lowBattery  bool   // 上一次 LINK 心跳是否低电量
+	alarmStore  protocol.AlarmStore
+	alarmDevice string // 已从 alarmStore 载入报警位的设备
+	pending     []h02Outgoing
+}
+
+// 报警状态存储中的名称: 状态字报警位、低电量 (1 为低电量)
+const (
+	h02AlarmFlag   = "status"
+	h02BatteryFlag = "battery"
+)
+
+func init() {
+	Register(Registration{
+		Name:   "H02",
+		Detect: (&H02Adapter{}).Match,
+		New: func(opts Options) protocol.ProtocolAdapter {
+			return NewH02AdapterWithClock(opts.Clock)
+		},
+	})
+}
+
+// NewH02Adapter 创建H02适配器，设备时间为 UTC
+func NewH02Adapter() *H02Adapter {
+	return NewH02AdapterWithClock(UTCDeviceClock())
+}
+
+// NewH02AdapterWithClock 创建H02适配器并指定设备时区
+func NewH02AdapterWithClock(clock DeviceClock) *H02Adapter {
+	return &H02Adapter{clock: clock}
+}
+
+// Match 匹配H02文本报文 (以 *H 开头，如 *HQ)
+func (a *H02Adapter) Match(header []byte) bool {
+	return len(header) >= 2 && header[0] == '*' && header[1] == 'H'
+}
+
+// Decode 解码H02报文
+func (a *H02Adapter) Decode(packet []byte) (*protocol.StandardMessage, error) {
+	fields, err := splitH02(packet)
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	a.deviceID = fields[1]
+	a.mu.Unlock()
+
+	receivedAt := time.Now().Unix()
+	msg := &protocol.StandardMessage{
+		DeviceID:  fields[1],
+		Timestamp: receivedAt,
+		Extras:    make(map[string]interface{}),
+	}
+	msg.Extras["received_at"] = receivedAt
+
+	switch kind := fields[2]; kind {
+	case "V1":
+		// V1,时间,A/V,纬度,N/S,经度,E/W,速度,航向,日期,状态[,MCC,MNC,LAC,CID]
+		msg.Type = protocol.MsgTypeLocation
+		if err := a.decodePosition(fields[3:], msg); err != nil {
+			return nil, err
+		}
+		a.raiseAlarms(msg)
+
+	case "NBR":
+		msg.Type = protocol.MsgTypeLBS
+		if err := a.decodeNBR(fields[3:], msg); err != nil {
+			return nil, err
+		}
+
+	case "LINK":
+		// LINK,时间,GSM信号,卫星数,电量,计步,翻转次数,日期
+		msg.Type = protocol.MsgTypeHeartbeat
+		a.decodeLink(fields[3:], msg)
+		a.raiseLowBattery(msg)
+
+	case "HTBT", "XT":
+		msg.Type = protocol.MsgTypeHeartbeat
+
+	case "V4":
+		// V4,指令码,指令参数或执行结果...
+		msg.Type = protocol.MsgTypeCommandResponse
+		if len(fields) < 4 {
+			return nil, errors.New("invalid H02 command reply")
+		}
+		a.decodeReply(fields[3], strings.Join(fields[4:], ","), msg)
+
+	default:
+		msg.Type = "UNKNOWN"
+		msg.Extras["h02_type"] = kind
+	}
+	return msg, nil
+}
+
+// Encode 编码下发指令: *HQ,IMEI,指令码,HHMMSS[,参数...]#
+func (a *H02Adapter) Encode(cmd protocol.StandardCommand) ([]byte, error) {
+	var code string
+	var args []string
+	switch cmd.Type {
+	case protocol.CmdCustom:
+		// 指令码及参数，如 "S20,1,1"
+		text, _ := cmd.Params["command"].(string)
+		if text == "" {
+			return nil, errors.New("empty custom command")
+		}
+		var rest string
+		code, rest, _ = strings.Cut(text, ",")
+		if rest != "" {
+			args = strings.Split(rest, ",")
+		}
+
+	case protocol.CmdVehicleControl:
+		// 断油电
+		if target, _ := cmd.Params["type"].(string); target != "" && target != "oil" {
+			return nil, fmt.Errorf("invalid vehicle control type: %q", target)
+		}
+		code = "S20"
+		switch action, _ := cmd.Params["action"].(string); action {
+		case "lock", "cut":
+			args = []string{"1", "1"}
+		case "unlock", "restore":
+			args = []string{"1", "0"}
+		default:
+			return nil, fmt.Errorf("invalid vehicle control action: %q", action)
+		}
+
+	case protocol.CmdTempTracking:
+		// 设置上报间隔 (秒)
+		interval, ok := paramUint(cmd.Params, "interval")
+		if !ok || interval == 0 {
+			return nil, fmt.Errorf("invalid tracking interval: %v", cmd.Params["interval"])
+		}
+		code = "S71"
+		args = []string{"22", strconv.FormatUint(interval, 10)}
+
+	case protocol.CmdTerminalControl:
+		command, err := terminalControlCommand(cmd.Params)
+		if err != nil {
+			return nil, err
+		}
+		if command != 4 {
+			return nil, fmt.Errorf("unsupported terminal control command: %d", command)
+		}
+		code = "R1" // 重启
+
+	default:
+		return nil, fmt.Errorf("unsupported command: %s", cmd.Type)
+	}
+	return a.encodeCommand(cmd, code, args)
+}
+
+// IsHeartbeat 判断是否心跳报文
+func (a *H02Adapter) IsHeartbeat(packet []byte) bool {
+	fields, err := splitH02(packet)
+	if err != nil {
+		return false
+	}
+	switch fields[2] {
+	case "LINK", "HTBT", "XT":
+		return true
+	}
+	return false
+}
+
+// GenerateHeartbeatAck H02 心跳无需应答
+func (a *H02Adapter) GenerateHeartbeatAck(packet []byte) ([]byte, error) {
+	return nil, nil
+}
+
+// Protocol 返回协议标识
+func (a *H02Adapter) Protocol() string {
+	return "H02"
+}
+
+// Scanner 返回H02分包器
+func (a *H02Adapter) Scanner() protocol.PacketScanner {
+	return H02Scanner{}
+}
+
+// H02Scanner H02分包器，报文以 * 开头、# 结尾
+type H02Scanner struct{}
+
+// Scan 提取下一个完整报文，含首尾的 * 和 #
+func (H02Scanner) Scan(buffer []byte) ([]byte, []byte, error) {
+	start := bytes.IndexByte(buffer, '*')
+	if start == -1 {
+		return nil, nil, fmt.Errorf("discarded %d bytes outside H02 frames", len(buffer))
+	}
+	if start > 0 {
+		return nil, buffer[start:], fmt.Errorf("discarded %d bytes outside H02 frames", start)
+	}
+	end := bytes.IndexByte(buffer, '#')
+	if end == -1 {
+		if len(buffer) > h02MaxFrame {
+			return nil, buffer[1:], fmt.Errorf("H02 frame exceeds %d bytes", h02MaxFrame)
+		}
+		return nil, buffer, nil
+	}
+	return buffer[:end+1], buffer[end+1:], nil
+}
+
+// 解析方法
+
+// decodePosition 解析位置字段: 时间,A/V,纬度,N/S,经度,E/W,速度(节),航向,日期,状态
+func (a *H02Adapter) decodePosition(fields []string, msg *protocol.StandardMessage) error {
+	if len(fields) < 10 {
+		return errors.New("H02 position too short")
+	}
+	lat, err := parseNMEACoord(fields[2], fields[3])
+	if err != nil {
+		return err
+	}
+	lon, err := parseNMEACoord(fields[4], fields[5])
+	if err != nil {
+		return err
+	}
+	msg.Lat = lat
+	msg.Lon = lon
+	msg.Extras["location_valid"] = fields[1] == "A"
+
+	if speed, err := strconv.ParseFloat(fields[6], 64); err == nil {
+		msg.Speed = speed * 1.852 // kn -> km/h
+	}
+	if dir, err := strconv.ParseFloat(fields[7], 64); err == nil {
+		msg.Direction = dir
+	}
+	if t, err := a.parseDateTime(fields[8], fields[0]); err == nil {
+		a.clock.Stamp(msg, t)
+	}
+
+	status, err := strconv.ParseUint(fields[9], 16, 32)
+	if err != nil {
+		return fmt.Errorf("invalid H02 status %q", fields[9])
+	}
+	msg.Extras["status"] = fields[9]
+	msg.Extras["acc_on"] = status&(1<<h02AccBit) != 0
+
+	var active uint32
+	for _, b := range h02AlarmBits {
+		if status&(1<<b.Bit) == 0 {
+			active |= 1 << b.Bit
+		}
+	}
+	msg.Extras["alarm_flag"] = active
+
+	// 部分终端在状态字后附带基站信息
+	if len(fields) >= 14 {
+		setH02Cell(msg.Extras, fields[10], fields[11], fields[12], fields[13])
+	}
+	return nil
+}
+
+// SetAlarmStore 实现 protocol.AlarmTracker
+func (a *H02Adapter) SetAlarmStore(store protocol.AlarmStore) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.alarmStore = store
+}
+
+// raiseAlarms 与上一条位置的报警位比较，为变化的位生成报警消息。
+// 连接的第一条位置从报警状态存储载入上次的报警位。
+func (a *H02Adapter) raiseAlarms(msg *protocol.StandardMessage) {
+	flag, _ := msg.Extras["alarm_flag"].(uint32)
+	a.loadAlarms(msg.DeviceID)
+
+	a.mu.Lock()
+	changed := flag ^ a.alarms
+	a.alarms = flag
+	store := a.alarmStore
+	a.mu.Unlock()
+
+	if changed != 0 && store != nil && msg.DeviceID != "" {
+		store.SaveAlarmFlag(msg.DeviceID, h02AlarmFlag, flag)
+	}
+
+	for _, b := range h02AlarmBits {
+		mask := uint32(1) << b.Bit
+		if changed&mask == 0 {
+			continue
+		}
+		edge := protocol.AlarmEdgeFalling
+		if flag&mask != 0 {
+			edge = protocol.AlarmEdgeRising
+		}
+		alarm := &protocol.StandardMessage{
+			DeviceID:  msg.DeviceID,
+			Type:      protocol.MsgTypeAlarm,
+			Timestamp: msg.Timestamp,
+			Lat:       msg.Lat,
+			Lon:       msg.Lon,
+			Speed:     msg.Speed,
+			Direction: msg.Direction,
+			Extras:    make(map[string]interface{}),
+		}
+		alarm.Extras["alarm_type"] = b.Type
+		alarm.Extras["alarm_edge"] = edge
+		alarm.Extras["alarm_bit"] = b.Bit
+		alarm.Extras["received_at"] = msg.Extras["received_at"]
+		msg.Alarms = append(msg.Alarms, alarm)
+	}
+}
+
+// loadAlarms 每个连接从报警状态存储载入一次设备上次的报警位
+func (a *H02Adapter) loadAlarms(deviceID string) {
+	a.mu.Lock()
+	store, loaded := a.alarmStore, a.alarmDevice == deviceID
+	a.mu.Unlock()
+	if store == nil || loaded || deviceID == "" {
+		return
+	}
+
+	flags := store.LoadAlarmFlags(deviceID)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.alarmDevice = deviceID
+	a.alarms = flags[h02AlarmFlag]
+	a.lowBattery = flags[h02BatteryFlag] != 0
+}
+
+// raiseLowBattery 电量跌破或恢复到 h02LowBattery 时生成低电量报警的开始或结束
+func (a *H02Adapter) raiseLowBattery(msg *protocol.StandardMessage) {
+	level, ok := msg.Extras["battery_level"].(uint64)
+	if !ok {
+		return
+	}
+	low := level < h02LowBattery
+	a.loadAlarms(msg.DeviceID)
+
+	a.mu.Lock()
+	changed := low != a.lowBattery
+	a.lowBattery = low
+	store := a.alarmStore
+	a.mu.Unlock()
+	if !changed {
+		return
+	}
+
+	var flag uint32
+	edge := protocol.AlarmEdgeFalling
+	if low {
+		flag = 1
+		edge = protocol.AlarmEdgeRising
+	}
+	if store != nil && msg.DeviceID != "" {
+		store.SaveAlarmFlag(msg.DeviceID, h02BatteryFlag, flag)
+	}
+
+	alarm := &protocol.StandardMessage{
+		DeviceID:  msg.DeviceID,
+		Type:      protocol.MsgTypeAlarm,
+		Timestamp: msg.Timestamp,
+		Extras:    make(map[string]interface{}),
+	}
+	alarm.Extras["alarm_type"] = protocol.AlarmLowBattery
+	alarm.Extras["alarm_edge"] = edge
+	alarm.Extras["battery_level"] = level
+	alarm.Extras["received_at"] = msg.Extras["received_at"]
+	msg.Alarms = append(msg.Alarms, alarm)
+}
+
+// decodeNBR 解析基站报文: 时间,MCC,MNC,时间提前量,基站数,[LAC,CID,RSSI]...,日期,状态
+func (a *H02Adapter) decodeNBR(fields []string, msg *protocol.StandardMessage) error {
+	if len(fields) < 5 {
+		return errors.New("H02 NBR report too short")
+	}
+	count, err := strconv.Atoi(fields[4])
+	if err != nil || count < 0 || len(fields) < 5+count*3+2 {
+		return errors.New("invalid H02 NBR report")
+	}
+	msg.Extras["mcc"], _ = strconv.ParseUint(fields[1], 10, 16)
+	msg.Extras["mnc"], _ = strconv.ParseUint(fields[2], 10, 16)
+	msg.Extras["timing_advance"], _ = strconv.ParseUint(fields[3], 10, 8)
+
+	cells := []map[string]interface{}{}
+	for i := 0; i < count; i++ {
+		cell := fields[5+i*3 : 8+i*3]
+		lac, _ := strconv.ParseUint(cell[0], 10, 16)
+		cellID, _ := strconv.ParseUint(cell[1], 10, 32)
+		rssi, _ := strconv.ParseUint(cell[2], 10, 8)
+		cells = append(cells, map[string]interface{}{
+			"lac":     lac,
+			"cell_id": cellID,
+			"rssi":    rssi,
+		})
+	}
+	msg.Extras["cells"] = cells
+
+	rest := fields[5+count*3:]
+	if t, err := a.parseDateTime(rest[0], fields[0]); err == nil {
+		a.clock.Stamp(msg, t)
+	}
+	msg.Extras["status"] = rest[1]
+	return nil
+}
+
+// decodeLink 解析 LINK 心跳中的终端状态
+func (a *H02Adapter) decodeLink(fields []string, msg *protocol.StandardMessage) {
+	for i, key := range []string{"", "gsm_signal", "satellites", "battery_level", "steps", "turnovers"} {
+		if key == "" || i >= len(fields) {
+			continue
+		}
+		if v, err := strconv.ParseUint(fields[i], 10, 32); err == nil {
+			msg.Extras[key] = v
+		}
+	}
+	if len(fields) >= 7 {
+		if t, err := a.parseDateTime(fields[6], fields[0]); err == nil {
+			a.clock.Stamp(msg, t)
+		}
+	}
+}
+
+// decodeReply 解析 V4 指令回复，按指令码匹配最早的同类指令
+func (a *H02Adapter) decodeReply(code, reply string, msg *protocol.StandardMessage) {
+	msg.Extras["command_code"] = code
+	msg.Extras["reply"] = reply
+
+	a.mu.Lock()
+	var out h02Outgoing
+	found := false
+	for i, o := range a.pending {
+		if o.Code == code {
+			out, found = o, true
+			a.pending = append(a.pending[:i], a.pending[i+1:]...)
+			break
+		}
+	}
+	a.mu.Unlock()
+	if !found {
+		return
+	}
+	msg.Extras["command"] = out.Command
+	if out.CommandID == "" {
+		return
+	}
+	msg.Response = &protocol.CommandResponse{
+		CommandID: out.CommandID,
+		DeviceID:  msg.DeviceID,
+		Success:   true,
+		Data: map[string]interface{}{
+			"reply": reply,
+		},
+	}
+}
+
+// encodeCommand 生成指令报文，时间为终端时区的 HHMMSS
+func (a *H02Adapter) encodeCommand(cmd protocol.StandardCommand, code string, args []string) ([]byte, error) {
+	if code == "" {
+		return nil, errors.New("empty H02 command code")
+	}
+	a.mu.Lock()
+	deviceID := a.deviceID
+	if deviceID != "" {
+		if len(a.pending) >= h02MaxPending {
+			a.pending = a.pending[1:]
+		}
+		a.pending = append(a.pending, h02Outgoing{Code: code, Command: cmd.Type, CommandID: cmd.CommandID})
+	}
+	a.mu.Unlock()
+	if deviceID == "" {
+		return nil, errors.New("device has not reported its IMEI yet")
+	}
+
+	fields := append([]string{"HQ", deviceID, code, time.Now().In(a.clock.location()).Format("150405")}, args...)
+	return []byte("*" + strings.Join(fields, ",") + "#"), nil
+}
+
+// 辅助方法
+
+// splitH02 校验报文首尾并按逗号拆分，至少包含厂商、IMEI 和类型
+func splitH02(packet []byte) ([]string, error) {
+	if len(packet) < 2 || packet[0] != '*' || packet[len(packet)-1] != '#' {
+		return nil, errors.New("invalid H02 frame")
+	}
+	fields := strings.Split(string(packet[1:len(packet)-1]), ",")
+	if len(fields) < 3 || fields[1] == "" {
+		return nil, errors.New("invalid H02 frame")
+	}
+	return fields, nil
+}
+
+// setH02Cell 写入位置附带的基站信息
+func setH02Cell(extras map[string]interface{}, mcc, mnc, lac, cid string) {
+	if v, err := strconv.ParseUint(mcc, 10, 16); err == nil {
+		extras["mcc"] = v
+	}
+	if v, err := strconv.ParseUint(mnc, 10, 16); err == nil {
+		extras["mnc"] = v
+	}
+	if v, err := strconv.ParseUint(lac, 10, 32); err == nil {
+		extras["lac"] = v
+	}
+	if v, err := strconv.ParseUint(cid, 10, 32); err == nil {
+		extras["cell_id"] = v
+	}
+}
+
+// parseNMEACoord 解析度分格式坐标 (DDMM.MMMM / DDDMM.MMMM) 及半球
+func parseNMEACoord(value, hemisphere string) (float64, error) {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid coordinate %q", value)
+	}
+	degrees := float64(int(v / 100))
+	coord := degrees + (v-degrees*100)/60
+	switch hemisphere {
+	case "S", "W":
+		coord = -coord
+	case "N", "E":
+	default:
+		return 0, fmt.Errorf("invalid hemisphere %q", hemisphere)
+	}
+	return coord, nil
+}
+
+// parseDateTime 解析 DDMMYY 日期和 HHMMSS 时间
+func (a *H02Adapter) parseDateTime(dateStr, timeStr string) (time.Time, error) {
+	if len(dateStr) != 6 || len(timeStr) < 6 {
+		return time.Time{}, fmt.Errorf("invalid datetime: %s %s", dateStr, timeStr)
+	}
+	var f [6]int
+	for i, s := range []string{dateStr, timeStr[:6]} {
+		for j := 0; j < 3; j++ {
+			n, err := strconv.Atoi(s[j*2 : j*2+2])
+			if err != nil {
+				return time.Time{}, fmt.Errorf("invalid datetime: %s %s", dateStr, timeStr)
+			}
+			f[i*3+j] = n
+		}
+	}
+	return a.clock.Date(2000+f[2], f[1], f[0], f[3], f[4], f[5])
+}
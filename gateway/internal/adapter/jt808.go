@@ -2,10 +2,14 @@ package adapter
 
 import (
 	"bytes"
+	"crypto/rsa"
 	"encoding/binary"
-	"encoding/hex"
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"openfms/gateway/internal/protocol"
@@ -18,19 +22,142 @@ const (
 	// Message IDs
 	MsgIDTerminalAuth     uint16 = 0x0102
 	MsgIDLocationReport   uint16 = 0x0200
+	MsgIDLocationBatch    uint16 = 0x0704
 	MsgIDHeartbeat        uint16 = 0x0002
 	MsgIDTerminalRegister uint16 = 0x0100
 
 	// Server response IDs
-	MsgIDPlatformGeneralAck uint16 = 0x8001
+	MsgIDPlatformGeneralAck  uint16 = 0x8001
+	MsgIDRetransmitRequest   uint16 = 0x8003
+	MsgIDTerminalRegisterAck uint16 = 0x8100
+
+	// Protocol versions, detected per packet from the body properties
+	JT808Version2013 = "2013"
+	JT808Version2019 = "2019"
+
+	// Body properties: length(10) + encryption(3) + subpackage(1) + version flag(1)
+	jt808PropsLengthMask  uint16 = 0x03FF
+	jt808PropsEncryptMask uint16 = 0x1C00
+	jt808PropsSubpackage  uint16 = 0x2000
+	jt808PropsVersionFlag uint16 = 0x4000
+
+	// Terminal phone width in BCD bytes
+	jt808PhoneLen2013 = 6
+	jt808PhoneLen2019 = 10
 )
 
-// JT808Adapter implements ProtocolAdapter for JT808 protocol
-type JT808Adapter struct{}
+// jt808Header is the decoded message header of a JT808 packet
+type jt808Header struct {
+	MsgID     uint16
+	BodyProps uint16
+	Version   string // JT808Version2013 or JT808Version2019
+	ProtoVer  byte   // protocol version byte, 2019 only
+	Phone     []byte // raw BCD terminal phone
+	Serial    uint16
+	PkgTotal  uint16 // subpackage total, 0 if not subpackaged
+	PkgIndex  uint16 // subpackage index, starts from 1
+	Len       int    // header length in bytes
+}
+
+// BodyLen returns body length declared in body properties
+func (h *jt808Header) BodyLen() int {
+	return int(h.BodyProps & jt808PropsLengthMask)
+}
+
+// Subpackaged reports whether the message is split into several packets
+func (h *jt808Header) Subpackaged() bool {
+	return h.BodyProps&jt808PropsSubpackage != 0
+}
+
+// Encryption returns the encryption bits of body properties
+func (h *jt808Header) Encryption() uint16 {
+	return (h.BodyProps & jt808PropsEncryptMask) >> 10
+}
+
+// JT808Adapter implements ProtocolAdapter for JT808 protocol.
+// An adapter instance serves a single terminal connection and remembers
+// the protocol version and phone number the terminal uses.
+type JT808Adapter struct {
+	mu          sync.Mutex
+	options     JT808Options
+	version     string
+	protoVer    byte
+	phone       []byte
+	terminalKey *rsa.PublicKey // uploaded by the terminal in 0x0A00
+	write       func(packet []byte) error
+	fragments   *jt808Reassembler
+	serial      uint16                   // next platform message serial
+	outstanding map[uint16]jt808Outgoing // platform serial -> command awaiting reply
+
+	alarmFlag      uint32 // alarm flag of the last live location
+	blindAlarmFlag uint32 // alarm flag of the last blind area backfill location
+	alarmStore     protocol.AlarmStore
+	alarmDevice    string // device whose flags were loaded from alarmStore
+}
+
+// jt808DefaultLocation is the GMT+8 timezone of JT808 terminal clocks
+var jt808DefaultLocation = time.FixedZone("GMT+8", 8*3600)
+
+func init() {
+	Register(Registration{
+		Name: "JT808",
+		Detect: func(header []byte) bool {
+			return len(header) > 0 && header[0] == JT808Header
+		},
+		New: func(opts Options) protocol.ProtocolAdapter {
+			return NewJT808AdapterWithOptions(opts.JT808)
+		},
+	})
+}
 
 // NewJT808Adapter creates a new JT808 adapter
 func NewJT808Adapter() *JT808Adapter {
-	return &JT808Adapter{}
+	return NewJT808AdapterWithOptions(JT808Options{
+		Clock: DeviceClock{MaxSkew: DefaultMaxClockSkew},
+	})
+}
+
+// NewJT808AdapterWithOptions creates a new JT808 adapter with options
+func NewJT808AdapterWithOptions(opts JT808Options) *JT808Adapter {
+	if opts.Clock.Location == nil {
+		opts.Clock.Location = jt808DefaultLocation
+	}
+	j := &JT808Adapter{
+		options: opts,
+		version: JT808Version2013,
+	}
+	j.fragments = newJT808Reassembler(j)
+	return j
+}
+
+// SetWriter implements protocol.Outbound
+func (j *JT808Adapter) SetWriter(write func(packet []byte) error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.write = write
+}
+
+// SetAlarmStore implements protocol.AlarmTracker
+func (j *JT808Adapter) SetAlarmStore(store protocol.AlarmStore) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.alarmStore = store
+}
+
+// Close implements protocol.Closer, dropping unfinished subpackaged messages
+func (j *JT808Adapter) Close() {
+	j.fragments.Close()
+}
+
+// send writes a frame to the terminal if a writer is attached
+func (j *JT808Adapter) send(packet []byte) error {
+	j.mu.Lock()
+	write := j.write
+	j.mu.Unlock()
+	if write == nil {
+		return errors.New("no writer attached")
+	}
+	return write(packet)
 }
 
 // Protocol returns protocol identifier
@@ -38,7 +165,8 @@ func (j *JT808Adapter) Protocol() string {
 	return "JT808"
 }
 
-// Decode translates JT808 packet to standard message
+// Decode translates JT808 packet to standard message.
+// For a subpackaged message it returns nil until all packages have arrived.
 func (j *JT808Adapter) Decode(packet []byte) (*protocol.StandardMessage, error) {
 	if len(packet) < 12 {
 		return nil, errors.New("packet too short")
@@ -60,34 +188,50 @@ func (j *JT808Adapter) Decode(packet []byte) (*protocol.StandardMessage, error)
 		return nil, errors.New("checksum mismatch")
 	}
 
-	// Parse message header
-	msgID := binary.BigEndian.Uint16(content[0:2])
-	bodyProps := binary.BigEndian.Uint16(content[2:4])
-	phoneNum := bcdToString(content[4:10])
-	msgSerial := binary.BigEndian.Uint16(content[10:12])
-
-	// Check if body is encrypted (simplified - assume no encryption for now)
-	_ = bodyProps
-	_ = msgSerial
+	// Parse message header (2013 or 2019 layout)
+	header, err := parseJT808Header(content)
+	if err != nil {
+		return nil, err
+	}
+	msgID := header.MsgID
+	j.remember(header)
+
+	// Body starts after header and ends before checksum
+	body := content[header.Len : len(content)-1]
+
+	// Decrypt RSA encrypted bodies transparently
+	switch header.Encryption() {
+	case 0:
+	case jt808EncryptRSA:
+		if body, err = j.decryptBody(body); err != nil {
+			return nil, fmt.Errorf("decrypt 0x%04X: %w", msgID, err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported encryption 0x%X", header.Encryption())
+	}
 
-	// Body starts after header (12 bytes)
-	body := content[12 : len(content)-1]
+	// Buffer subpackages until the whole body is reassembled
+	if header.Subpackaged() {
+		header, body, err = j.fragments.Add(header, body)
+		if err != nil || header == nil {
+			return nil, err
+		}
+	}
 
+	receivedAt := time.Now().Unix()
 	msg := &protocol.StandardMessage{
-		DeviceID:  phoneNum,
-		Timestamp: time.Now().Unix(),
+		DeviceID:  normalizePhone(bcdToString(header.Phone)),
+		Timestamp: receivedAt,
 		Extras:    make(map[string]interface{}),
 	}
+	msg.Extras["received_at"] = receivedAt
+	msg.Extras["protocol_version"] = header.Version
+	msg.Extras["msg_serial"] = header.Serial
 
 	switch msgID {
 	case MsgIDTerminalAuth:
 		msg.Type = protocol.MsgTypeAuth
-		if len(body) > 0 {
-			authCodeLen := int(body[0])
-			if len(body) >= 1+authCodeLen {
-				msg.Extras["auth_code"] = string(body[1 : 1+authCodeLen])
-			}
-		}
+		j.parseAuth(header, body, msg)
 
 	case MsgIDLocationReport:
 		msg.Type = protocol.MsgTypeLocation
@@ -95,22 +239,55 @@ func (j *JT808Adapter) Decode(packet []byte) (*protocol.StandardMessage, error)
 			return nil, err
 		}
 
+	case MsgIDLocationBatch:
+		msg.Type = protocol.MsgTypeBatch
+		if err := j.parseLocationBatch(body, msg); err != nil {
+			return nil, err
+		}
+
 	case MsgIDHeartbeat:
 		msg.Type = protocol.MsgTypeHeartbeat
 
 	case MsgIDTerminalRegister:
-		msg.Type = "REGISTER"
-		if len(body) >= 10 {
-			msg.Extras["province_id"] = binary.BigEndian.Uint16(body[0:2])
-			msg.Extras["city_id"] = binary.BigEndian.Uint16(body[2:4])
-			msg.Extras["manufacturer_id"] = string(body[4:9])
-			msg.Extras["terminal_model"] = string(body[9:17])
+		msg.Type = protocol.MsgTypeRegister
+		j.parseRegister(header, body, msg)
+
+	case MsgIDTerminalGeneralAck:
+		msg.Type = "TERMINAL_ACK"
+		j.parseTerminalAck(body, msg)
+
+	case MsgIDQueryParamsReply:
+		msg.Type = protocol.MsgTypeCommandResponse
+		if err := j.parseQueryParamsReply(body, msg); err != nil {
+			return nil, err
+		}
+
+	case MsgIDLocationQueryReply, MsgIDVehicleControlReply:
+		msg.Type = protocol.MsgTypeLocation
+		if err := j.parseLocationReply(body, msg); err != nil {
+			return nil, err
+		}
+
+	case MsgIDTerminalRSAKey:
+		msg.Type = "RSA_KEY"
+		if err := j.handleTerminalRSAKey(header, body, msg); err != nil {
+			return nil, err
 		}
 
 	default:
 		msg.Type = fmt.Sprintf("UNKNOWN_0x%04X", msgID)
 	}
 
+	// Turn alarm flag changes into ALARM messages, in time order for batches
+	switch msg.Type {
+	case protocol.MsgTypeLocation:
+		j.raiseAlarms(msg)
+	case protocol.MsgTypeBatch:
+		for _, item := range msg.Items {
+			j.raiseAlarms(item)
+		}
+	}
+
 	return msg, nil
 }
 
@@ -119,6 +296,24 @@ func (j *JT808Adapter) Encode(cmd protocol.StandardCommand) ([]byte, error) {
 	switch cmd.Type {
 	case "GENERAL_ACK":
 		return j.encodeGeneralAck(cmd.Params)
+	case "REGISTER_ACK":
+		return j.encodeRegisterAck(cmd.Params)
+	case "PLATFORM_RSA_KEY":
+		return j.encodePlatformRSAKey(cmd.Params)
+	case protocol.CmdSetParams:
+		return j.encodeSetParams(cmd)
+	case protocol.CmdGetParams, protocol.CmdQueryParams:
+		return j.encodeQueryParams(cmd)
+	case protocol.CmdTerminalControl:
+		return j.encodeTerminalControl(cmd)
+	case protocol.CmdLocationQuery:
+		return j.encodeLocationQuery(cmd)
+	case protocol.CmdTempTracking:
+		return j.encodeTempTracking(cmd)
+	case protocol.CmdTextMessage:
+		return j.encodeTextMessage(cmd)
+	case protocol.CmdVehicleControl:
+		return j.encodeVehicleControl(cmd)
 	default:
 		return nil, fmt.Errorf("unsupported command type: %s", cmd.Type)
 	}
@@ -139,20 +334,88 @@ func (j *JT808Adapter) IsHeartbeat(packet []byte) bool {
 
 // GenerateHeartbeatAck creates heartbeat acknowledgment
 func (j *JT808Adapter) GenerateHeartbeatAck(packet []byte) ([]byte, error) {
-	// Parse original packet to get phone number and serial
+	// Parse original packet to get version, phone number and serial
 	unescaped := j.unescape(packet)
-	content := unescaped[1 : len(unescaped)-1]
-
-	phoneNum := content[4:10]
-	msgSerial := content[10:12]
+	if len(unescaped) < 2 {
+		return nil, errors.New("packet too short")
+	}
+	header, err := parseJT808Header(unescaped[1 : len(unescaped)-1])
+	if err != nil {
+		return nil, err
+	}
 
-	// Build ACK: MsgID(0x8001) + BodyProps + Phone + Serial + Result(0)
+	// Build ACK body: Original MsgID + Original Serial + Result(0)
 	ackBody := make([]byte, 5)
-	copy(ackBody[0:2], content[0:2]) // Original MsgID
-	copy(ackBody[2:4], msgSerial)    // Original Serial
-	ackBody[4] = 0                   // Result: 0 = success
+	binary.BigEndian.PutUint16(ackBody[0:2], header.MsgID)
+	binary.BigEndian.PutUint16(ackBody[2:4], header.Serial)
+	ackBody[4] = 0 // Result: 0 = success
+
+	return j.buildPacket(header.Version, MsgIDPlatformGeneralAck, header.Phone, ackBody)
+}
+
+// Version returns the protocol version last used by the terminal
+func (j *JT808Adapter) Version() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.version
+}
+
+// remember records version and phone of the terminal so that replies and
+// downlink commands are encoded in the same layout the terminal uses
+func (j *JT808Adapter) remember(header *jt808Header) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.version = header.Version
+	j.protoVer = header.ProtoVer
+	j.phone = append(j.phone[:0], header.Phone...)
+}
+
+// parseJT808Header parses the message header from unescaped content
+// (without 0x7E markers). 2013 header is 12 bytes, 2019 header is 17 bytes,
+// both followed by 4 bytes of subpackage info when the subpackage bit is set.
+func parseJT808Header(content []byte) (*jt808Header, error) {
+	if len(content) < 12 {
+		return nil, errors.New("header too short")
+	}
+
+	h := &jt808Header{
+		MsgID:     binary.BigEndian.Uint16(content[0:2]),
+		BodyProps: binary.BigEndian.Uint16(content[2:4]),
+		Version:   JT808Version2013,
+	}
+
+	offset := 4
+	phoneLen := jt808PhoneLen2013
+	if h.BodyProps&jt808PropsVersionFlag != 0 {
+		h.Version = JT808Version2019
+		h.ProtoVer = content[4]
+		phoneLen = jt808PhoneLen2019
+		offset = 5
+	}
+
+	if len(content) < offset+phoneLen+2 {
+		return nil, errors.New("header too short")
+	}
+	h.Phone = content[offset : offset+phoneLen]
+	offset += phoneLen
+	h.Serial = binary.BigEndian.Uint16(content[offset : offset+2])
+	offset += 2
+
+	if h.Subpackaged() {
+		if len(content) < offset+4 {
+			return nil, errors.New("subpackage header too short")
+		}
+		h.PkgTotal = binary.BigEndian.Uint16(content[offset : offset+2])
+		h.PkgIndex = binary.BigEndian.Uint16(content[offset+2 : offset+4])
+		offset += 4
+	}
 
-	return j.buildPacket(MsgIDPlatformGeneralAck, phoneNum, ackBody), nil
+	h.Len = offset
+	// Header + checksum must fit
+	if len(content) < h.Len+1 {
+		return nil, errors.New("packet too short")
+	}
+	return h, nil
 }
 
 // Helper functions
@@ -210,16 +473,42 @@ func (j *JT808Adapter) calculateChecksum(data []byte) byte {
 	return checksum
 }
 
-func (j *JT808Adapter) buildPacket(msgID uint16, phoneNum []byte, body []byte) []byte {
-	// Header: MsgID(2) + BodyProps(2) + Phone(6) + Serial(2) = 12 bytes
-	header := make([]byte, 12)
-	binary.BigEndian.PutUint16(header[0:2], msgID)
-	// Body properties: length + encryption + subpackage
-	bodyProps := uint16(len(body)) & 0x03FF
-	binary.BigEndian.PutUint16(header[2:4], bodyProps)
-	copy(header[4:10], phoneNum)
-	// Use serial 0 for now
-	binary.BigEndian.PutUint16(header[10:12], 0)
+func (j *JT808Adapter) buildPacket(version string, msgID uint16, phoneNum []byte, body []byte) ([]byte, error) {
+	packet, _, err := j.buildPacketSerial(version, msgID, phoneNum, body)
+	return packet, err
+}
+
+// buildPacketSerial builds a packet and returns the platform serial it used
+func (j *JT808Adapter) buildPacketSerial(version string, msgID uint16, phoneNum []byte, body []byte) ([]byte, uint16, error) {
+	// Encrypt body for terminals that exchanged an RSA key
+	body, encrypted, err := j.encryptBody(msgID, body)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(body) > int(jt808PropsLengthMask) {
+		return nil, 0, fmt.Errorf("body of 0x%04X too long: %d bytes", msgID, len(body))
+	}
+
+	// 2013 header: MsgID(2) + BodyProps(2) + Phone(6) + Serial(2) = 12 bytes
+	// 2019 header: MsgID(2) + BodyProps(2) + ProtoVer(1) + Phone(10) + Serial(2) = 17 bytes
+	header := make([]byte, 0, 17)
+	header = binary.BigEndian.AppendUint16(header, msgID)
+	// Body properties: length + encryption + subpackage + version flag
+	bodyProps := uint16(len(body)) & jt808PropsLengthMask
+	if encrypted {
+		bodyProps |= jt808EncryptRSA << 10
+	}
+	if version == JT808Version2019 {
+		bodyProps |= jt808PropsVersionFlag
+		header = binary.BigEndian.AppendUint16(header, bodyProps)
+		header = append(header, j.protocolVersionByte())
+		header = append(header, padBCD(phoneNum, jt808PhoneLen2019)...)
+	} else {
+		header = binary.BigEndian.AppendUint16(header, bodyProps)
+		header = append(header, padBCD(phoneNum, jt808PhoneLen2013)...)
+	}
+	serial := j.nextSerial()
+	header = binary.BigEndian.AppendUint16(header, serial)
 
 	// Combine header + body
 	content := append(header, body...)
@@ -233,7 +522,95 @@ func (j *JT808Adapter) buildPacket(msgID uint16, phoneNum []byte, body []byte) [
 	packet := append([]byte{JT808Header}, escaped...)
 	packet = append(packet, JT808Header)
 
-	return packet
+	return packet, serial, nil
+}
+
+// protocolVersionByte returns the 2019 protocol version byte to send,
+// echoing the terminal's value (1 for the first 2019 revision)
+func (j *JT808Adapter) protocolVersionByte() byte {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.protoVer == 0 {
+		return 1
+	}
+	return j.protoVer
+}
+
+// replyTarget resolves version and BCD phone for an outgoing packet:
+// explicit "version"/"phone" params win, otherwise the terminal's last seen values
+func (j *JT808Adapter) replyTarget(params map[string]interface{}) (string, []byte) {
+	j.mu.Lock()
+	version := j.version
+	phone := append([]byte(nil), j.phone...)
+	j.mu.Unlock()
+
+	if v, ok := params["version"].(string); ok && (v == JT808Version2013 || v == JT808Version2019) {
+		version = v
+	}
+	width := jt808PhoneLen2013
+	if version == JT808Version2019 {
+		width = jt808PhoneLen2019
+	}
+	if p, ok := params["phone"].(string); ok && p != "" {
+		phone = phoneToBCD(p, width)
+	}
+	return version, padBCD(phone, width)
+}
+
+func (j *JT808Adapter) parseAuth(header *jt808Header, body []byte, msg *protocol.StandardMessage) {
+	if len(body) == 0 {
+		return
+	}
+
+	// 2013: auth code fills the whole body
+	if header.Version == JT808Version2013 {
+		msg.Extras["auth_code"] = trimField(body)
+		return
+	}
+
+	// 2019: AuthLen(1) + AuthCode(n) + IMEI(15) + SoftwareVersion(20)
+	authCodeLen := int(body[0])
+	if len(body) < 1+authCodeLen {
+		return
+	}
+	msg.Extras["auth_code"] = string(body[1 : 1+authCodeLen])
+	rest := body[1+authCodeLen:]
+	if len(rest) >= 15 {
+		msg.Extras["imei"] = trimField(rest[0:15])
+		rest = rest[15:]
+	}
+	if len(rest) >= 20 {
+		msg.Extras["software_version"] = trimField(rest[0:20])
+	}
+}
+
+func (j *JT808Adapter) parseRegister(header *jt808Header, body []byte, msg *protocol.StandardMessage) {
+	// 2013: Province(2) + City(2) + Manufacturer(5) + Model(20) + TerminalID(7) + PlateColor(1) + Plate
+	// 2019: Province(2) + City(2) + Manufacturer(11) + Model(30) + TerminalID(30) + PlateColor(1) + Plate
+	manufacturerLen, modelLen, terminalIDLen := 5, 20, 7
+	if header.Version == JT808Version2019 {
+		manufacturerLen, modelLen, terminalIDLen = 11, 30, 30
+	}
+
+	fixedLen := 4 + manufacturerLen + modelLen + terminalIDLen + 1
+	if len(body) < fixedLen {
+		return
+	}
+
+	msg.Extras["province_id"] = binary.BigEndian.Uint16(body[0:2])
+	msg.Extras["city_id"] = binary.BigEndian.Uint16(body[2:4])
+	offset := 4
+	msg.Extras["manufacturer_id"] = trimField(body[offset : offset+manufacturerLen])
+	offset += manufacturerLen
+	msg.Extras["terminal_model"] = trimField(body[offset : offset+modelLen])
+	offset += modelLen
+	msg.Extras["terminal_id"] = trimField(body[offset : offset+terminalIDLen])
+	offset += terminalIDLen
+	msg.Extras["plate_color"] = body[offset]
+	offset++
+	if offset < len(body) {
+		msg.Extras["plate_number"] = decodeGBK([]byte(trimField(body[offset:])))
+	}
 }
 
 func (j *JT808Adapter) parseLocation(body []byte, msg *protocol.StandardMessage) error {
@@ -244,6 +621,7 @@ func (j *JT808Adapter) parseLocation(body []byte, msg *protocol.StandardMessage)
 	// Alarm flag (4 bytes)
 	alarmFlag := binary.BigEndian.Uint32(body[0:4])
 	msg.Extras["alarm_flag"] = alarmFlag
+	msg.Extras["alarms"] = decodeAlarmFlag(alarmFlag)
 
 	// Status (4 bytes)
 	status := binary.BigEndian.Uint32(body[4:8])
@@ -271,9 +649,15 @@ func (j *JT808Adapter) parseLocation(body []byte, msg *protocol.StandardMessage)
 	direction := binary.BigEndian.Uint16(body[20:22])
 	msg.Direction = float64(direction)
 
-	// Time (6 bytes) - BCD: YYMMDDHHMMSS
+	// Time (6 bytes) - BCD: YYMMDDHHMMSS in the terminal timezone (GMT+8).
+	// Invalid times (no fix yet) keep the receive time.
 	timeStr := bcdToString(body[22:28])
 	msg.Extras["gps_time"] = timeStr
+	if deviceTime, err := j.parseBCDTime(timeStr); err == nil {
+		j.options.Clock.Stamp(msg, deviceTime)
+	} else {
+		msg.Extras["gps_time_invalid"] = true
+	}
 
 	// Parse additional info (28 bytes onwards)
 	if len(body) > 28 {
@@ -283,72 +667,173 @@ func (j *JT808Adapter) parseLocation(body []byte, msg *protocol.StandardMessage)
 	return nil
 }
 
-func (j *JT808Adapter) parseLocationExtras(data []byte, msg *protocol.StandardMessage) {
-	for len(data) >= 2 {
-		id := data[0]
-		length := int(data[1])
+// parseLocationBatch splits 0x0704 into location messages:
+// Count(2) + Type(1, 0 normal / 1 blind area) + [Length(2) + 0x0200 body]...
+// Items are sorted by GPS time so that backfilled tracks replay in order.
+func (j *JT808Adapter) parseLocationBatch(body []byte, msg *protocol.StandardMessage) error {
+	if len(body) < 3 {
+		return errors.New("location batch body too short")
+	}
+	count := int(binary.BigEndian.Uint16(body[0:2]))
+	blindArea := body[2] == 1
+	msg.Extras["batch_count"] = count
+	msg.Extras["blind_area"] = blindArea
+
+	data := body[3:]
+	for i := 0; i < count; i++ {
+		if len(data) < 2 {
+			return fmt.Errorf("location batch truncated at item %d/%d", i+1, count)
+		}
+		length := int(binary.BigEndian.Uint16(data[0:2]))
 		if len(data) < 2+length {
-			break
+			return fmt.Errorf("location batch item %d/%d truncated", i+1, count)
 		}
-		value := data[2 : 2+length]
 
-		switch id {
-		case 0x01: // Mileage (4 bytes, 0.1 km)
-			if length >= 4 {
-				mileage := binary.BigEndian.Uint32(value[0:4])
-				msg.Extras["mileage"] = float64(mileage) / 10.0
-			}
-		case 0x02: // Fuel (2 bytes, 0.1 L)
-			if length >= 2 {
-				fuel := binary.BigEndian.Uint16(value[0:2])
-				msg.Extras["fuel"] = float64(fuel) / 10.0
-			}
-		case 0x03: // Speed from sensor (2 bytes, 0.1 km/h)
-			if length >= 2 {
-				sensorSpeed := binary.BigEndian.Uint16(value[0:2])
-				msg.Extras["sensor_speed"] = float64(sensorSpeed) / 10.0
-			}
-		case 0x25: // Signal strength
-			if length >= 1 {
-				msg.Extras["signal_strength"] = value[0]
-			}
-		case 0x30: // WiFi signal
-			if length >= 1 {
-				msg.Extras["wifi_signal"] = value[0]
-			}
+		item := &protocol.StandardMessage{
+			DeviceID:  msg.DeviceID,
+			Type:      protocol.MsgTypeLocation,
+			Timestamp: msg.Timestamp,
+			Extras:    make(map[string]interface{}),
 		}
-
+		item.Extras["received_at"] = msg.Extras["received_at"]
+		item.Extras["protocol_version"] = msg.Extras["protocol_version"]
+		item.Extras["msg_serial"] = msg.Extras["msg_serial"]
+		item.Extras["blind_area"] = blindArea
+		if err := j.parseLocation(data[2:2+length], item); err != nil {
+			return fmt.Errorf("location batch item %d/%d: %w", i+1, count, err)
+		}
+		msg.Items = append(msg.Items, item)
 		data = data[2+length:]
 	}
+
+	sort.SliceStable(msg.Items, func(a, b int) bool {
+		return msg.Items[a].Timestamp < msg.Items[b].Timestamp
+	})
+	return nil
+}
+
+// parseBCDTime parses the YYMMDDHHMMSS location time
+func (j *JT808Adapter) parseBCDTime(s string) (time.Time, error) {
+	if len(s) != 12 {
+		return time.Time{}, errors.New("invalid BCD time")
+	}
+	var f [6]int
+	for i := range f {
+		n, err := strconv.Atoi(s[i*2 : i*2+2])
+		if err != nil {
+			return time.Time{}, errors.New("invalid BCD time")
+		}
+		f[i] = n
+	}
+	return j.options.Clock.Date(2000+f[0], f[1], f[2], f[3], f[4], f[5])
 }
 
 func (j *JT808Adapter) encodeGeneralAck(params map[string]interface{}) ([]byte, error) {
 	body := make([]byte, 5)
 
 	// Original MsgID
-	if msgID, ok := params["msg_id"].(uint16); ok {
-		binary.BigEndian.PutUint16(body[0:2], msgID)
+	if msgID, ok := paramUint(params, "msg_id"); ok {
+		binary.BigEndian.PutUint16(body[0:2], uint16(msgID))
 	}
 
 	// Original Serial
-	if serial, ok := params["serial"].(uint16); ok {
-		binary.BigEndian.PutUint16(body[2:4], serial)
+	if serial, ok := paramUint(params, "serial"); ok {
+		binary.BigEndian.PutUint16(body[2:4], uint16(serial))
 	}
 
 	// Result: 0 = success, 1 = fail, 2 = msg error, 3 = not supported
-	body[4] = 0
-
-	phoneNum := make([]byte, 6)
-	if phone, ok := params["phone"].(string); ok && len(phone) == 12 {
-		// Convert BCD
-		for i := 0; i < 6; i++ {
-			high := phone[i*2] - '0'
-			low := phone[i*2+1] - '0'
-			phoneNum[i] = (high << 4) | low
-		}
+	if result, ok := paramUint(params, "result"); ok {
+		body[4] = byte(result)
+	}
+
+	version, phoneNum := j.replyTarget(params)
+	return j.buildPacket(version, MsgIDPlatformGeneralAck, phoneNum, body)
+}
+
+func (j *JT808Adapter) encodeRegisterAck(params map[string]interface{}) ([]byte, error) {
+	// Body: Original Serial(2) + Result(1) + AuthCode(n, only on success)
+	body := make([]byte, 3)
+	if serial, ok := paramUint(params, "serial"); ok {
+		binary.BigEndian.PutUint16(body[0:2], uint16(serial))
+	}
+
+	// Result: 0 = success, 1 = vehicle registered, 2 = no such vehicle,
+	// 3 = terminal registered, 4 = no such terminal
+	if result, ok := paramUint(params, "result"); ok {
+		body[2] = byte(result)
+	}
+	if body[2] == 0 {
+		authCode, _ := params["auth_code"].(string)
+		body = append(body, authCode...)
+	}
+
+	version, phoneNum := j.replyTarget(params)
+	return j.buildPacket(version, MsgIDTerminalRegisterAck, phoneNum, body)
+}
+
+func (j *JT808Adapter) encodePlatformRSAKey(params map[string]interface{}) ([]byte, error) {
+	if j.options.PlatformKey == nil {
+		return nil, errors.New("no platform RSA key configured")
+	}
+	version, phoneNum := j.replyTarget(params)
+	return j.buildPacket(version, MsgIDPlatformRSAKey, phoneNum, encodeRSAKey(&j.options.PlatformKey.PublicKey))
+}
+
+// paramUint reads a non-negative integer command parameter, accepting the
+// numeric types produced by both Go callers and JSON decoding
+func paramUint(params map[string]interface{}, key string) (uint64, bool) {
+	return toUint(params[key])
+}
+
+// toUint converts a numeric value to uint64
+func toUint(value interface{}) (uint64, bool) {
+	switch v := value.(type) {
+	case uint8:
+		return uint64(v), true
+	case uint16:
+		return uint64(v), true
+	case uint32:
+		return uint64(v), true
+	case uint64:
+		return v, true
+	case int:
+		return uint64(v), v >= 0
+	case int64:
+		return uint64(v), v >= 0
+	case float64:
+		return uint64(v), v >= 0
+	default:
+		return 0, false
 	}
+}
+
+// trimField converts a fixed-width string field, dropping 0x00/space padding
+func trimField(data []byte) string {
+	return strings.TrimRight(string(bytes.TrimRight(data, "\x00")), " ")
+}
+
+// normalizePhone strips the extra zero padding of 2019 20-digit phones so
+// that a terminal keeps the same device ID across protocol versions
+func normalizePhone(phone string) string {
+	for len(phone) > 12 && phone[0] == '0' {
+		phone = phone[1:]
+	}
+	return phone
+}
+
+// phoneToBCD encodes a phone number as BCD, left-padded to width bytes
+func phoneToBCD(phone string, width int) []byte {
+	return padBCD(stringToBCD(phone), width)
+}
 
-	return j.buildPacket(MsgIDPlatformGeneralAck, phoneNum, body), nil
+// padBCD left-pads (or left-truncates) BCD bytes to width
+func padBCD(bcd []byte, width int) []byte {
+	if len(bcd) >= width {
+		return bcd[len(bcd)-width:]
+	}
+	result := make([]byte, width)
+	copy(result[width-len(bcd):], bcd)
+	return result
 }
 
 // bcdToString converts BCD encoded bytes to string
@@ -383,26 +868,54 @@ func stringToBCD(s string) []byte {
 	return result
 }
 
+// Scanner returns the JT808 packet scanner
+func (j *JT808Adapter) Scanner() protocol.PacketScanner {
+	return JT808Scanner{}
+}
+
+// JT808Scanner frames JT808 packets delimited by 0x7E
+type JT808Scanner struct{}
+
+// Scan extracts the next 0x7E ... 0x7E packet, still escaped
+func (JT808Scanner) Scan(buffer []byte) ([]byte, []byte, error) {
+	start := bytes.IndexByte(buffer, JT808Header)
+	if start == -1 {
+		// No start marker, nothing worth keeping
+		return nil, nil, nil
+	}
+	end := bytes.IndexByte(buffer[start+1:], JT808Header)
+	if end == -1 {
+		// Incomplete packet
+		return nil, buffer[start:], nil
+	}
+	end += start + 1
+	return buffer[start : end+1], buffer[end+1:], nil
+}
+
 // JT808Detector implements protocol detection for JT808
 type JT808Detector struct {
-	adapter *JT808Adapter
+	options JT808Options
 }
 
 // NewJT808Detector creates a new JT808 detector
 func NewJT808Detector() *JT808Detector {
-	return &JT808Detector{
-		adapter: NewJT808Adapter(),
-	}
+	return &JT808Detector{}
+}
+
+// NewJT808DetectorWithOptions creates a JT808 detector whose adapters use opts
+func NewJT808DetectorWithOptions(opts JT808Options) *JT808Detector {
+	return &JT808Detector{options: opts}
 }
 
-// Match detects JT808 protocol from header bytes
+// Match detects JT808 protocol from header bytes.
+// Each match returns a fresh adapter since JT808 keeps per-terminal state.
 func (d *JT808Detector) Match(headerBytes []byte) (protocol.ProtocolAdapter, bool) {
 	if len(headerBytes) < 1 {
 		return nil, false
 	}
 	// JT808 packets start with 0x7E
 	if headerBytes[0] == JT808Header {
-		return d.adapter, true
+		return NewJT808AdapterWithOptions(d.options), true
 	}
 	return nil, false
 }
@@ -0,0 +1,141 @@
+package adapter
+
+import (
+	"openfms/gateway/internal/protocol"
+)
+
+// jt808AlarmBit maps a bit of the 0x0200 alarm flag to an alarm type
+type jt808AlarmBit struct {
+	Bit  uint
+	Type string
+}
+
+// jt808AlarmBits lists the standard alarm flag bits of JT/T 808
+var jt808AlarmBits = []jt808AlarmBit{
+	{0, protocol.AlarmSOS},
+	{1, protocol.AlarmOverspeed},
+	{2, protocol.AlarmFatigueDriving},
+	{3, protocol.AlarmDangerWarning},
+	{4, protocol.AlarmGNSSFault},
+	{5, protocol.AlarmGNSSAntennaCut},
+	{6, protocol.AlarmGNSSAntennaShort},
+	{7, protocol.AlarmPowerUndervoltage},
+	{8, protocol.AlarmPowerCut},
+	{9, protocol.AlarmLCDFault},
+	{10, protocol.AlarmTTSFault},
+	{11, protocol.AlarmCameraFault},
+	{12, protocol.AlarmICCardFault},
+	{13, protocol.AlarmOverspeedWarning},
+	{14, protocol.AlarmFatigueWarning},
+	{18, protocol.AlarmDrivingTimeout},
+	{19, protocol.AlarmParkingTimeout},
+	{20, protocol.AlarmAreaInOut},
+	{21, protocol.AlarmRouteInOut},
+	{22, protocol.AlarmRouteDrivingTime},
+	{23, protocol.AlarmRouteDeviation},
+	{24, protocol.AlarmVSSFault},
+	{25, protocol.AlarmFuelAbnormal},
+	{26, protocol.AlarmTheft},
+	{27, protocol.AlarmIllegalIgnition},
+	{28, protocol.AlarmIllegalMove},
+	{29, protocol.AlarmCollision},
+	{30, protocol.AlarmRollover},
+	{31, protocol.AlarmIllegalDoorOpen},
+}
+
+// decodeAlarmFlag returns the alarm types set in a 0x0200 alarm flag
+func decodeAlarmFlag(flag uint32) []string {
+	alarms := []string{}
+	for _, b := range jt808AlarmBits {
+		if flag&(1<<b.Bit) != 0 {
+			alarms = append(alarms, b.Type)
+		}
+	}
+	return alarms
+}
+
+// Names of the JT808 alarm flags in the alarm store
+const (
+	jt808AlarmFlagLive  = "live"
+	jt808AlarmFlagBlind = "blind"
+)
+
+// raiseAlarms compares the alarm flag of a location with the previous one of
+// the terminal and attaches an ALARM message for every bit that was raised
+// (rising) or cleared (falling). Blind area backfill is tracked separately
+// since it replays older positions after the live ones. The previous flags
+// come from the alarm store on the first location of a connection.
+func (j *JT808Adapter) raiseAlarms(msg *protocol.StandardMessage) {
+	flag, ok := msg.Extras["alarm_flag"].(uint32)
+	if !ok {
+		return
+	}
+	blindArea, _ := msg.Extras["blind_area"].(bool)
+	j.loadAlarmFlags(msg.DeviceID)
+
+	j.mu.Lock()
+	name, last := jt808AlarmFlagLive, &j.alarmFlag
+	if blindArea {
+		name, last = jt808AlarmFlagBlind, &j.blindAlarmFlag
+	}
+	changed := flag ^ *last
+	*last = flag
+	store := j.alarmStore
+	j.mu.Unlock()
+
+	if changed == 0 {
+		return
+	}
+	if store != nil && msg.DeviceID != "" {
+		store.SaveAlarmFlag(msg.DeviceID, name, flag)
+	}
+	for _, b := range jt808AlarmBits {
+		mask := uint32(1) << b.Bit
+		if changed&mask == 0 {
+			continue
+		}
+		edge := protocol.AlarmEdgeFalling
+		if flag&mask != 0 {
+			edge = protocol.AlarmEdgeRising
+		}
+
+		alarm := &protocol.StandardMessage{
+			DeviceID:  msg.DeviceID,
+			Type:      protocol.MsgTypeAlarm,
+			Timestamp: msg.Timestamp,
+			Lat:       msg.Lat,
+			Lon:       msg.Lon,
+			Speed:     msg.Speed,
+			Direction: msg.Direction,
+			Extras:    make(map[string]interface{}),
+		}
+		alarm.Extras["alarm_type"] = b.Type
+		alarm.Extras["alarm_edge"] = edge
+		alarm.Extras["alarm_bit"] = b.Bit
+		alarm.Extras["alarm_flag"] = flag
+		alarm.Extras["received_at"] = msg.Extras["received_at"]
+		alarm.Extras["gps_time"] = msg.Extras["gps_time"]
+		if blindArea {
+			alarm.Extras["blind_area"] = true
+		}
+		msg.Alarms = append(msg.Alarms, alarm)
+	}
+}
+
+// loadAlarmFlags seeds the previous alarm flags of a device from the alarm
+// store, once per connection
+func (j *JT808Adapter) loadAlarmFlags(deviceID string) {
+	j.mu.Lock()
+	store, loaded := j.alarmStore, j.alarmDevice == deviceID
+	j.mu.Unlock()
+	if store == nil || loaded || deviceID == "" {
+		return
+	}
+
+	flags := store.LoadAlarmFlags(deviceID)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.alarmDevice = deviceID
+	j.alarmFlag = flags[jt808AlarmFlagLive]
+	j.blindAlarmFlag = flags[jt808AlarmFlagBlind]
+}
@@ -0,0 +1,441 @@
+package adapter
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"openfms/gateway/internal/protocol"
+)
+
+const (
+	// Terminal response IDs
+	MsgIDTerminalGeneralAck  uint16 = 0x0001
+	MsgIDQueryParamsReply    uint16 = 0x0104
+	MsgIDLocationQueryReply  uint16 = 0x0201
+	MsgIDVehicleControlReply uint16 = 0x0500
+
+	// Platform command IDs
+	MsgIDSetParams            uint16 = 0x8103
+	MsgIDQueryParams          uint16 = 0x8104
+	MsgIDTerminalControl      uint16 = 0x8105
+	MsgIDQuerySpecifiedParams uint16 = 0x8106
+	MsgIDLocationQuery        uint16 = 0x8201
+	MsgIDTempTracking         uint16 = 0x8202
+	MsgIDTextMessage          uint16 = 0x8300
+	MsgIDVehicleControl       uint16 = 0x8500
+
+	// Outstanding downlink messages kept for reply matching
+	jt808MaxOutstanding = 256
+	jt808OutstandingTTL = 10 * time.Minute
+)
+
+// jt808Outgoing records a downlink message awaiting the terminal's reply
+type jt808Outgoing struct {
+	MsgID     uint16
+	Command   string
+	CommandID string // API command ID, empty for commands not sent by the API
+	SentAt    time.Time
+}
+
+// jt808AckResults names the result codes of 0x0001
+var jt808AckResults = map[byte]string{
+	0: "success",
+	1: "failed",
+	2: "message error",
+	3: "not supported",
+}
+
+// encodeCommand builds a platform command packet and tracks its serial so
+// the terminal's reply can be matched to the command
+func (j *JT808Adapter) encodeCommand(cmd protocol.StandardCommand, msgID uint16, body []byte) ([]byte, error) {
+	version, phoneNum := j.replyTarget(cmd.Params)
+	if isZeroBCD(phoneNum) {
+		return nil, errors.New("terminal phone unknown")
+	}
+
+	packet, serial, err := j.buildPacketSerial(version, msgID, phoneNum, body)
+	if err != nil {
+		return nil, err
+	}
+	j.track(serial, jt808Outgoing{
+		MsgID:     msgID,
+		Command:   cmd.Type,
+		CommandID: cmd.CommandID,
+		SentAt:    time.Now(),
+	})
+	return packet, nil
+}
+
+// nextSerial returns the next platform message serial of this session
+func (j *JT808Adapter) nextSerial() uint16 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	serial := j.serial
+	j.serial++
+	return serial
+}
+
+// track remembers an outgoing serial, evicting stale entries
+func (j *JT808Adapter) track(serial uint16, out jt808Outgoing) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.outstanding == nil {
+		j.outstanding = make(map[uint16]jt808Outgoing)
+	}
+	if len(j.outstanding) >= jt808MaxOutstanding {
+		for s, o := range j.outstanding {
+			if time.Since(o.SentAt) > jt808OutstandingTTL {
+				delete(j.outstanding, s)
+			}
+		}
+	}
+	if len(j.outstanding) < jt808MaxOutstanding {
+		j.outstanding[serial] = out
+	}
+}
+
+// resolve looks up and forgets the outgoing message a reply refers to
+func (j *JT808Adapter) resolve(serial uint16) (jt808Outgoing, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	out, ok := j.outstanding[serial]
+	if ok {
+		delete(j.outstanding, serial)
+	}
+	return out, ok
+}
+
+// parseTerminalAck decodes 0x0001: Reply Serial(2) + Reply MsgID(2) + Result(1)
+func (j *JT808Adapter) parseTerminalAck(body []byte, msg *protocol.StandardMessage) {
+	if len(body) < 5 {
+		return
+	}
+	serial := binary.BigEndian.Uint16(body[0:2])
+	result := body[4]
+	msg.Extras["ack_serial"] = serial
+	msg.Extras["ack_msg_id"] = binary.BigEndian.Uint16(body[2:4])
+	// Result: 0 = success, 1 = fail, 2 = msg error, 3 = not supported
+	msg.Extras["result"] = result
+
+	out, ok := j.resolve(serial)
+	if !ok {
+		return
+	}
+	msg.Extras["command"] = out.Command
+	if out.CommandID == "" {
+		return
+	}
+
+	msg.Type = protocol.MsgTypeCommandResponse
+	msg.Response = &protocol.CommandResponse{
+		CommandID: out.CommandID,
+		DeviceID:  msg.DeviceID,
+		Success:   result == 0,
+		Data: map[string]interface{}{
+			"result": result,
+		},
+	}
+	if result != 0 {
+		msg.Response.Error = jt808AckResults[result]
+		if msg.Response.Error == "" {
+			msg.Response.Error = fmt.Sprintf("result %d", result)
+		}
+	}
+}
+
+// parseQueryParamsReply decodes 0x0104: Reply Serial(2) + Count(1) + Items
+func (j *JT808Adapter) parseQueryParamsReply(body []byte, msg *protocol.StandardMessage) error {
+	if len(body) < 3 {
+		return errors.New("param reply body too short")
+	}
+	serial := binary.BigEndian.Uint16(body[0:2])
+	params := decodeJT808ParamItems(body[3:], int(body[2]))
+	msg.Extras["reply_serial"] = serial
+	msg.Extras["params"] = params
+
+	j.respond(serial, msg, map[string]interface{}{
+		"params": params,
+	})
+	return nil
+}
+
+// parseLocationReply decodes 0x0201 / 0x0500: Reply Serial(2) + Location
+func (j *JT808Adapter) parseLocationReply(body []byte, msg *protocol.StandardMessage) error {
+	if len(body) < 2 {
+		return errors.New("location reply body too short")
+	}
+	serial := binary.BigEndian.Uint16(body[0:2])
+	if err := j.parseLocation(body[2:], msg); err != nil {
+		return err
+	}
+	msg.Extras["reply_serial"] = serial
+
+	j.respond(serial, msg, map[string]interface{}{
+		"lat":       msg.Lat,
+		"lon":       msg.Lon,
+		"speed":     msg.Speed,
+		"direction": msg.Direction,
+		"timestamp": msg.Timestamp,
+		"status":    msg.Extras["status"],
+	})
+	return nil
+}
+
+// respond attaches a successful CommandResponse for the command sent with serial
+func (j *JT808Adapter) respond(serial uint16, msg *protocol.StandardMessage, data map[string]interface{}) {
+	out, ok := j.resolve(serial)
+	if !ok {
+		return
+	}
+	msg.Extras["command"] = out.Command
+	if out.CommandID == "" {
+		return
+	}
+	msg.Response = &protocol.CommandResponse{
+		CommandID: out.CommandID,
+		DeviceID:  msg.DeviceID,
+		Success:   true,
+		Data:      data,
+	}
+}
+
+// encodeSetParams builds 0x8103: Count(1) + Items
+func (j *JT808Adapter) encodeSetParams(cmd protocol.StandardCommand) ([]byte, error) {
+	values := commandValues(cmd.Params)
+	if len(values) == 0 {
+		return nil, errors.New("no parameters to set")
+	}
+	items, count, err := encodeJT808ParamItems(values)
+	if err != nil {
+		return nil, err
+	}
+	if count > 255 {
+		return nil, errors.New("too many parameters")
+	}
+	body := append([]byte{byte(count)}, items...)
+	return j.encodeCommand(cmd, MsgIDSetParams, body)
+}
+
+// encodeQueryParams builds 0x8104 (all parameters) or, when "ids" are given,
+// 0x8106: Count(1) + IDs(4 each)
+func (j *JT808Adapter) encodeQueryParams(cmd protocol.StandardCommand) ([]byte, error) {
+	ids, err := commandParamIDs(cmd.Params["ids"])
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		if cmd.Type == protocol.CmdQueryParams {
+			return nil, errors.New("no parameter ids to query")
+		}
+		return j.encodeCommand(cmd, MsgIDQueryParams, nil)
+	}
+	if len(ids) > 255 {
+		return nil, errors.New("too many parameter ids")
+	}
+
+	body := []byte{byte(len(ids))}
+	for _, id := range ids {
+		body = binary.BigEndian.AppendUint32(body, id)
+	}
+	return j.encodeCommand(cmd, MsgIDQuerySpecifiedParams, body)
+}
+
+// terminalControlCommand returns the "command" param of a TERMINAL_CONTROL
+// command, given as a number or a numeric string. The codes are JT808's and
+// are shared by the other protocols.
+func terminalControlCommand(params map[string]interface{}) (uint64, error) {
+	command, ok := toUint(params["command"])
+	if !ok {
+		if s, isStr := params["command"].(string); isStr {
+			_, err := fmt.Sscanf(s, "%d", &command)
+			ok = err == nil
+		}
+	}
+	if !ok || command == 0 || command > 0xFF {
+		return 0, fmt.Errorf("invalid terminal control command: %v", params["command"])
+	}
+	return command, nil
+}
+
+// encodeTerminalControl builds 0x8105: Command(1) + Params(STRING, ';' separated)
+// Command: 1 = upgrade, 2 = connect server, 3 = power off, 4 = reset,
+// 5 = factory reset, 6 = close data link, 7 = close all wireless links
+func (j *JT808Adapter) encodeTerminalControl(cmd protocol.StandardCommand) ([]byte, error) {
+	command, err := terminalControlCommand(cmd.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	body := []byte{byte(command)}
+	switch args := cmd.Params["args"].(type) {
+	case string:
+		body = append(body, encodeGBK(args)...)
+	case []interface{}:
+		parts := make([]string, len(args))
+		for i, a := range args {
+			parts[i] = fmt.Sprint(a)
+		}
+		body = append(body, encodeGBK(strings.Join(parts, ";"))...)
+	}
+	return j.encodeCommand(cmd, MsgIDTerminalControl, body)
+}
+
+// encodeLocationQuery builds 0x8201 with an empty body
+func (j *JT808Adapter) encodeLocationQuery(cmd protocol.StandardCommand) ([]byte, error) {
+	return j.encodeCommand(cmd, MsgIDLocationQuery, nil)
+}
+
+// encodeTempTracking builds 0x8202: Interval(2, seconds) + Validity(4, seconds).
+// An interval of 0 stops tracking.
+func (j *JT808Adapter) encodeTempTracking(cmd protocol.StandardCommand) ([]byte, error) {
+	interval, _ := paramUint(cmd.Params, "interval")
+	validity, _ := paramUint(cmd.Params, "duration")
+	if interval > 0xFFFF {
+		return nil, fmt.Errorf("tracking interval too large: %d", interval)
+	}
+
+	body := binary.BigEndian.AppendUint16(nil, uint16(interval))
+	if interval > 0 {
+		body = binary.BigEndian.AppendUint32(body, uint32(validity))
+	}
+	return j.encodeCommand(cmd, MsgIDTempTracking, body)
+}
+
+// encodeTextMessage builds 0x8300.
+// 2013: Flag(1) + Text; 2019: Flag(1) + TextType(1) + Text.
+// Flag bits: 0 emergency, 2 terminal display, 3 TTS, 5 (2013: ad screen / 2019: CAN fault code)
+func (j *JT808Adapter) encodeTextMessage(cmd protocol.StandardCommand) ([]byte, error) {
+	text, _ := cmd.Params["text"].(string)
+	if text == "" {
+		return nil, errors.New("empty text message")
+	}
+
+	flag := uint64(0x0C) // display + TTS
+	if f, ok := paramUint(cmd.Params, "flag"); ok {
+		flag = f
+	}
+	body := []byte{byte(flag)}
+
+	version, _ := j.replyTarget(cmd.Params)
+	if version == JT808Version2019 {
+		// Text type: 1 = notice, 2 = service
+		textType := uint64(1)
+		if t, ok := paramUint(cmd.Params, "text_type"); ok {
+			textType = t
+		}
+		body = append(body, byte(textType))
+	}
+	body = append(body, encodeGBK(text)...)
+	return j.encodeCommand(cmd, MsgIDTextMessage, body)
+}
+
+// encodeVehicleControl builds 0x8500.
+// 2013: ControlFlag(1), bit 0 = lock/cut. Terminals wire bit 0 to either the
+// door lock or the oil/circuit relay.
+// 2019: Count(2) + [ControlID(2) + Param] items, 0x0001 = door (1 lock, 0 unlock);
+// oil/circuit control is vendor-specific and defaults to ID 0xF001.
+func (j *JT808Adapter) encodeVehicleControl(cmd protocol.StandardCommand) ([]byte, error) {
+	target, _ := cmd.Params["type"].(string)
+	action, _ := cmd.Params["action"].(string)
+
+	var on bool
+	switch action {
+	case "lock", "cut":
+		on = true
+	case "unlock", "restore":
+		on = false
+	default:
+		return nil, fmt.Errorf("invalid vehicle control action: %q", action)
+	}
+
+	var controlID uint64
+	switch target {
+	case "door":
+		controlID = 0x0001
+	case "oil":
+		controlID = 0xF001
+	default:
+		return nil, fmt.Errorf("invalid vehicle control type: %q", target)
+	}
+	if id, ok := paramUint(cmd.Params, "control_id"); ok {
+		controlID = id
+	}
+
+	var flag byte
+	if on {
+		flag = 1
+	}
+
+	version, _ := j.replyTarget(cmd.Params)
+	if version != JT808Version2019 {
+		return j.encodeCommand(cmd, MsgIDVehicleControl, []byte{flag})
+	}
+
+	body := binary.BigEndian.AppendUint16(nil, 1)
+	body = binary.BigEndian.AppendUint16(body, uint16(controlID))
+	body = append(body, flag)
+	return j.encodeCommand(cmd, MsgIDVehicleControl, body)
+}
+
+// commandValues returns the parameter values of a SET_PARAMS command, which
+// may be nested under "params" or given directly
+func commandValues(params map[string]interface{}) map[string]interface{} {
+	if nested, ok := params["params"].(map[string]interface{}); ok {
+		return nested
+	}
+	values := make(map[string]interface{}, len(params))
+	for k, v := range params {
+		switch k {
+		case "version", "phone", "command_id":
+			continue
+		}
+		values[k] = v
+	}
+	return values
+}
+
+// commandParamIDs converts a list of parameter names or IDs to sorted IDs
+func commandParamIDs(raw interface{}) ([]uint32, error) {
+	var keys []string
+	switch v := raw.(type) {
+	case nil:
+		return nil, nil
+	case []string:
+		keys = v
+	case []interface{}:
+		for _, item := range v {
+			if n, ok := toUint(item); ok {
+				keys = append(keys, fmt.Sprint(n))
+			} else {
+				keys = append(keys, fmt.Sprint(item))
+			}
+		}
+	default:
+		return nil, fmt.Errorf("invalid parameter id list: %v", raw)
+	}
+
+	ids := make([]uint32, 0, len(keys))
+	for _, key := range keys {
+		p, err := lookupJT808Param(key)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, p.ID)
+	}
+	sort.Slice(ids, func(a, b int) bool { return ids[a] < ids[b] })
+	return ids, nil
+}
+
+// isZeroBCD reports whether a BCD phone is all zero (terminal not seen yet)
+func isZeroBCD(bcd []byte) bool {
+	for _, b := range bcd {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,170 @@
+package adapter
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"openfms/gateway/internal/protocol"
+)
+
+// JT808ExtraDecoder decodes the value of a location additional information
+// item into the extras of the location message
+type JT808ExtraDecoder func(value []byte, extras map[string]interface{})
+
+var (
+	jt808ExtraMu       sync.RWMutex
+	jt808ExtraDecoders = map[byte]JT808ExtraDecoder{}
+)
+
+// RegisterJT808Extra registers the decoder of a location additional
+// information item, typically a vendor custom block (0xE0-0xFF). Registered
+// decoders take precedence over the built-in ones.
+func RegisterJT808Extra(id byte, decode JT808ExtraDecoder) {
+	jt808ExtraMu.Lock()
+	defer jt808ExtraMu.Unlock()
+	jt808ExtraDecoders[id] = decode
+}
+
+func lookupJT808Extra(id byte) (JT808ExtraDecoder, bool) {
+	jt808ExtraMu.RLock()
+	defer jt808ExtraMu.RUnlock()
+	decode, ok := jt808ExtraDecoders[id]
+	return decode, ok
+}
+
+// jt808VehicleSignals names the bits of the extended vehicle signal status (0x25)
+var jt808VehicleSignals = []string{
+	"low_beam", "high_beam", "right_turn", "left_turn", "brake", "reverse",
+	"fog_lamp", "position_lamp", "horn", "air_conditioner", "neutral",
+	"retarder", "abs", "heater", "clutch",
+}
+
+// jt808AreaTypes names the location types of 0x11/0x12
+var jt808AreaTypes = []string{"none", "circle", "rectangle", "polygon", "route"}
+
+func jt808AreaType(t byte) string {
+	if int(t) < len(jt808AreaTypes) {
+		return jt808AreaTypes[t]
+	}
+	return fmt.Sprintf("unknown_%d", t)
+}
+
+// parseLocationExtras decodes the additional information items following the
+// 28 byte location body: ID(1) + Length(1) + Value(n)...
+func (j *JT808Adapter) parseLocationExtras(data []byte, msg *protocol.StandardMessage) {
+	for len(data) >= 2 {
+		id := data[0]
+		length := int(data[1])
+		if len(data) < 2+length {
+			break
+		}
+		value := data[2 : 2+length]
+		data = data[2+length:]
+
+		if decode, ok := lookupJT808Extra(id); ok {
+			decode(value, msg.Extras)
+			continue
+		}
+
+		switch id {
+		case 0x01: // Mileage (4 bytes, 0.1 km)
+			if length >= 4 {
+				mileage := binary.BigEndian.Uint32(value[0:4])
+				msg.Extras["mileage"] = float64(mileage) / 10.0
+			}
+		case 0x02: // Fuel (2 bytes, 0.1 L)
+			if length >= 2 {
+				fuel := binary.BigEndian.Uint16(value[0:2])
+				msg.Extras["fuel"] = float64(fuel) / 10.0
+			}
+		case 0x03: // Speed from sensor (2 bytes, 0.1 km/h)
+			if length >= 2 {
+				sensorSpeed := binary.BigEndian.Uint16(value[0:2])
+				msg.Extras["sensor_speed"] = float64(sensorSpeed) / 10.0
+			}
+		case 0x04: // Manual alarm event ID (2 bytes), confirmed by 0x8203
+			if length >= 2 {
+				msg.Extras["manual_alarm_event_id"] = binary.BigEndian.Uint16(value[0:2])
+			}
+		case 0x05: // Tire pressure (2019, 30 bytes, kPa, 0xFF invalid)
+			pressures := make([]int, 0, length)
+			for _, p := range value {
+				if p == 0xFF {
+					pressures = append(pressures, -1)
+				} else {
+					pressures = append(pressures, int(p))
+				}
+			}
+			msg.Extras["tire_pressure"] = pressures
+		case 0x06: // Carriage temperature (2019, 2 bytes signed, °C)
+			if length >= 2 {
+				msg.Extras["carriage_temperature"] = int16(binary.BigEndian.Uint16(value[0:2]))
+			}
+		case 0x11: // Overspeed alarm: LocationType(1) + AreaID(4, absent for type 0)
+			if length >= 1 {
+				msg.Extras["overspeed_location_type"] = jt808AreaType(value[0])
+				if value[0] != 0 && length >= 5 {
+					msg.Extras["overspeed_area_id"] = binary.BigEndian.Uint32(value[1:5])
+				}
+			}
+		case 0x12: // Area/route in-out alarm: LocationType(1) + AreaID(4) + Direction(1)
+			if length >= 6 {
+				msg.Extras["area_alarm_type"] = jt808AreaType(value[0])
+				msg.Extras["area_alarm_id"] = binary.BigEndian.Uint32(value[1:5])
+				if value[5] == 0 {
+					msg.Extras["area_alarm_direction"] = "in"
+				} else {
+					msg.Extras["area_alarm_direction"] = "out"
+				}
+			}
+		case 0x13: // Route driving time alarm: SectionID(4) + Time(2, s) + Result(1)
+			if length >= 7 {
+				msg.Extras["route_section_id"] = binary.BigEndian.Uint32(value[0:4])
+				msg.Extras["route_driving_time"] = binary.BigEndian.Uint16(value[4:6])
+				if value[6] == 0 {
+					msg.Extras["route_driving_result"] = "insufficient"
+				} else {
+					msg.Extras["route_driving_result"] = "too_long"
+				}
+			}
+		case 0x25: // Extended vehicle signal status (4 bytes)
+			if length >= 4 {
+				status := binary.BigEndian.Uint32(value[0:4])
+				msg.Extras["vehicle_signal"] = status
+				signals := []string{}
+				for bit, name := range jt808VehicleSignals {
+					if status&(1<<uint(bit)) != 0 {
+						signals = append(signals, name)
+					}
+				}
+				msg.Extras["vehicle_signals"] = signals
+			}
+		case 0x2A: // IO status (2 bytes): bit0 deep sleep, bit1 sleep
+			if length >= 2 {
+				io := binary.BigEndian.Uint16(value[0:2])
+				msg.Extras["io_status"] = io
+				msg.Extras["io_deep_sleep"] = io&0x0001 != 0
+				msg.Extras["io_sleep"] = io&0x0002 != 0
+			}
+		case 0x2B: // Analog (4 bytes): bit0-15 AD0, bit16-31 AD1
+			if length >= 4 {
+				analog := binary.BigEndian.Uint32(value[0:4])
+				msg.Extras["analog_ad0"] = uint16(analog)
+				msg.Extras["analog_ad1"] = uint16(analog >> 16)
+			}
+		case 0x30: // Wireless network signal strength (1 byte)
+			if length >= 1 {
+				msg.Extras["signal_strength"] = value[0]
+			}
+		case 0x31: // GNSS satellite count (1 byte)
+			if length >= 1 {
+				msg.Extras["satellites"] = value[0]
+			}
+		default:
+			// Keep unknown and vendor custom items for later analysis
+			msg.Extras[fmt.Sprintf("extra_0x%02X", id)] = hex.EncodeToString(value)
+		}
+	}
+}
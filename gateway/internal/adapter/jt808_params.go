@@ -0,0 +1,263 @@
+package adapter
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// JT808 terminal parameter value types
+const (
+	jt808ParamByte   = "BYTE"
+	jt808ParamWord   = "WORD"
+	jt808ParamDword  = "DWORD"
+	jt808ParamString = "STRING"
+)
+
+// jt808Param describes a terminal parameter used by 0x8103/0x8104/0x8106/0x0104
+type jt808Param struct {
+	ID   uint32
+	Name string
+	Type string
+}
+
+// jt808Params lists the commonly used terminal parameters of JT/T 808
+var jt808Params = []jt808Param{
+	{0x0001, "heartbeat_interval", jt808ParamDword},
+	{0x0002, "tcp_timeout", jt808ParamDword},
+	{0x0003, "tcp_retries", jt808ParamDword},
+	{0x0004, "udp_timeout", jt808ParamDword},
+	{0x0005, "udp_retries", jt808ParamDword},
+	{0x0006, "sms_timeout", jt808ParamDword},
+	{0x0007, "sms_retries", jt808ParamDword},
+	{0x0010, "apn", jt808ParamString},
+	{0x0011, "apn_user", jt808ParamString},
+	{0x0012, "apn_password", jt808ParamString},
+	{0x0013, "server_address", jt808ParamString},
+	{0x0014, "backup_apn", jt808ParamString},
+	{0x0015, "backup_apn_user", jt808ParamString},
+	{0x0016, "backup_apn_password", jt808ParamString},
+	{0x0017, "backup_server_address", jt808ParamString},
+	{0x0018, "server_tcp_port", jt808ParamDword},
+	{0x0019, "server_udp_port", jt808ParamDword},
+	{0x0020, "report_strategy", jt808ParamDword},
+	{0x0021, "report_scheme", jt808ParamDword},
+	{0x0022, "report_interval_driver_absent", jt808ParamDword},
+	{0x0027, "report_interval_sleep", jt808ParamDword},
+	{0x0028, "report_interval_alarm", jt808ParamDword},
+	{0x0029, "report_interval_default", jt808ParamDword},
+	{0x002C, "report_distance_default", jt808ParamDword},
+	{0x002D, "report_distance_driver_absent", jt808ParamDword},
+	{0x002E, "report_distance_sleep", jt808ParamDword},
+	{0x002F, "report_distance_alarm", jt808ParamDword},
+	{0x0030, "corner_angle", jt808ParamDword},
+	{0x0031, "geofence_radius", jt808ParamWord},
+	{0x0040, "platform_phone", jt808ParamString},
+	{0x0041, "reset_phone", jt808ParamString},
+	{0x0042, "factory_reset_phone", jt808ParamString},
+	{0x0043, "platform_sms_phone", jt808ParamString},
+	{0x0044, "alarm_sms_phone", jt808ParamString},
+	{0x0045, "answer_strategy", jt808ParamDword},
+	{0x0048, "monitor_phone", jt808ParamString},
+	{0x0050, "alarm_mask", jt808ParamDword},
+	{0x0051, "alarm_sms_switch", jt808ParamDword},
+	{0x0052, "alarm_photo_switch", jt808ParamDword},
+	{0x0053, "alarm_photo_save", jt808ParamDword},
+	{0x0054, "key_alarm_flag", jt808ParamDword},
+	{0x0055, "max_speed", jt808ParamDword},
+	{0x0056, "overspeed_duration", jt808ParamDword},
+	{0x0057, "continuous_driving_limit", jt808ParamDword},
+	{0x0058, "daily_driving_limit", jt808ParamDword},
+	{0x0059, "min_rest_time", jt808ParamDword},
+	{0x005A, "max_parking_time", jt808ParamDword},
+	{0x005B, "overspeed_warning_diff", jt808ParamWord},
+	{0x005C, "fatigue_warning_diff", jt808ParamWord},
+	{0x005D, "collision_alarm_params", jt808ParamWord},
+	{0x005E, "rollover_alarm_angle", jt808ParamWord},
+	{0x0070, "image_quality", jt808ParamDword},
+	{0x0071, "brightness", jt808ParamDword},
+	{0x0072, "contrast", jt808ParamDword},
+	{0x0073, "saturation", jt808ParamDword},
+	{0x0074, "chroma", jt808ParamDword},
+	{0x0080, "odometer", jt808ParamDword},
+	{0x0081, "province_id", jt808ParamWord},
+	{0x0082, "city_id", jt808ParamWord},
+	{0x0083, "plate_number", jt808ParamString},
+	{0x0084, "plate_color", jt808ParamByte},
+	{0x0090, "gnss_mode", jt808ParamByte},
+	{0x0091, "gnss_baudrate", jt808ParamByte},
+	{0x0092, "gnss_output_rate", jt808ParamByte},
+	{0x0093, "gnss_sample_rate", jt808ParamDword},
+	{0x0094, "gnss_upload_mode", jt808ParamByte},
+	{0x0095, "gnss_upload_setting", jt808ParamDword},
+	{0x0100, "can1_sample_interval", jt808ParamDword},
+	{0x0101, "can1_upload_interval", jt808ParamWord},
+	{0x0102, "can2_sample_interval", jt808ParamDword},
+	{0x0103, "can2_upload_interval", jt808ParamWord},
+}
+
+var (
+	jt808ParamsByID   = make(map[uint32]jt808Param)
+	jt808ParamsByName = make(map[string]jt808Param)
+)
+
+func init() {
+	for _, p := range jt808Params {
+		jt808ParamsByID[p.ID] = p
+		jt808ParamsByName[p.Name] = p
+	}
+}
+
+// lookupJT808Param resolves a parameter key given as a name ("heartbeat_interval"),
+// hex ID ("0x0001") or decimal ID ("1"). Unknown IDs get a zero Type.
+func lookupJT808Param(key string) (jt808Param, error) {
+	if p, ok := jt808ParamsByName[key]; ok {
+		return p, nil
+	}
+
+	var id uint64
+	var err error
+	if strings.HasPrefix(key, "0x") || strings.HasPrefix(key, "0X") {
+		id, err = strconv.ParseUint(key[2:], 16, 32)
+	} else {
+		id, err = strconv.ParseUint(key, 10, 32)
+	}
+	if err != nil {
+		return jt808Param{}, fmt.Errorf("unknown parameter: %s", key)
+	}
+	if p, ok := jt808ParamsByID[uint32(id)]; ok {
+		return p, nil
+	}
+	return jt808Param{ID: uint32(id), Name: fmt.Sprintf("0x%04X", id)}, nil
+}
+
+// encodeJT808ParamItems encodes parameter items for 0x8103:
+// ID(4) + Len(1) + Value, sorted by ID for a stable wire format
+func encodeJT808ParamItems(values map[string]interface{}) ([]byte, int, error) {
+	type item struct {
+		param jt808Param
+		value interface{}
+	}
+	items := make([]item, 0, len(values))
+	for key, value := range values {
+		p, err := lookupJT808Param(key)
+		if err != nil {
+			return nil, 0, err
+		}
+		items = append(items, item{p, value})
+	}
+	sort.Slice(items, func(a, b int) bool { return items[a].param.ID < items[b].param.ID })
+
+	var data []byte
+	for _, it := range items {
+		value, err := encodeJT808ParamValue(it.param, it.value)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(value) > 255 {
+			return nil, 0, fmt.Errorf("parameter %s too long", it.param.Name)
+		}
+		data = binary.BigEndian.AppendUint32(data, it.param.ID)
+		data = append(data, byte(len(value)))
+		data = append(data, value...)
+	}
+	return data, len(items), nil
+}
+
+func encodeJT808ParamValue(p jt808Param, value interface{}) ([]byte, error) {
+	typ := p.Type
+	if typ == "" {
+		// Unknown parameter: strings as STRING, numbers as DWORD
+		if _, ok := value.(string); ok {
+			typ = jt808ParamString
+		} else {
+			typ = jt808ParamDword
+		}
+	}
+
+	if typ == jt808ParamString {
+		s, ok := value.(string)
+		if !ok {
+			s = fmt.Sprint(value)
+		}
+		return encodeGBK(s), nil
+	}
+
+	n, ok := toUint(value)
+	if !ok {
+		if s, isStr := value.(string); isStr {
+			parsed, err := strconv.ParseUint(s, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("parameter %s: invalid number %q", p.Name, s)
+			}
+			n, ok = parsed, true
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("parameter %s: invalid value %v", p.Name, value)
+	}
+
+	switch typ {
+	case jt808ParamByte:
+		return []byte{byte(n)}, nil
+	case jt808ParamWord:
+		return binary.BigEndian.AppendUint16(nil, uint16(n)), nil
+	default:
+		return binary.BigEndian.AppendUint32(nil, uint32(n)), nil
+	}
+}
+
+// decodeJT808ParamItems decodes parameter items of 0x0104 into name -> value
+func decodeJT808ParamItems(data []byte, count int) map[string]interface{} {
+	result := make(map[string]interface{})
+	for i := 0; i < count && len(data) >= 5; i++ {
+		id := binary.BigEndian.Uint32(data[0:4])
+		length := int(data[4])
+		if len(data) < 5+length {
+			break
+		}
+		value := data[5 : 5+length]
+		data = data[5+length:]
+
+		p, known := jt808ParamsByID[id]
+		if !known {
+			p = jt808Param{ID: id, Name: fmt.Sprintf("0x%04X", id)}
+		}
+
+		switch {
+		case p.Type == jt808ParamString:
+			result[p.Name] = decodeGBK(value)
+		case length == 1:
+			result[p.Name] = value[0]
+		case length == 2:
+			result[p.Name] = binary.BigEndian.Uint16(value)
+		case length == 4:
+			result[p.Name] = binary.BigEndian.Uint32(value)
+		default:
+			result[p.Name] = fmt.Sprintf("%X", value)
+		}
+	}
+	return result
+}
+
+// encodeGBK converts UTF-8 text to GBK as used by JT808 STRING fields
+func encodeGBK(s string) []byte {
+	data, err := simplifiedchinese.GBK.NewEncoder().Bytes([]byte(s))
+	if err != nil {
+		return []byte(s)
+	}
+	return data
+}
+
+// decodeGBK converts a GBK STRING field to UTF-8
+func decodeGBK(data []byte) string {
+	text, err := simplifiedchinese.GBK.NewDecoder().Bytes(data)
+	if err != nil {
+		return string(data)
+	}
+	return string(text)
+}
@@ -0,0 +1,176 @@
+package adapter
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"openfms/gateway/internal/protocol"
+)
+
+const (
+	// RSA key exchange message IDs
+	MsgIDTerminalRSAKey uint16 = 0x0A00
+	MsgIDPlatformRSAKey uint16 = 0x8A00
+
+	// Encryption field value for RSA in body properties
+	jt808EncryptRSA uint16 = 0x01
+
+	// JT808 carries the RSA modulus as BYTE[128]
+	jt808RSAKeyBits = 1024
+	jt808RSAKeyLen  = jt808RSAKeyBits / 8
+)
+
+// JT808Options configures the adapters created by JT808Detector
+type JT808Options struct {
+	// PlatformKey decrypts bodies from terminals using RSA encryption and is
+	// sent to them in 0x8A00. Nil disables RSA support.
+	PlatformKey *rsa.PrivateKey
+
+	// Clock interprets the BCD location time; a nil Location means GMT+8,
+	// the timezone JT808 terminals report in
+	Clock DeviceClock
+}
+
+// LoadJT808PlatformKey loads the platform RSA private key from a PEM file
+// (PKCS#1 or PKCS#8). An empty path generates an ephemeral key.
+func LoadJT808PlatformKey(path string) (*rsa.PrivateKey, error) {
+	if path == "" {
+		return rsa.GenerateKey(rand.Reader, jt808RSAKeyBits)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var key *rsa.PrivateKey
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		var parsed interface{}
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err == nil {
+			var ok bool
+			if key, ok = parsed.(*rsa.PrivateKey); !ok {
+				err = errors.New("not an RSA private key")
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if key.N.BitLen() != jt808RSAKeyBits {
+		return nil, fmt.Errorf("JT808 requires a %d-bit RSA key, got %d", jt808RSAKeyBits, key.N.BitLen())
+	}
+	return key, nil
+}
+
+// parseRSAKey decodes 0x0A00 / 0x8A00 body: e(4) + n(128)
+func parseRSAKey(body []byte) (*rsa.PublicKey, error) {
+	if len(body) < 4+jt808RSAKeyLen {
+		return nil, errors.New("RSA key body too short")
+	}
+	e := binary.BigEndian.Uint32(body[0:4])
+	if e < 3 || e&1 == 0 {
+		return nil, fmt.Errorf("invalid RSA exponent %d", e)
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(body[4 : 4+jt808RSAKeyLen]),
+		E: int(e),
+	}, nil
+}
+
+// encodeRSAKey encodes a public key as e(4) + n(128)
+func encodeRSAKey(key *rsa.PublicKey) []byte {
+	body := binary.BigEndian.AppendUint32(nil, uint32(key.E))
+	return append(body, key.N.FillBytes(make([]byte, jt808RSAKeyLen))...)
+}
+
+// handleTerminalRSAKey stores the key uploaded in 0x0A00 and answers with
+// the platform key in 0x8A00 so that both directions can be encrypted
+func (j *JT808Adapter) handleTerminalRSAKey(header *jt808Header, body []byte, msg *protocol.StandardMessage) error {
+	key, err := parseRSAKey(body)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	j.terminalKey = key
+	platformKey := j.options.PlatformKey
+	j.mu.Unlock()
+
+	msg.Extras["rsa_e"] = key.E
+	msg.Extras["rsa_bits"] = key.N.BitLen()
+
+	if platformKey == nil {
+		return nil
+	}
+	packet, err := j.buildPacket(header.Version, MsgIDPlatformRSAKey, header.Phone, encodeRSAKey(&platformKey.PublicKey))
+	if err != nil {
+		return err
+	}
+	return j.send(packet)
+}
+
+// decryptBody decrypts an RSA encrypted body with the platform key.
+// The body is a sequence of 128-byte PKCS#1 v1.5 blocks.
+func (j *JT808Adapter) decryptBody(body []byte) ([]byte, error) {
+	j.mu.Lock()
+	key := j.options.PlatformKey
+	j.mu.Unlock()
+	if key == nil {
+		return nil, errors.New("RSA encrypted body but no platform key configured")
+	}
+
+	size := key.Size()
+	if len(body)%size != 0 {
+		return nil, fmt.Errorf("encrypted body length %d is not a multiple of %d", len(body), size)
+	}
+	var plain []byte
+	for i := 0; i < len(body); i += size {
+		block, err := rsa.DecryptPKCS1v15(rand.Reader, key, body[i:i+size])
+		if err != nil {
+			return nil, err
+		}
+		plain = append(plain, block...)
+	}
+	return plain, nil
+}
+
+// encryptBody encrypts a downlink body with the terminal key once the
+// terminal has uploaded one. The key exchange message itself stays plain.
+func (j *JT808Adapter) encryptBody(msgID uint16, body []byte) ([]byte, bool, error) {
+	j.mu.Lock()
+	key := j.terminalKey
+	j.mu.Unlock()
+	if key == nil || msgID == MsgIDPlatformRSAKey || len(body) == 0 {
+		return body, false, nil
+	}
+
+	chunk := key.Size() - 11 // PKCS#1 v1.5 padding overhead
+	var cipher []byte
+	for i := 0; i < len(body); i += chunk {
+		end := i + chunk
+		if end > len(body) {
+			end = len(body)
+		}
+		block, err := rsa.EncryptPKCS1v15(rand.Reader, key, body[i:end])
+		if err != nil {
+			return nil, false, err
+		}
+		cipher = append(cipher, block...)
+	}
+	return cipher, true, nil
+}
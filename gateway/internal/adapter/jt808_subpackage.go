@@ -0,0 +1,175 @@
+package adapter
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// Time to wait for the missing packages of a message before asking again
+	jt808SubpackageTimeout = 30 * time.Second
+	// Retransmission requests sent before a message is dropped
+	jt808SubpackageRetries = 3
+	// Upper bounds protecting the session from runaway terminals
+	jt808MaxSubpackages     = 4096
+	jt808MaxPendingMessages = 16
+)
+
+// jt808Fragments holds the packages received so far for one message
+type jt808Fragments struct {
+	header      *jt808Header // header of the first package
+	firstSerial uint16       // serial of package 1, referenced by 0x8003
+	parts       map[uint16][]byte
+	retries     int
+	timer       *time.Timer
+}
+
+// jt808Reassembler buffers JT808 subpackages by (msgID, total, index) and
+// requests missing ones with 0x8003 when a message stalls
+type jt808Reassembler struct {
+	adapter *JT808Adapter
+	mu      sync.Mutex
+	pending map[string]*jt808Fragments
+	closed  bool
+}
+
+func newJT808Reassembler(adapter *JT808Adapter) *jt808Reassembler {
+	return &jt808Reassembler{
+		adapter: adapter,
+		pending: make(map[string]*jt808Fragments),
+	}
+}
+
+// Add stores one package. When the message is complete it returns the header
+// of the first package and the concatenated body, otherwise nil.
+func (r *jt808Reassembler) Add(header *jt808Header, body []byte) (*jt808Header, []byte, error) {
+	if header.PkgTotal == 0 || header.PkgTotal > jt808MaxSubpackages {
+		return nil, nil, fmt.Errorf("invalid subpackage total %d", header.PkgTotal)
+	}
+	if header.PkgIndex == 0 || header.PkgIndex > header.PkgTotal {
+		return nil, nil, fmt.Errorf("invalid subpackage index %d/%d", header.PkgIndex, header.PkgTotal)
+	}
+
+	// Packages of one message carry consecutive serials
+	firstSerial := header.Serial - (header.PkgIndex - 1)
+	key := fmt.Sprintf("%04X:%d:%d", header.MsgID, header.PkgTotal, firstSerial)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, nil, errors.New("connection closed")
+	}
+
+	frags, ok := r.pending[key]
+	if !ok {
+		if len(r.pending) >= jt808MaxPendingMessages {
+			return nil, nil, errors.New("too many pending subpackaged messages")
+		}
+		frags = &jt808Fragments{
+			firstSerial: firstSerial,
+			parts:       make(map[uint16][]byte),
+		}
+		frags.timer = time.AfterFunc(jt808SubpackageTimeout, func() { r.expire(key) })
+		r.pending[key] = frags
+	}
+	if header.PkgIndex == 1 || frags.header == nil {
+		frags.header = header
+	}
+	frags.parts[header.PkgIndex] = append([]byte(nil), body...)
+
+	if len(frags.parts) < int(header.PkgTotal) {
+		return nil, nil, nil
+	}
+
+	// All packages present: concatenate in index order
+	frags.timer.Stop()
+	delete(r.pending, key)
+
+	var full []byte
+	for i := uint16(1); i <= header.PkgTotal; i++ {
+		full = append(full, frags.parts[i]...)
+	}
+	return frags.header, full, nil
+}
+
+// Close stops the retransmission timers and drops the buffered packages
+func (r *jt808Reassembler) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, frags := range r.pending {
+		frags.timer.Stop()
+		delete(r.pending, key)
+	}
+	r.closed = true
+}
+
+// expire runs when a message has not completed in time: it asks the terminal
+// for the missing packages, or drops the message after too many attempts
+func (r *jt808Reassembler) expire(key string) {
+	r.mu.Lock()
+	frags, ok := r.pending[key]
+	if !ok {
+		r.mu.Unlock()
+		return
+	}
+	if frags.retries >= jt808SubpackageRetries {
+		delete(r.pending, key)
+		r.mu.Unlock()
+		log.Printf("[JT808] Dropped incomplete message 0x%04X after %d retransmission requests",
+			frags.header.MsgID, frags.retries)
+		return
+	}
+	frags.retries++
+	missing := frags.missing()
+	header := frags.header
+	firstSerial := frags.firstSerial
+	frags.timer.Reset(jt808SubpackageTimeout)
+	r.mu.Unlock()
+
+	packet, err := r.adapter.encodeRetransmitRequest(header, firstSerial, missing)
+	if err == nil {
+		err = r.adapter.send(packet)
+	}
+	if err != nil {
+		log.Printf("[JT808] Failed to request retransmission of 0x%04X: %v", header.MsgID, err)
+	}
+}
+
+// missing lists the package indexes not received yet, in ascending order
+func (f *jt808Fragments) missing() []uint16 {
+	var ids []uint16
+	for i := uint16(1); i <= f.header.PkgTotal; i++ {
+		if _, ok := f.parts[i]; !ok {
+			ids = append(ids, i)
+		}
+	}
+	return ids
+}
+
+// encodeRetransmitRequest builds 0x8003:
+// Original Serial(2) + Count(1 in 2013, 2 in 2019) + Package IDs(2 each)
+func (j *JT808Adapter) encodeRetransmitRequest(header *jt808Header, firstSerial uint16, ids []uint16) ([]byte, error) {
+	// Keep the request within one packet; later ones are asked again on the next timeout
+	limit := 255
+	if header.Version == JT808Version2019 {
+		limit = 500
+	}
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	body := binary.BigEndian.AppendUint16(nil, firstSerial)
+	if header.Version == JT808Version2019 {
+		body = binary.BigEndian.AppendUint16(body, uint16(len(ids)))
+	} else {
+		body = append(body, byte(len(ids)))
+	}
+	for _, id := range ids {
+		body = binary.BigEndian.AppendUint16(body, id)
+	}
+	return j.buildPacket(header.Version, MsgIDRetransmitRequest, header.Phone, body)
+}
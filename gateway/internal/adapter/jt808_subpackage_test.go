@@ -0,0 +1,235 @@
+package adapter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"openfms/gateway/internal/protocol"
+)
+
+// jt808SubpackageFrames splits a message body into total 2013 packets with
+// consecutive serials starting at first
+func jt808SubpackageFrames(t *testing.T, msgID uint16, body []byte, total int, first uint16) [][]byte {
+	t.Helper()
+	size := (len(body) + total - 1) / total
+	var frames [][]byte
+	for i := 0; i < total; i++ {
+		part := body[i*size : min(len(body), (i+1)*size)]
+		content := binary.BigEndian.AppendUint16(nil, msgID)
+		content = binary.BigEndian.AppendUint16(content, uint16(len(part))|jt808PropsSubpackage)
+		content = append(content, jt808Hex(t, "013912345678")...)
+		content = binary.BigEndian.AppendUint16(content, first+uint16(i))
+		content = binary.BigEndian.AppendUint16(content, uint16(total))
+		content = binary.BigEndian.AppendUint16(content, uint16(i+1))
+		content = append(content, part...)
+		frames = append(frames, jt808TestFrame(content))
+	}
+	return frames
+}
+
+// jt808LocationBody is the 0x0200 body of jt808Location2013
+func jt808LocationBody(t *testing.T) []byte {
+	t.Helper()
+	frame := jt808Hex(t, jt808Location2013)
+	return frame[13 : len(frame)-2]
+}
+
+func TestJT808SubpackageReassembly(t *testing.T) {
+	tests := []struct {
+		name  string
+		total int
+		order []int
+	}{
+		{"in order", 3, []int{0, 1, 2}},
+		{"out of order", 3, []int{2, 0, 1}},
+		{"duplicate package", 2, []int{1, 1, 0}},
+		{"single package", 1, []int{0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := NewJT808Adapter()
+			defer j.Close()
+			frames := jt808SubpackageFrames(t, MsgIDLocationReport, jt808LocationBody(t), tt.total, 10)
+
+			for i, index := range tt.order {
+				msg, err := j.Decode(frames[index])
+				if err != nil {
+					t.Fatalf("package %d: %v", index+1, err)
+				}
+				if i < len(tt.order)-1 {
+					if msg != nil {
+						t.Fatalf("package %d: message before the last package: %+v", index+1, msg)
+					}
+					continue
+				}
+				if msg == nil || msg.Type != protocol.MsgTypeLocation {
+					t.Fatalf("reassembled message = %+v", msg)
+				}
+				if math.Abs(msg.Lat-22.5431) > 1e-9 || math.Abs(msg.Lon-113.9486) > 1e-9 {
+					t.Errorf("position = %f,%f", msg.Lat, msg.Lon)
+				}
+				// The message keeps the serial of its first package
+				if msg.Extras["msg_serial"] != uint16(10) {
+					t.Errorf("serial = %v", msg.Extras["msg_serial"])
+				}
+			}
+		})
+	}
+}
+
+func TestJT808SubpackageInterleaved(t *testing.T) {
+	j := NewJT808Adapter()
+	defer j.Close()
+	body := jt808LocationBody(t)
+	a := jt808SubpackageFrames(t, MsgIDLocationReport, body, 2, 10)
+	b := jt808SubpackageFrames(t, MsgIDLocationReport, body, 2, 20)
+
+	var done int
+	for _, frame := range [][]byte{a[0], b[0], b[1], a[1]} {
+		msg, err := j.Decode(frame)
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if msg != nil {
+			done++
+		}
+	}
+	if done != 2 {
+		t.Errorf("reassembled %d messages, want 2", done)
+	}
+}
+
+func TestJT808SubpackageInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		total  uint16
+		index  uint16
+		closed bool
+	}{
+		{"zero total", 0, 1, false},
+		{"zero index", 2, 0, false},
+		{"index beyond total", 2, 3, false},
+		{"too many packages", jt808MaxSubpackages + 1, 1, false},
+		{"closed connection", 2, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newJT808Reassembler(NewJT808Adapter())
+			if tt.closed {
+				r.Close()
+			}
+			header := &jt808Header{MsgID: MsgIDLocationReport, Serial: 1, PkgTotal: tt.total, PkgIndex: tt.index}
+			if h, body, err := r.Add(header, []byte{0x01}); err == nil {
+				t.Errorf("expected an error, got %+v %X", h, body)
+			}
+			r.Close()
+		})
+	}
+}
+
+func TestJT808SubpackagePendingLimit(t *testing.T) {
+	r := newJT808Reassembler(NewJT808Adapter())
+	defer r.Close()
+	for i := 0; i < jt808MaxPendingMessages; i++ {
+		header := &jt808Header{MsgID: MsgIDLocationReport, Serial: uint16(i * 10), PkgTotal: 2, PkgIndex: 1}
+		if _, _, err := r.Add(header, []byte{0x01}); err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+	}
+	header := &jt808Header{MsgID: MsgIDLocationReport, Serial: 1000, PkgTotal: 2, PkgIndex: 1}
+	if _, _, err := r.Add(header, []byte{0x01}); err == nil {
+		t.Error("expected the pending message limit to be enforced")
+	}
+}
+
+func TestJT808SubpackageRetransmitRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		// 0x8003 body: first serial, count (1 byte in 2013, 2 in 2019), missing indexes
+		body string
+	}{
+		{"2013", JT808Version2013, "000A0200020004"},
+		{"2019", JT808Version2019, "000A000200020004"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := NewJT808Adapter()
+			defer j.Close()
+			var sent [][]byte
+			j.SetWriter(func(packet []byte) error {
+				sent = append(sent, packet)
+				return nil
+			})
+
+			phone := jt808Hex(t, "013912345678")
+			for _, index := range []uint16{1, 3} {
+				header := &jt808Header{
+					MsgID:    MsgIDLocationReport,
+					Version:  tt.version,
+					Phone:    phone,
+					Serial:   10 + index - 1,
+					PkgTotal: 4,
+					PkgIndex: index,
+				}
+				if _, _, err := j.fragments.Add(header, []byte{byte(index)}); err != nil {
+					t.Fatalf("add %d: %v", index, err)
+				}
+			}
+			j.fragments.expire("0200:4:10")
+
+			if len(sent) != 1 {
+				t.Fatalf("sent %d packets, want 1", len(sent))
+			}
+			content := j.unescape(sent[0])
+			content = content[1 : len(content)-2]
+			header, err := parseJT808Header(content)
+			if err != nil {
+				t.Fatalf("request header: %v", err)
+			}
+			if header.MsgID != MsgIDRetransmitRequest || header.Version != tt.version {
+				t.Errorf("request = 0x%04X %s", header.MsgID, header.Version)
+			}
+			if body := content[header.Len:]; !bytes.Equal(body, jt808Hex(t, tt.body)) {
+				t.Errorf("request body = %X, want %s", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestJT808SubpackageDroppedAfterRetries(t *testing.T) {
+	j := NewJT808Adapter()
+	defer j.Close()
+	var sent int
+	j.SetWriter(func(packet []byte) error {
+		sent++
+		return nil
+	})
+
+	header := &jt808Header{
+		MsgID:    MsgIDLocationReport,
+		Version:  JT808Version2013,
+		Phone:    jt808Hex(t, "013912345678"),
+		Serial:   10,
+		PkgTotal: 2,
+		PkgIndex: 1,
+	}
+	if _, _, err := j.fragments.Add(header, []byte{0x01}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	for i := 0; i <= jt808SubpackageRetries; i++ {
+		j.fragments.expire("0200:2:10")
+	}
+	if sent != jt808SubpackageRetries {
+		t.Errorf("sent %d retransmission requests, want %d", sent, jt808SubpackageRetries)
+	}
+
+	j.fragments.mu.Lock()
+	pending := len(j.fragments.pending)
+	j.fragments.mu.Unlock()
+	if pending != 0 {
+		t.Errorf("%d messages still pending", pending)
+	}
+}
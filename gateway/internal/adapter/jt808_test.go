@@ -0,0 +1,233 @@
+package adapter
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"testing"
+	"time"
+
+	"openfms/gateway/internal/protocol"
+)
+
+// Sample frames of terminal 013912345678, escaped and checksummed
+const (
+	jt808Heartbeat2013 = "7E00020000013912345678007D014F7E" // serial 0x007D, escaped as 7D 01
+	jt808Heartbeat2019 = "7E0002400001000000000139123456780001727E"
+	jt808Location2013  = "7E0200001C013912345678000200000000000000030157FAFC06CAB7B8003201F4005A240115103020117E"
+	jt808Location2019  = "7E0200401C0100000000013912345678000300000000000000030157FAFC06CAB7B8003201F4005A240115103020517E"
+
+	// Platform general acks to the heartbeats, serial 0
+	jt808HeartbeatAck2013 = "7E8001000501391234567800000002007D0100CB7E"
+	jt808HeartbeatAck2019 = "7E80014005010000000001391234567800000002000100F67E"
+)
+
+func jt808Hex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("invalid hex %q: %v", s, err)
+	}
+	return b
+}
+
+// jt808TestFrame appends the checksum to unescaped content, escapes it and
+// wraps it in 0x7E markers
+func jt808TestFrame(content []byte) []byte {
+	var checksum byte
+	for _, b := range content {
+		checksum ^= b
+	}
+	content = append(append([]byte(nil), content...), checksum)
+
+	frame := []byte{JT808Header}
+	for _, b := range content {
+		switch b {
+		case 0x7E:
+			frame = append(frame, 0x7D, 0x02)
+		case 0x7D:
+			frame = append(frame, 0x7D, 0x01)
+		default:
+			frame = append(frame, b)
+		}
+	}
+	return append(frame, JT808Header)
+}
+
+func TestParseJT808Header(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		version  string
+		protoVer byte
+		phone    string
+		serial   uint16
+		pkgTotal uint16
+		pkgIndex uint16
+		length   int
+		wantErr  bool
+	}{
+		{"2013", "00020000013912345678007D4F", JT808Version2013, 0, "013912345678", 0x7D, 0, 0, 12, false},
+		{"2019", "000240000100000000013912345678000172", JT808Version2019, 1, "00000000013912345678", 1, 0, 0, 17, false},
+		{"2013 subpackage", "02002004013912345678000A0003000200000000FF", JT808Version2013, 0, "013912345678", 10, 3, 2, 16, false},
+		{"2019 subpackage", "02006004010000000001391234567800140002000100000000FF", JT808Version2019, 1, "00000000013912345678", 20, 2, 1, 21, false},
+		{"too short", "0002000001391234", "", 0, "", 0, 0, 0, 0, true},
+		{"2019 phone cut", "000240000100000000000139123456", "", 0, "", 0, 0, 0, 0, true},
+		{"subpackage info missing", "02002004013912345678000A0003", "", 0, "", 0, 0, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := parseJT808Header(jt808Hex(t, tt.content))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", h)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if h.Version != tt.version || h.ProtoVer != tt.protoVer || bcdToString(h.Phone) != tt.phone ||
+				h.Serial != tt.serial || h.PkgTotal != tt.pkgTotal || h.PkgIndex != tt.pkgIndex || h.Len != tt.length {
+				t.Errorf("header = %+v (phone %s)", h, bcdToString(h.Phone))
+			}
+		})
+	}
+}
+
+func TestJT808DecodeVersions(t *testing.T) {
+	tests := []struct {
+		name    string
+		frame   string
+		version string
+	}{
+		{"2013", jt808Location2013, JT808Version2013},
+		{"2019", jt808Location2019, JT808Version2019},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := NewJT808Adapter()
+			msg, err := j.Decode(jt808Hex(t, tt.frame))
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if msg.Type != protocol.MsgTypeLocation || msg.DeviceID != "013912345678" {
+				t.Errorf("message = %s from %q", msg.Type, msg.DeviceID)
+			}
+			if msg.Extras["protocol_version"] != tt.version || j.Version() != tt.version {
+				t.Errorf("version = %v, adapter %s", msg.Extras["protocol_version"], j.Version())
+			}
+			if math.Abs(msg.Lat-22.5431) > 1e-9 || math.Abs(msg.Lon-113.9486) > 1e-9 {
+				t.Errorf("position = %f,%f", msg.Lat, msg.Lon)
+			}
+			if msg.Speed != 50 || msg.Direction != 90 || msg.Extras["altitude"] != uint16(50) {
+				t.Errorf("speed %v direction %v altitude %v", msg.Speed, msg.Direction, msg.Extras["altitude"])
+			}
+			// Terminal clocks run in GMT+8
+			if want := time.Date(2024, 1, 15, 2, 30, 20, 0, time.UTC).Unix(); msg.Timestamp != want {
+				t.Errorf("timestamp = %d, want %d", msg.Timestamp, want)
+			}
+		})
+	}
+}
+
+func TestJT808HeartbeatAck(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame string
+		ack   string
+	}{
+		{"2013", jt808Heartbeat2013, jt808HeartbeatAck2013},
+		{"2019", jt808Heartbeat2019, jt808HeartbeatAck2019},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := NewJT808Adapter()
+			packet := jt808Hex(t, tt.frame)
+			if !j.IsHeartbeat(packet) {
+				t.Fatal("not recognized as a heartbeat")
+			}
+			msg, err := j.Decode(packet)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if msg.Type != protocol.MsgTypeHeartbeat || msg.DeviceID != "013912345678" {
+				t.Errorf("message = %s from %q", msg.Type, msg.DeviceID)
+			}
+			ack, err := j.GenerateHeartbeatAck(packet)
+			if err != nil {
+				t.Fatalf("ack: %v", err)
+			}
+			if !bytes.Equal(ack, jt808Hex(t, tt.ack)) {
+				t.Errorf("ack = %X, want %s", ack, tt.ack)
+			}
+		})
+	}
+}
+
+func TestJT808DecodeErrors(t *testing.T) {
+	badChecksum := jt808Hex(t, jt808Location2013)
+	badChecksum[len(badChecksum)-2] ^= 0xFF
+
+	tests := []struct {
+		name   string
+		packet []byte
+	}{
+		{"bad checksum", badChecksum},
+		{"too short", jt808Hex(t, "7E000200007E")},
+		{"missing end marker", jt808Hex(t, jt808Heartbeat2019)[:19]},
+		{"unsupported encryption", jt808TestFrame(jt808Hex(t, "000208000139123456780001"))},
+		{"location body too short", jt808TestFrame(jt808Hex(t, "02000004013912345678000100000000"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if msg, err := NewJT808Adapter().Decode(tt.packet); err == nil {
+				t.Errorf("expected an error, got %+v", msg)
+			}
+		})
+	}
+}
+
+func TestJT808EscapeRoundTrip(t *testing.T) {
+	j := NewJT808Adapter()
+	data := []byte{0x01, 0x7E, 0x02, 0x7D, 0x7D, 0x02, 0x7E}
+	escaped := j.escape(data)
+	if want := []byte{0x01, 0x7D, 0x02, 0x02, 0x7D, 0x01, 0x7D, 0x01, 0x02, 0x7D, 0x02}; !bytes.Equal(escaped, want) {
+		t.Errorf("escape = %X, want %X", escaped, want)
+	}
+	if got := j.unescape(escaped); !bytes.Equal(got, data) {
+		t.Errorf("unescape = %X, want %X", got, data)
+	}
+}
+
+func TestJT808Scanner(t *testing.T) {
+	heartbeat, location := jt808Hex(t, jt808Heartbeat2013), jt808Hex(t, jt808Location2019)
+	stream := append([]byte{0x00, 0x01}, heartbeat...)
+	stream = append(stream, location...)
+
+	for _, chunk := range []int{1, 3, 8, len(stream)} {
+		var packets [][]byte
+		var buffer []byte
+		for offset := 0; offset < len(stream); offset += chunk {
+			end := offset + chunk
+			if end > len(stream) {
+				end = len(stream)
+			}
+			buffer = append(buffer, stream[offset:end]...)
+			for {
+				packet, rest, err := JT808Scanner{}.Scan(buffer)
+				if err != nil {
+					t.Fatalf("chunk %d: unexpected error: %v", chunk, err)
+				}
+				buffer = rest
+				if packet == nil {
+					break
+				}
+				packets = append(packets, append([]byte(nil), packet...))
+			}
+		}
+		if len(packets) != 2 || !bytes.Equal(packets[0], heartbeat) || !bytes.Equal(packets[1], location) {
+			t.Errorf("chunk %d: got packets %X", chunk, packets)
+		}
+	}
+}
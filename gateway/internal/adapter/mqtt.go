@@ -0,0 +1,146 @@
+// MQTT 设备适配器
+// 设备通过 MQTT 发布 JSON 载荷，消息类型由主题决定；指令以 JSON 发布到设备的指令主题
+
+package adapter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"openfms/gateway/internal/protocol"
+)
+
+// MQTTAdapter MQTT设备适配器，每个设备一个实例
+type MQTTAdapter struct {
+	deviceID string
+	clock    DeviceClock
+}
+
+// NewMQTTAdapter 创建MQTT适配器，clock 用于时间偏差检查
+func NewMQTTAdapter(deviceID string, clock DeviceClock) *MQTTAdapter {
+	return &MQTTAdapter{deviceID: deviceID, clock: clock}
+}
+
+// Match MQTT 不经过协议识别
+func (a *MQTTAdapter) Match(header []byte) bool {
+	return false
+}
+
+// Decode 按位置消息解析载荷，其他类型见 DecodePayload
+func (a *MQTTAdapter) Decode(packet []byte) (*protocol.StandardMessage, error) {
+	return a.DecodePayload(protocol.MsgTypeLocation, packet)
+}
+
+// DecodePayload 解析主题对应类型的 JSON 载荷:
+// {"lat": 30.1, "lon": 120.2, "speed": 36.5, "direction": 90, "timestamp": 1700000000, ...}
+// 速度单位为 km/h，时间支持 Unix 秒/毫秒和 RFC 3339，其余字段放入 Extras。
+// ALARM 载荷带 alarm_type/alarm_edge，COMMAND_RESPONSE 载荷带 command_id/success/error/data
+func (a *MQTTAdapter) DecodePayload(msgType string, payload []byte) (*protocol.StandardMessage, error) {
+	var fields map[string]interface{}
+	if len(payload) > 0 {
+		if err := json.Unmarshal(payload, &fields); err != nil {
+			return nil, fmt.Errorf("invalid MQTT payload: %w", err)
+		}
+	}
+
+	receivedAt := time.Now().Unix()
+	msg := &protocol.StandardMessage{
+		DeviceID:  a.deviceID,
+		Type:      msgType,
+		Timestamp: receivedAt,
+		Extras:    make(map[string]interface{}),
+	}
+	msg.Extras["received_at"] = receivedAt
+
+	deviceTime := time.Now()
+	var hasLat, hasLon bool
+	for key, value := range fields {
+		switch key {
+		case "device_id", "id":
+			// 设备ID以主题为准
+		case "lat":
+			msg.Lat, hasLat = jsonNumber(value)
+		case "lon":
+			msg.Lon, hasLon = jsonNumber(value)
+		case "speed":
+			msg.Speed, _ = jsonNumber(value)
+		case "direction", "bearing", "heading":
+			msg.Direction, _ = jsonNumber(value)
+		case "timestamp":
+			t, err := parseOsmAndTime(jsonString(value))
+			if err != nil {
+				return nil, err
+			}
+			deviceTime = t
+		default:
+			msg.Extras[key] = value
+		}
+	}
+	a.clock.Stamp(msg, deviceTime)
+
+	switch msgType {
+	case protocol.MsgTypeLocation:
+		// 缺少或非数值的坐标不按 0,0 上报
+		if !hasLat || !hasLon {
+			return nil, errors.New("missing coordinates")
+		}
+
+	case protocol.MsgTypeAlarm:
+		if _, ok := msg.Extras["alarm_type"].(string); !ok {
+			return nil, errors.New("alarm without alarm_type")
+		}
+		if _, ok := msg.Extras["alarm_edge"]; !ok {
+			msg.Extras["alarm_edge"] = protocol.AlarmEdgeRising
+		}
+
+	case protocol.MsgTypeCommandResponse:
+		commandID, _ := msg.Extras["command_id"].(string)
+		if commandID == "" {
+			return nil, errors.New("command response without command_id")
+		}
+		success, _ := msg.Extras["success"].(bool)
+		errText, _ := msg.Extras["error"].(string)
+		data, _ := msg.Extras["data"].(map[string]interface{})
+		msg.Response = &protocol.CommandResponse{
+			CommandID: commandID,
+			DeviceID:  a.deviceID,
+			Success:   success,
+			Error:     errText,
+			Data:      data,
+		}
+	}
+	return msg, nil
+}
+
+// Encode 编码指令为 JSON:
+// {"command_id": "...", "type": "LOCATION_QUERY", "params": {...}, "timestamp": 1700000000}
+func (a *MQTTAdapter) Encode(cmd protocol.StandardCommand) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"command_id": cmd.CommandID,
+		"type":       cmd.Type,
+		"params":     cmd.Params,
+		"timestamp":  time.Now().Unix(),
+	})
+}
+
+// IsHeartbeat 心跳通过 HEARTBEAT 主题上报，由主题决定
+func (a *MQTTAdapter) IsHeartbeat(packet []byte) bool {
+	return false
+}
+
+// GenerateHeartbeatAck MQTT 心跳无需应答
+func (a *MQTTAdapter) GenerateHeartbeatAck(packet []byte) ([]byte, error) {
+	return nil, nil
+}
+
+// Protocol 返回协议标识
+func (a *MQTTAdapter) Protocol() string {
+	return "MQTT"
+}
+
+// Scanner MQTT 消息自带边界，无需分包
+func (a *MQTTAdapter) Scanner() protocol.PacketScanner {
+	return nil
+}
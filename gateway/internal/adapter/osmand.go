@@ -0,0 +1,282 @@
+// OsmAnd 协议解析器
+// 手机客户端 (OsmAnd、Traccar Client) 通过 HTTP 上报位置，支持查询参数/表单和 JSON 两种格式
+
+package adapter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"openfms/gateway/internal/protocol"
+)
+
+// osmandAlarms OsmAnd alarm 参数与平台报警类型的对应关系
+var osmandAlarms = map[string]string{
+	"sos":        protocol.AlarmSOS,
+	"overspeed":  protocol.AlarmOverspeed,
+	"lowbattery": protocol.AlarmLowBattery,
+	"powercut":   protocol.AlarmPowerCut,
+	"vibration":  protocol.AlarmVibration,
+	"movement":   protocol.AlarmIllegalMove,
+	"accident":   protocol.AlarmCollision,
+	"tampering":  protocol.AlarmTheft,
+}
+
+// OsmAndDecoder OsmAnd 位置解析器，HTTP 请求无连接状态，可并发使用
+type OsmAndDecoder struct {
+	clock DeviceClock
+}
+
+// NewOsmAndDecoder 创建OsmAnd解析器，clock 用于时间偏差检查
+func NewOsmAndDecoder(clock DeviceClock) *OsmAndDecoder {
+	return &OsmAndDecoder{clock: clock}
+}
+
+// Protocol 返回协议标识
+func (d *OsmAndDecoder) Protocol() string {
+	return "OSMAND"
+}
+
+// DecodeQuery 解析查询参数/表单格式:
+// ?id=123456&lat=30.1&lon=120.2&timestamp=1700000000&speed=10&bearing=90&altitude=12&accuracy=5&batt=80
+// 速度单位为节，未识别的参数原样放入 Extras
+func (d *OsmAndDecoder) DecodeQuery(values url.Values) (*protocol.StandardMessage, error) {
+	fields := make(map[string]string, len(values))
+	for key, v := range values {
+		if len(v) > 0 {
+			fields[key] = v[0]
+		}
+	}
+	return d.decodeFields(fields)
+}
+
+// DecodeJSON 解析 JSON 格式，支持与查询参数同名字段的平铺对象，
+// 以及 Traccar Client 的 {"device_id", "location": {"coords", "battery", ...}} 格式
+func (d *OsmAndDecoder) DecodeJSON(body []byte) (*protocol.StandardMessage, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, fmt.Errorf("invalid OsmAnd JSON: %w", err)
+	}
+
+	location, ok := raw["location"].(map[string]interface{})
+	if !ok {
+		fields := make(map[string]string, len(raw))
+		for key, value := range raw {
+			fields[key] = jsonString(value)
+		}
+		return d.decodeFields(fields)
+	}
+
+	deviceID := jsonString(raw["device_id"])
+	if deviceID == "" {
+		return nil, errors.New("missing device_id")
+	}
+	coords, _ := location["coords"].(map[string]interface{})
+	lat, okLat := jsonNumber(coords["latitude"])
+	lon, okLon := jsonNumber(coords["longitude"])
+	if !okLat || !okLon {
+		return nil, errors.New("missing coordinates")
+	}
+
+	msg := d.newMessage(deviceID)
+	msg.Lat = lat
+	msg.Lon = lon
+	msg.Extras["location_valid"] = true
+	if speed, ok := jsonNumber(coords["speed"]); ok && speed >= 0 {
+		msg.Speed = speed * 3.6 // m/s -> km/h
+	}
+	if heading, ok := jsonNumber(coords["heading"]); ok && heading >= 0 {
+		msg.Direction = heading
+	}
+	if altitude, ok := jsonNumber(coords["altitude"]); ok {
+		msg.Extras["altitude"] = altitude
+	}
+	if accuracy, ok := jsonNumber(coords["accuracy"]); ok {
+		msg.Extras["accuracy"] = accuracy
+	}
+	if battery, ok := location["battery"].(map[string]interface{}); ok {
+		if level, ok := jsonNumber(battery["level"]); ok && level >= 0 {
+			msg.Extras["battery_level"] = math.Round(level * 100)
+		}
+		if charging, ok := battery["is_charging"].(bool); ok {
+			msg.Extras["charging"] = charging
+		}
+	}
+	if odometer, ok := jsonNumber(location["odometer"]); ok {
+		msg.Extras["odometer"] = odometer // m
+	}
+	if moving, ok := location["is_moving"].(bool); ok {
+		msg.Extras["motion"] = moving
+	}
+	if event := jsonString(location["event"]); event != "" {
+		msg.Extras["event"] = event
+	}
+	if activity, ok := location["activity"].(map[string]interface{}); ok {
+		if kind := jsonString(activity["type"]); kind != "" {
+			msg.Extras["activity"] = kind
+		}
+	}
+
+	deviceTime := time.Now()
+	if ts := jsonString(location["timestamp"]); ts != "" {
+		t, err := parseOsmAndTime(ts)
+		if err != nil {
+			return nil, err
+		}
+		deviceTime = t
+	}
+	d.clock.Stamp(msg, deviceTime)
+	return msg, nil
+}
+
+// decodeFields 解析查询参数字段
+func (d *OsmAndDecoder) decodeFields(fields map[string]string) (*protocol.StandardMessage, error) {
+	deviceID := fields["id"]
+	if deviceID == "" {
+		deviceID = fields["deviceid"]
+	}
+	if deviceID == "" {
+		return nil, errors.New("missing device id")
+	}
+	msg := d.newMessage(deviceID)
+
+	deviceTime := time.Now()
+	hasLat, hasLon := false, false
+	for key, value := range fields {
+		var err error
+		switch key {
+		case "id", "deviceid":
+		case "timestamp":
+			deviceTime, err = parseOsmAndTime(value)
+		case "lat":
+			msg.Lat, err = strconv.ParseFloat(value, 64)
+			hasLat = true
+		case "lon":
+			msg.Lon, err = strconv.ParseFloat(value, 64)
+			hasLon = true
+		case "location":
+			// "lat,lon"
+			lat, lon, found := strings.Cut(value, ",")
+			if !found {
+				err = errors.New("expected lat,lon")
+				break
+			}
+			if msg.Lat, err = strconv.ParseFloat(lat, 64); err == nil {
+				msg.Lon, err = strconv.ParseFloat(lon, 64)
+			}
+			hasLat, hasLon = true, true
+		case "speed":
+			var knots float64
+			knots, err = strconv.ParseFloat(value, 64)
+			msg.Speed = knots * 1.852 // kn -> km/h
+		case "bearing", "heading":
+			msg.Direction, err = strconv.ParseFloat(value, 64)
+		case "altitude", "accuracy", "hdop":
+			var f float64
+			f, err = strconv.ParseFloat(value, 64)
+			msg.Extras[key] = f
+		case "batt":
+			var f float64
+			f, err = strconv.ParseFloat(value, 64)
+			msg.Extras["battery_level"] = f
+		case "charge":
+			msg.Extras["charging"] = value == "true" || value == "1"
+		case "valid":
+			msg.Extras["location_valid"] = value == "true" || value == "1"
+		case "alarm":
+			msg.Extras["alarm"] = value
+		default:
+			msg.Extras[key] = value
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid OsmAnd %s %q: %w", key, value, err)
+		}
+	}
+	if !hasLat || !hasLon {
+		return nil, errors.New("missing coordinates")
+	}
+	if _, ok := msg.Extras["location_valid"]; !ok {
+		msg.Extras["location_valid"] = true
+	}
+	d.clock.Stamp(msg, deviceTime)
+
+	if alarm, _ := msg.Extras["alarm"].(string); alarm != "" {
+		if alarmType, ok := osmandAlarms[strings.ToLower(alarm)]; ok {
+			msg.Alarms = append(msg.Alarms, d.alarm(msg, alarmType))
+		}
+	}
+	return msg, nil
+}
+
+func (d *OsmAndDecoder) newMessage(deviceID string) *protocol.StandardMessage {
+	receivedAt := time.Now().Unix()
+	msg := &protocol.StandardMessage{
+		DeviceID:  deviceID,
+		Type:      protocol.MsgTypeLocation,
+		Timestamp: receivedAt,
+		Extras:    make(map[string]interface{}),
+	}
+	msg.Extras["received_at"] = receivedAt
+	return msg
+}
+
+// alarm 生成位置附带的报警消息，手机客户端无报警解除
+func (d *OsmAndDecoder) alarm(msg *protocol.StandardMessage, alarmType string) *protocol.StandardMessage {
+	alarm := &protocol.StandardMessage{
+		DeviceID:  msg.DeviceID,
+		Type:      protocol.MsgTypeAlarm,
+		Timestamp: msg.Timestamp,
+		Lat:       msg.Lat,
+		Lon:       msg.Lon,
+		Speed:     msg.Speed,
+		Direction: msg.Direction,
+		Extras:    make(map[string]interface{}),
+	}
+	alarm.Extras["alarm_type"] = alarmType
+	alarm.Extras["alarm_edge"] = protocol.AlarmEdgeRising
+	alarm.Extras["received_at"] = msg.Extras["received_at"]
+	return alarm
+}
+
+// parseOsmAndTime 解析时间: Unix 秒/毫秒、RFC 3339 或 "2006-01-02 15:04:05" (UTC)
+func parseOsmAndTime(value string) (time.Time, error) {
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		if f > 1e12 {
+			return time.UnixMilli(int64(f)), nil
+		}
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*1e9)), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02 15:04:05", value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid OsmAnd timestamp %q", value)
+}
+
+// jsonString 将 JSON 值转为字符串，数字不使用科学计数法
+func jsonString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func jsonNumber(value interface{}) (float64, bool) {
+	f, ok := value.(float64)
+	return f, ok
+}
@@ -0,0 +1,164 @@
+package adapter
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"openfms/gateway/internal/protocol"
+)
+
+// Options are the gateway-wide settings adapters are created with
+type Options struct {
+	// JT808 configures JT808 adapters
+	JT808 JT808Options
+	// Clock is the device clock of protocols reporting UTC (GT06, Wialon,
+	// H02)
+	Clock DeviceClock
+	// TK103Clock is the device clock of TK103 terminals, which report
+	// local time
+	TK103Clock DeviceClock
+}
+
+// Registration describes a protocol adapter known to the gateway
+type Registration struct {
+	// Name identifies the protocol in configuration, e.g. "JT808"
+	Name string
+	// Detect reports whether the first bytes of a connection belong to the
+	// protocol; it sees at least two bytes
+	Detect func(header []byte) bool
+	// New creates the adapter serving a single connection; its Scanner
+	// frames the rest of the stream
+	New func(opts Options) protocol.ProtocolAdapter
+}
+
+// Every shipped adapter implements the full adapter interface
+var (
+	_ protocol.ProtocolAdapter = (*JT808Adapter)(nil)
+	_ protocol.ProtocolAdapter = (*GT06Adapter)(nil)
+	_ protocol.ProtocolAdapter = (*WialonAdapter)(nil)
+	_ protocol.ProtocolAdapter = (*TeltonikaAdapter)(nil)
+	_ protocol.ProtocolAdapter = (*H02Adapter)(nil)
+	_ protocol.ProtocolAdapter = (*TK103Adapter)(nil)
+	_ protocol.Outbound        = (*JT808Adapter)(nil)
+	_ protocol.Outbound        = (*GT06Adapter)(nil)
+	_ protocol.Outbound        = (*WialonAdapter)(nil)
+	_ protocol.Outbound        = (*TeltonikaAdapter)(nil)
+	_ protocol.Outbound        = (*TK103Adapter)(nil)
+	_ protocol.AlarmTracker    = (*JT808Adapter)(nil)
+	_ protocol.AlarmTracker    = (*H02Adapter)(nil)
+	_ protocol.Closer          = (*JT808Adapter)(nil)
+)
+
+var (
+	registryMu    sync.RWMutex
+	registrations []Registration
+)
+
+// Register makes a protocol adapter available to the gateway. Adapters
+// register themselves from init; a duplicate name panics.
+func Register(r Registration) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if r.Name == "" || r.Detect == nil || r.New == nil {
+		panic("adapter: incomplete registration " + r.Name)
+	}
+	for _, existing := range registrations {
+		if strings.EqualFold(existing.Name, r.Name) {
+			panic("adapter: Register called twice for " + r.Name)
+		}
+	}
+	registrations = append(registrations, r)
+}
+
+// Lookup returns the registration of a protocol name (case-insensitive)
+func Lookup(name string) (Registration, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	for _, r := range registrations {
+		if strings.EqualFold(r.Name, name) {
+			return r, true
+		}
+	}
+	return Registration{}, false
+}
+
+// Names returns the registered protocol names in registration order
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registrations))
+	for _, r := range registrations {
+		names = append(names, r.Name)
+	}
+	return names
+}
+
+// Detector auto-detects the protocol of a connection among a set of
+// registered adapters
+type Detector struct {
+	options       Options
+	registrations []Registration
+	fixed         bool // bind every connection without detection
+	all           bool // follow the registry, including later registrations
+}
+
+// NewDetector creates a detector over the named protocols, or over all
+// registered ones when names is empty. The latter also detects protocols
+// registered afterwards, such as decoder scripts loaded on reload.
+func NewDetector(opts Options, names ...string) (*Detector, error) {
+	if len(names) == 0 {
+		return &Detector{options: opts, all: true}, nil
+	}
+	d := &Detector{options: opts}
+	for _, name := range names {
+		r, ok := Lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown protocol %q (registered: %s)", name, strings.Join(Names(), ", "))
+		}
+		d.registrations = append(d.registrations, r)
+	}
+	return d, nil
+}
+
+// NewFixedDetector creates a detector binding every connection to the named
+// protocol without looking at its header, for ports dedicated to a protocol
+func NewFixedDetector(opts Options, name string) (*Detector, error) {
+	d, err := NewDetector(opts, name)
+	if err != nil {
+		return nil, err
+	}
+	d.fixed = true
+	return d, nil
+}
+
+// Match implements protocol.Detector, returning a fresh adapter for the
+// first protocol recognizing the header
+func (d *Detector) Match(headerBytes []byte) (protocol.ProtocolAdapter, bool) {
+	for _, r := range d.current() {
+		if d.fixed || r.Detect(headerBytes) {
+			return r.New(d.options), true
+		}
+	}
+	return nil, false
+}
+
+// Protocols returns the names of the protocols the detector recognizes
+func (d *Detector) Protocols() []string {
+	registrations := d.current()
+	names := make([]string, 0, len(registrations))
+	for _, r := range registrations {
+		names = append(names, r.Name)
+	}
+	return names
+}
+
+// current returns the registrations the detector picks from
+func (d *Detector) current() []Registration {
+	if !d.all {
+		return d.registrations
+	}
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return registrations
+}
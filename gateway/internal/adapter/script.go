@@ -0,0 +1,539 @@
+// 脚本协议适配器
+// 小众终端的解码器以 JavaScript 脚本编写，放在脚本目录中，无需修改网关代码。
+// 每个 *.js 文件注册为一个协议，协议名为大写的文件名 (mydevice.js -> MYDEVICE)。
+//
+// 脚本定义以下全局函数，字节数据均为 Uint8Array:
+//
+//	detect(header)   可选，连接的前几个字节是否属于该协议；未定义时只能用于专用端口
+//	frame(buffer)    可选，返回第一个完整报文的长度，0 表示需要更多数据，
+//	                 负数表示丢弃一个字节重新同步；未定义时每次读到的数据为一个报文
+//	decode(packet)   必需，返回 {device_id, type, timestamp, lat, lon, speed, direction,
+//	                 extras, alarms: [{type, edge, extras}], response: {command_id, success,
+//	                 error, data}, ack}；ack 为应答 (字符串、字节数组或 Uint8Array)，
+//	                 type 为空时只发送应答；返回 null 忽略该报文
+//	encode(command)  可选，将 {command_id, type, params} 编码为下发数据
+//
+// 脚本运行在独立的 JavaScript 虚拟机中，无文件和网络访问，只提供 log()。
+// 每次调用受时间预算限制，超时即中断；每个连接一个虚拟机，全局变量可保存连接状态。
+
+package adapter
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dop251/goja"
+
+	"openfms/gateway/internal/protocol"
+)
+
+// scriptMaxCallStack 脚本调用栈深度上限，防止无限递归
+const scriptMaxCallStack = 1024
+
+// ScriptSet 脚本目录中的协议脚本，Reload 重新编译变化的文件
+type ScriptSet struct {
+	dir     string
+	timeout time.Duration
+
+	mu       sync.Mutex // 串行化加载
+	scripts  map[string]*scriptProtocol
+	modTimes map[string]time.Time // 上次加载时各文件的修改时间，含加载失败的文件
+}
+
+// scriptProtocol 一个脚本协议，重新加载后新连接使用新版本，已有连接继续使用旧版本
+type scriptProtocol struct {
+	name    string
+	current atomic.Pointer[scriptProgram] // 脚本文件删除后为 nil
+}
+
+// scriptProgram 编译后的脚本
+type scriptProgram struct {
+	name    string
+	program *goja.Program
+	modTime time.Time
+	timeout time.Duration
+
+	detectVM *scriptVM // 所有连接的 detect 共用的虚拟机
+}
+
+// LoadScripts 加载脚本目录并注册其中的协议，任一脚本无效时返回错误
+func LoadScripts(dir string, timeout time.Duration) (*ScriptSet, error) {
+	s := &ScriptSet{
+		dir:     dir,
+		timeout: timeout,
+		scripts: make(map[string]*scriptProtocol),
+	}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload 编译新增和修改的脚本，卸载已删除的脚本。
+// 编译失败的脚本保留原版本，返回所有失败的原因。
+func (s *ScriptSet) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read script directory: %w", err)
+	}
+
+	var errs []error
+	seen := make(map[string]bool)
+	s.modTimes = make(map[string]time.Time)
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".js" {
+			continue
+		}
+		name := strings.ToUpper(strings.TrimSuffix(entry.Name(), ".js"))
+		seen[name] = true
+		info, err := entry.Info()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		s.modTimes[entry.Name()] = info.ModTime()
+		p := s.scripts[name]
+		if p != nil {
+			if prog := p.current.Load(); prog != nil && prog.modTime.Equal(info.ModTime()) {
+				continue
+			}
+		} else if _, exists := Lookup(name); exists {
+			errs = append(errs, fmt.Errorf("%s: protocol %s is already registered", entry.Name(), name))
+			continue
+		}
+
+		prog, err := compileScript(name, filepath.Join(s.dir, entry.Name()), info.ModTime(), s.timeout)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", entry.Name(), err))
+			continue
+		}
+		if p == nil {
+			p = &scriptProtocol{name: name}
+			p.current.Store(prog)
+			s.scripts[name] = p
+			Register(Registration{Name: name, Detect: p.detect, New: p.newAdapter})
+		} else {
+			p.current.Store(prog)
+		}
+		log.Printf("[Script] Loaded protocol %s from %s", name, entry.Name())
+	}
+
+	for name, p := range s.scripts {
+		if !seen[name] && p.current.Load() != nil {
+			p.current.Store(nil)
+			log.Printf("[Script] Unloaded protocol %s", name)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Changed 判断脚本目录自上次加载后是否有新增、修改或删除的脚本
+func (s *ScriptSet) Changed() bool {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".js" {
+			continue
+		}
+		count++
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if modTime, ok := s.modTimes[entry.Name()]; !ok || !modTime.Equal(info.ModTime()) {
+			return true
+		}
+	}
+	return count != len(s.modTimes)
+}
+
+// compileScript 编译脚本并试运行，检查必需的 decode 函数
+func compileScript(name, path string, modTime time.Time, timeout time.Duration) (*scriptProgram, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	program, err := goja.Compile(filepath.Base(path), string(src), false)
+	if err != nil {
+		return nil, err
+	}
+	prog := &scriptProgram{name: name, program: program, modTime: modTime, timeout: timeout}
+	if prog.detectVM, err = prog.newVM(); err != nil {
+		return nil, err
+	}
+	return prog, nil
+}
+
+// detect 用脚本的 detect 函数识别连接
+func (p *scriptProtocol) detect(header []byte) bool {
+	prog := p.current.Load()
+	if prog == nil || prog.detectVM.detect == nil {
+		return false
+	}
+	v, err := prog.detectVM.call(prog.detectVM.detect, header)
+	if err != nil {
+		log.Printf("[Script] %s detect failed: %v", p.name, err)
+		return false
+	}
+	return v.ToBoolean()
+}
+
+// newAdapter 为新连接创建适配器
+func (p *scriptProtocol) newAdapter(opts Options) protocol.ProtocolAdapter {
+	a := &ScriptAdapter{name: p.name, clock: opts.Clock}
+	prog := p.current.Load()
+	if prog == nil {
+		a.err = fmt.Errorf("protocol script %s was removed", p.name)
+		return a
+	}
+	if a.vm, a.err = prog.newVM(); a.err != nil {
+		log.Printf("[Script] %s: %v", p.name, a.err)
+	}
+	return a
+}
+
+// ScriptAdapter 脚本协议适配器，每个连接一个实例
+type ScriptAdapter struct {
+	name  string
+	clock DeviceClock
+	vm    *scriptVM
+	err   error // 虚拟机创建失败的原因
+
+	mu       sync.Mutex
+	deviceID string // 脚本最近返回的设备ID
+	write    func(packet []byte) error
+}
+
+// SetWriter 实现 protocol.Outbound，用于发送脚本返回的应答
+func (a *ScriptAdapter) SetWriter(write func(packet []byte) error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.write = write
+}
+
+// Match 由注册时的 detect 函数识别
+func (a *ScriptAdapter) Match(header []byte) bool {
+	return false
+}
+
+// Decode 调用脚本的 decode 函数
+func (a *ScriptAdapter) Decode(packet []byte) (*protocol.StandardMessage, error) {
+	if a.err != nil {
+		return nil, a.err
+	}
+	v, err := a.vm.call(a.vm.decode, packet)
+	if err != nil {
+		return nil, err
+	}
+	if goja.IsUndefined(v) || goja.IsNull(v) {
+		return nil, nil
+	}
+	result, ok := v.Export().(map[string]interface{})
+	if !ok {
+		return nil, errors.New("decode must return an object")
+	}
+
+	if ack, ok := result["ack"]; ok && ack != nil {
+		b, err := scriptBytes(ack)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ack: %w", err)
+		}
+		a.send(b)
+	}
+
+	a.mu.Lock()
+	if id := jsonString(result["device_id"]); id != "" {
+		a.deviceID = id
+	}
+	deviceID := a.deviceID
+	a.mu.Unlock()
+
+	msgType := jsonString(result["type"])
+	if msgType == "" {
+		return nil, nil
+	}
+	receivedAt := time.Now().Unix()
+	msg := &protocol.StandardMessage{
+		DeviceID:  deviceID,
+		Type:      msgType,
+		Timestamp: receivedAt,
+		Extras:    make(map[string]interface{}),
+	}
+	msg.Lat, _ = scriptNumber(result["lat"])
+	msg.Lon, _ = scriptNumber(result["lon"])
+	msg.Speed, _ = scriptNumber(result["speed"])
+	msg.Direction, _ = scriptNumber(result["direction"])
+	if extras, ok := result["extras"].(map[string]interface{}); ok {
+		for key, value := range extras {
+			msg.Extras[key] = value
+		}
+	}
+	msg.Extras["received_at"] = receivedAt
+	if ts := jsonString(result["timestamp"]); ts != "" {
+		t, err := parseOsmAndTime(ts)
+		if err != nil {
+			return nil, err
+		}
+		a.clock.Stamp(msg, t)
+	}
+
+	alarms, _ := result["alarms"].([]interface{})
+	for _, item := range alarms {
+		fields, ok := item.(map[string]interface{})
+		if !ok || jsonString(fields["type"]) == "" {
+			return nil, errors.New("alarm without type")
+		}
+		msg.Alarms = append(msg.Alarms, scriptAlarm(msg, fields))
+	}
+
+	if response, ok := result["response"].(map[string]interface{}); ok {
+		commandID := jsonString(response["command_id"])
+		if commandID == "" {
+			return nil, errors.New("command response without command_id")
+		}
+		success, _ := response["success"].(bool)
+		data, _ := response["data"].(map[string]interface{})
+		msg.Response = &protocol.CommandResponse{
+			CommandID: commandID,
+			DeviceID:  deviceID,
+			Success:   success,
+			Error:     jsonString(response["error"]),
+			Data:      data,
+		}
+	}
+	return msg, nil
+}
+
+// Encode 调用脚本的 encode 函数
+func (a *ScriptAdapter) Encode(cmd protocol.StandardCommand) ([]byte, error) {
+	if a.err != nil {
+		return nil, a.err
+	}
+	if a.vm.encode == nil {
+		return nil, fmt.Errorf("unsupported command: %s", cmd.Type)
+	}
+	params := cmd.Params
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	v, err := a.vm.call(a.vm.encode, map[string]interface{}{
+		"command_id": cmd.CommandID,
+		"type":       cmd.Type,
+		"params":     params,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if goja.IsUndefined(v) || goja.IsNull(v) {
+		return nil, fmt.Errorf("unsupported command: %s", cmd.Type)
+	}
+	return scriptBytes(v.Export())
+}
+
+// IsHeartbeat 心跳应答由 decode 返回的 ack 发送
+func (a *ScriptAdapter) IsHeartbeat(packet []byte) bool {
+	return false
+}
+
+// GenerateHeartbeatAck 见 IsHeartbeat
+func (a *ScriptAdapter) GenerateHeartbeatAck(packet []byte) ([]byte, error) {
+	return nil, nil
+}
+
+// Protocol 返回协议标识，即脚本的协议名
+func (a *ScriptAdapter) Protocol() string {
+	return a.name
+}
+
+// Scanner 返回调用脚本 frame 函数的分包器
+func (a *ScriptAdapter) Scanner() protocol.PacketScanner {
+	return scriptScanner{a}
+}
+
+// scriptScanner 脚本分包器
+type scriptScanner struct {
+	a *ScriptAdapter
+}
+
+// Scan 由 frame 函数确定报文长度，未定义 frame 时整段数据为一个报文
+func (s scriptScanner) Scan(buffer []byte) ([]byte, []byte, error) {
+	vm := s.a.vm
+	if s.a.err != nil || vm.frame == nil {
+		return buffer, nil, nil
+	}
+	v, err := vm.call(vm.frame, buffer)
+	if err != nil {
+		return nil, buffer[1:], err
+	}
+	n := v.ToInteger()
+	switch {
+	case n == 0:
+		return nil, buffer, nil
+	case n < 0:
+		return nil, buffer[1:], errors.New("frame: invalid data, skipping a byte")
+	case n > int64(len(buffer)):
+		return nil, buffer, nil
+	}
+	return buffer[:n], buffer[n:], nil
+}
+
+// send 向终端发送脚本返回的应答
+func (a *ScriptAdapter) send(packet []byte) {
+	a.mu.Lock()
+	write := a.write
+	a.mu.Unlock()
+	if write == nil || len(packet) == 0 {
+		return
+	}
+	if err := write(packet); err != nil {
+		log.Printf("[Script] %s failed to send ack: %v", a.name, err)
+	}
+}
+
+// scriptAlarm 生成脚本返回的报警消息，默认为触发沿
+func scriptAlarm(msg *protocol.StandardMessage, fields map[string]interface{}) *protocol.StandardMessage {
+	alarm := &protocol.StandardMessage{
+		DeviceID:  msg.DeviceID,
+		Type:      protocol.MsgTypeAlarm,
+		Timestamp: msg.Timestamp,
+		Lat:       msg.Lat,
+		Lon:       msg.Lon,
+		Speed:     msg.Speed,
+		Direction: msg.Direction,
+		Extras:    make(map[string]interface{}),
+	}
+	if extras, ok := fields["extras"].(map[string]interface{}); ok {
+		for key, value := range extras {
+			alarm.Extras[key] = value
+		}
+	}
+	edge := jsonString(fields["edge"])
+	if edge != protocol.AlarmEdgeFalling {
+		edge = protocol.AlarmEdgeRising
+	}
+	alarm.Extras["alarm_type"] = jsonString(fields["type"])
+	alarm.Extras["alarm_edge"] = edge
+	alarm.Extras["received_at"] = msg.Extras["received_at"]
+	return alarm
+}
+
+// scriptVM 一个脚本虚拟机及其导出的函数，调用互相串行
+type scriptVM struct {
+	mu      sync.Mutex
+	rt      *goja.Runtime
+	timeout time.Duration
+
+	detect goja.Callable
+	frame  goja.Callable
+	decode goja.Callable
+	encode goja.Callable
+}
+
+// newVM 创建虚拟机并运行脚本
+func (p *scriptProgram) newVM() (*scriptVM, error) {
+	vm := &scriptVM{rt: goja.New(), timeout: p.timeout}
+	vm.rt.SetMaxCallStackSize(scriptMaxCallStack)
+	vm.rt.Set("log", func(call goja.FunctionCall) goja.Value {
+		args := make([]string, len(call.Arguments))
+		for i, arg := range call.Arguments {
+			args[i] = arg.String()
+		}
+		log.Printf("[Script] %s: %s", p.name, strings.Join(args, " "))
+		return goja.Undefined()
+	})
+
+	timer := time.AfterFunc(p.timeout, func() {
+		vm.rt.Interrupt("script timeout")
+	})
+	_, err := vm.rt.RunProgram(p.program)
+	timer.Stop()
+	vm.rt.ClearInterrupt()
+	if err != nil {
+		return nil, err
+	}
+
+	var ok bool
+	if vm.decode, ok = goja.AssertFunction(vm.rt.Get("decode")); !ok {
+		return nil, errors.New("script does not define decode(packet)")
+	}
+	vm.detect, _ = goja.AssertFunction(vm.rt.Get("detect"))
+	vm.frame, _ = goja.AssertFunction(vm.rt.Get("frame"))
+	vm.encode, _ = goja.AssertFunction(vm.rt.Get("encode"))
+	return vm, nil
+}
+
+// call 在时间预算内调用脚本函数，[]byte 参数以 Uint8Array 传入
+func (vm *scriptVM) call(fn goja.Callable, arg interface{}) (goja.Value, error) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	value := vm.rt.ToValue(arg)
+	if b, ok := arg.([]byte); ok {
+		buf := vm.rt.NewArrayBuffer(append([]byte(nil), b...))
+		array, err := vm.rt.New(vm.rt.Get("Uint8Array"), vm.rt.ToValue(buf))
+		if err != nil {
+			return nil, err
+		}
+		value = array
+	}
+
+	timer := time.AfterFunc(vm.timeout, func() {
+		vm.rt.Interrupt("script timeout")
+	})
+	v, err := fn(goja.Undefined(), value)
+	timer.Stop()
+	vm.rt.ClearInterrupt()
+	return v, err
+}
+
+// scriptBytes 将脚本返回的字符串、数组或字节数组转换为字节
+func scriptBytes(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case string:
+		return []byte(v), nil
+	case []byte:
+		return v, nil
+	case goja.ArrayBuffer:
+		return v.Bytes(), nil
+	case []interface{}:
+		b := make([]byte, len(v))
+		for i, item := range v {
+			n, ok := scriptNumber(item)
+			if !ok || n < 0 || n > 0xFF {
+				return nil, fmt.Errorf("invalid byte at %d: %v", i, item)
+			}
+			b[i] = byte(n)
+		}
+		return b, nil
+	default:
+		return nil, fmt.Errorf("expected bytes, got %T", value)
+	}
+}
+
+// scriptNumber 将脚本数值 (int64 或 float64) 转换为浮点数
+func scriptNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,608 @@
+// Teltonika 协议适配器
+// 支持 TCP/UDP 上的 Codec 8 / Codec 8 Extended 数据上报和 TCP 上的 Codec 12 GPRS 指令
+
+package adapter
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"openfms/gateway/internal/protocol"
+)
+
+// Teltonika 编解码器 ID
+const (
+	TeltonikaCodec8         byte = 0x08
+	TeltonikaCodec8Extended byte = 0x8E
+	TeltonikaCodec12        byte = 0x0C
+)
+
+// Codec 12 消息类型
+const (
+	teltonikaCommand  byte = 0x05
+	teltonikaResponse byte = 0x06
+)
+
+const (
+	// teltonikaMaxIMEI 握手包中 IMEI 的最大长度
+	teltonikaMaxIMEI = 32
+	// teltonikaMaxFrame 数据包数据区的最大长度
+	teltonikaMaxFrame = 16 * 1024
+	// teltonikaMaxPending 等待回复的 Codec 12 指令数
+	teltonikaMaxPending = 16
+)
+
+// teltonikaIO 常用 IO 元素与 Extras 字段的对应关系，未列出的记为 io<ID>
+var teltonikaIO = map[uint16]struct {
+	Name  string
+	Scale float64 // 非 0 时按比例换算为浮点数
+}{
+	1:   {"din1", 0},
+	2:   {"din2", 0},
+	3:   {"din3", 0},
+	4:   {"din4", 0},
+	9:   {"ain1", 0.001}, // V
+	10:  {"ain2", 0.001},
+	16:  {"mileage", 0.001}, // 总里程 km
+	21:  {"gsm_signal", 0},
+	24:  {"gnss_speed", 0},
+	66:  {"external_voltage", 0.001}, // V
+	67:  {"battery_voltage", 0.001},  // V
+	68:  {"battery_current", 0.001},  // A
+	69:  {"gnss_status", 0},
+	72:  {"temperature1", 0.1}, // °C
+	78:  {"ibutton", 0},
+	113: {"battery_level", 0}, // %
+	179: {"dout1", 0},
+	180: {"dout2", 0},
+	181: {"pdop", 0.1},
+	182: {"hdop", 0.1},
+	199: {"trip_mileage", 0.001}, // km
+	200: {"sleep_mode", 0},
+	239: {"ignition", 0},
+	240: {"movement", 0},
+}
+
+// teltonikaOutgoing 记录等待回复的 Codec 12 指令，终端按发送顺序回复
+type teltonikaOutgoing struct {
+	Command   string
+	CommandID string
+}
+
+// TeltonikaAdapter Teltonika协议适配器，每个连接一个实例
+type TeltonikaAdapter struct {
+	clock DeviceClock
+
+	mu       sync.Mutex
+	deviceID string // 握手包中的IMEI
+	udp      bool   // 会话收到的是 UDP 数据报
+	write    func(packet []byte) error
+	pending  []teltonikaOutgoing
+}
+
+func init() {
+	Register(Registration{
+		Name:   "TELTONIKA",
+		Detect: (&TeltonikaAdapter{}).Match,
+		New: func(opts Options) protocol.ProtocolAdapter {
+			return NewTeltonikaAdapterWithClock(opts.Clock)
+		},
+	})
+}
+
+// NewTeltonikaAdapter 创建Teltonika适配器
+func NewTeltonikaAdapter() *TeltonikaAdapter {
+	return NewTeltonikaAdapterWithClock(UTCDeviceClock())
+}
+
+// NewTeltonikaAdapterWithClock 创建Teltonika适配器，clock 用于时间偏差检查
+func NewTeltonikaAdapterWithClock(clock DeviceClock) *TeltonikaAdapter {
+	return &TeltonikaAdapter{clock: clock}
+}
+
+// SetWriter 实现 protocol.Outbound，用于应答握手和数据包
+func (a *TeltonikaAdapter) SetWriter(write func(packet []byte) error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.write = write
+}
+
+// Match 匹配Teltonika握手包 (IMEI 长度 0x000F 开头) 或 UDP 数据报
+func (a *TeltonikaAdapter) Match(header []byte) bool {
+	if len(header) >= 2 && header[0] == 0x00 && header[1] == 0x0F {
+		return true
+	}
+	return isTeltonikaUDP(header) && binary.BigEndian.Uint16(header[6:8]) == 0x000F
+}
+
+// Decode 解码Teltonika数据包
+func (a *TeltonikaAdapter) Decode(packet []byte) (*protocol.StandardMessage, error) {
+	receivedAt := time.Now().Unix()
+	msg := &protocol.StandardMessage{
+		DeviceID:  a.currentDeviceID(),
+		Timestamp: receivedAt,
+		Extras:    make(map[string]interface{}),
+	}
+	msg.Extras["received_at"] = receivedAt
+
+	if isTeltonikaUDP(packet) {
+		if err := a.decodeUDP(packet, msg); err != nil {
+			return nil, err
+		}
+		return msg, nil
+	}
+
+	// 握手包: IMEI长度(2) + IMEI，应答 0x01 接受
+	if len(packet) >= 2 && binary.BigEndian.Uint16(packet[0:2]) != 0 {
+		imei := packet[2:]
+		if len(imei) != int(binary.BigEndian.Uint16(packet[0:2])) || len(imei) == 0 {
+			return nil, errors.New("invalid Teltonika IMEI handshake")
+		}
+		a.mu.Lock()
+		a.deviceID = string(imei)
+		a.mu.Unlock()
+
+		msg.Type = protocol.MsgTypeAuth
+		msg.DeviceID = string(imei)
+		a.send([]byte{0x01})
+		return msg, nil
+	}
+
+	// 数据包: 前导0(4) + 数据长度(4) + 数据区 + CRC16(4)
+	// 数据区: 编解码器ID(1) + 条数(1) + 数据 + 条数(1)
+	if len(packet) < 12 {
+		return nil, errors.New("packet too short")
+	}
+	dataLen := int(binary.BigEndian.Uint32(packet[4:8]))
+	if len(packet) != 8+dataLen+4 || dataLen < 3 {
+		return nil, errors.New("incomplete packet")
+	}
+	data := packet[8 : 8+dataLen]
+	checksum := binary.BigEndian.Uint32(packet[8+dataLen:])
+	if calc := crc16ARC(data); uint32(calc) != checksum {
+		// 不应答，终端会重发
+		return nil, fmt.Errorf("checksum mismatch: expected %04X, got %04X", calc, checksum)
+	}
+
+	switch codec := data[0]; codec {
+	case TeltonikaCodec8, TeltonikaCodec8Extended:
+		if err := a.decodeRecords(data, codec == TeltonikaCodec8Extended, msg); err != nil {
+			return nil, err
+		}
+		// 应答已接收的记录条数
+		a.send(binary.BigEndian.AppendUint32(nil, uint32(data[1])))
+
+	case TeltonikaCodec12:
+		msg.Type = protocol.MsgTypeCommandResponse
+		if err := a.decodeResponse(data, msg); err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported Teltonika codec 0x%02X", codec)
+	}
+	return msg, nil
+}
+
+// Encode 编码 Codec 12 GPRS 指令，仅支持 TCP 会话
+func (a *TeltonikaAdapter) Encode(cmd protocol.StandardCommand) ([]byte, error) {
+	a.mu.Lock()
+	udp := a.udp
+	a.mu.Unlock()
+	if udp {
+		// 指令帧为 TCP 格式，UDP 终端无法解析
+		return nil, errors.New("Teltonika commands are not supported on UDP sessions")
+	}
+
+	var text string
+	switch cmd.Type {
+	case protocol.CmdCustom:
+		text, _ = cmd.Params["command"].(string)
+		if text == "" {
+			return nil, errors.New("empty custom command")
+		}
+	case protocol.CmdLocationQuery:
+		text = "getgps"
+	case protocol.CmdVehicleControl:
+		// 断油电通过数字输出1控制
+		switch action, _ := cmd.Params["action"].(string); action {
+		case "lock", "cut":
+			text = "setdigout 1"
+		case "unlock", "restore":
+			text = "setdigout 0"
+		default:
+			return nil, fmt.Errorf("invalid vehicle control action: %q", action)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported command: %s", cmd.Type)
+	}
+
+	a.mu.Lock()
+	if len(a.pending) >= teltonikaMaxPending {
+		a.pending = a.pending[1:]
+	}
+	a.pending = append(a.pending, teltonikaOutgoing{Command: cmd.Type, CommandID: cmd.CommandID})
+	a.mu.Unlock()
+
+	// 数据区: 0x0C + 条数(1) + 类型(1) + 指令长度(4) + 指令 + 条数(1)
+	data := []byte{TeltonikaCodec12, 0x01, teltonikaCommand}
+	data = binary.BigEndian.AppendUint32(data, uint32(len(text)))
+	data = append(data, text...)
+	data = append(data, 0x01)
+	return buildTeltonikaPacket(data), nil
+}
+
+// IsHeartbeat Teltonika 无单独心跳包
+func (a *TeltonikaAdapter) IsHeartbeat(packet []byte) bool {
+	return false
+}
+
+// GenerateHeartbeatAck Teltonika 无心跳应答
+func (a *TeltonikaAdapter) GenerateHeartbeatAck(packet []byte) ([]byte, error) {
+	return nil, nil
+}
+
+// Protocol 返回协议标识
+func (a *TeltonikaAdapter) Protocol() string {
+	return "TELTONIKA"
+}
+
+// Scanner 返回Teltonika分包器
+func (a *TeltonikaAdapter) Scanner() protocol.PacketScanner {
+	return TeltonikaScanner{}
+}
+
+// TeltonikaScanner Teltonika分包器
+// 握手包: IMEI长度(2) + IMEI
+// 数据包: 0x00000000 + 数据长度(4) + 数据区 + CRC16(4)
+// UDP 数据报: 长度(2) + 其后内容
+type TeltonikaScanner struct{}
+
+// Scan 提取下一个完整的Teltonika包
+func (TeltonikaScanner) Scan(buffer []byte) ([]byte, []byte, error) {
+	if len(buffer) < 2 {
+		return nil, buffer, nil
+	}
+	if isTeltonikaUDP(buffer) {
+		total := 2 + int(binary.BigEndian.Uint16(buffer[0:2]))
+		if len(buffer) < total {
+			return nil, buffer, nil
+		}
+		return buffer[:total], buffer[total:], nil
+	}
+	if n := int(binary.BigEndian.Uint16(buffer[0:2])); n != 0 {
+		if n > teltonikaMaxIMEI {
+			return nil, buffer[1:], fmt.Errorf("invalid Teltonika IMEI length %d", n)
+		}
+		if len(buffer) < 2+n {
+			return nil, buffer, nil
+		}
+		return buffer[:2+n], buffer[2+n:], nil
+	}
+
+	if len(buffer) < 8 {
+		return nil, buffer, nil
+	}
+	if buffer[2] != 0 || buffer[3] != 0 {
+		// 前导不是 4 个 0，跳过重新同步
+		return nil, buffer[1:], errors.New("invalid Teltonika preamble")
+	}
+	dataLen := int(binary.BigEndian.Uint32(buffer[4:8]))
+	if dataLen > teltonikaMaxFrame {
+		return nil, buffer[1:], fmt.Errorf("Teltonika frame too large: %d bytes", dataLen)
+	}
+	total := 8 + dataLen + 4
+	if len(buffer) < total {
+		return nil, buffer, nil
+	}
+	return buffer[:total], buffer[total:], nil
+}
+
+// 解析方法
+
+// decodeRecords 解析 AVL 数据，一条记录输出 LOCATION，多条输出 BATCH
+func (a *TeltonikaAdapter) decodeRecords(data []byte, extended bool, msg *protocol.StandardMessage) error {
+	r := &teltonikaReader{data: data, pos: 2}
+	count := int(data[1])
+	records := make([]*protocol.StandardMessage, 0, count)
+	for i := 0; i < count; i++ {
+		record := &protocol.StandardMessage{
+			DeviceID:  msg.DeviceID,
+			Type:      protocol.MsgTypeLocation,
+			Timestamp: msg.Timestamp,
+			Extras:    make(map[string]interface{}),
+		}
+		record.Extras["received_at"] = msg.Extras["received_at"]
+		a.decodeRecord(r, extended, record)
+		if r.err != nil {
+			return fmt.Errorf("invalid AVL record %d: %w", i, r.err)
+		}
+		records = append(records, record)
+	}
+	if r.u8() != byte(count) || r.err != nil {
+		return errors.New("AVL record count mismatch")
+	}
+
+	if count == 1 {
+		*msg = *records[0]
+		return nil
+	}
+	msg.Type = protocol.MsgTypeBatch
+	msg.Items = records
+	msg.Extras["batch_count"] = count
+	return nil
+}
+
+// decodeRecord 解析一条 AVL 记录:
+// 时间戳(8, 毫秒) + 优先级(1) + 经度(4) + 纬度(4) + 高度(2) + 方向(2) + 卫星数(1) + 速度(2) + IO元素
+func (a *TeltonikaAdapter) decodeRecord(r *teltonikaReader, extended bool, msg *protocol.StandardMessage) {
+	timestamp := r.u64()
+	priority := r.u8()
+	lon := int32(r.u32())
+	lat := int32(r.u32())
+	altitude := int16(r.u16())
+	angle := r.u16()
+	satellites := r.u8()
+	speed := r.u16()
+	if r.err != nil {
+		return
+	}
+
+	msg.Lon = float64(lon) / 10000000.0
+	msg.Lat = float64(lat) / 10000000.0
+	msg.Speed = float64(speed)
+	msg.Direction = float64(angle)
+	msg.Extras["altitude"] = altitude
+	msg.Extras["satellites"] = satellites
+	msg.Extras["location_valid"] = satellites > 0 && (lat != 0 || lon != 0)
+	msg.Extras["priority"] = priority
+
+	a.decodeIO(r, extended, msg.Extras)
+	if r.err != nil {
+		return
+	}
+	a.clock.Stamp(msg, time.UnixMilli(int64(timestamp)))
+
+	// 优先级 2 为紧急 (panic) 记录
+	if priority == 2 {
+		alarm := &protocol.StandardMessage{
+			DeviceID:  msg.DeviceID,
+			Type:      protocol.MsgTypeAlarm,
+			Timestamp: msg.Timestamp,
+			Lat:       msg.Lat,
+			Lon:       msg.Lon,
+			Speed:     msg.Speed,
+			Direction: msg.Direction,
+			Extras:    make(map[string]interface{}),
+		}
+		alarm.Extras["alarm_type"] = protocol.AlarmSOS
+		alarm.Extras["alarm_edge"] = protocol.AlarmEdgeRising
+		alarm.Extras["received_at"] = msg.Extras["received_at"]
+		msg.Alarms = append(msg.Alarms, alarm)
+	}
+}
+
+// decodeIO 解析 IO 元素
+// Codec 8:  事件IO(1) + 总数(1) + 按 1/2/4/8 字节值分组 [数量(1) + (ID(1) + 值)...]
+// Codec 8E: 事件IO(2) + 总数(2) + 按 1/2/4/8 字节值分组 [数量(2) + (ID(2) + 值)...]，
+// 之后是变长组 [数量(2) + (ID(2) + 长度(2) + 值)...]
+func (a *TeltonikaAdapter) decodeIO(r *teltonikaReader, extended bool, extras map[string]interface{}) {
+	readID := func() uint16 {
+		if extended {
+			return r.u16()
+		}
+		return uint16(r.u8())
+	}
+
+	extras["event_io_id"] = readID()
+	readID() // 总数
+
+	for _, size := range []int{1, 2, 4, 8} {
+		n := int(readID())
+		for i := 0; i < n && r.err == nil; i++ {
+			id := readID()
+			var value uint64
+			for _, b := range r.bytes(size) {
+				value = value<<8 | uint64(b)
+			}
+			setTeltonikaIO(extras, id, value)
+		}
+	}
+
+	if extended {
+		n := int(r.u16())
+		for i := 0; i < n && r.err == nil; i++ {
+			id := r.u16()
+			value := r.bytes(int(r.u16()))
+			extras[teltonikaIOName(id)] = hex.EncodeToString(value)
+		}
+	}
+}
+
+// decodeUDP 解析 UDP 数据报:
+// 长度(2) + 包ID(2) + 0x01 + AVL包ID(1) + IMEI长度(2) + IMEI + 数据区(无CRC)
+// 应答: 0x0005 + 包ID(2) + 0x01 + AVL包ID(1) + 接收条数(1)
+func (a *TeltonikaAdapter) decodeUDP(packet []byte, msg *protocol.StandardMessage) error {
+	if int(binary.BigEndian.Uint16(packet[0:2])) != len(packet)-2 {
+		return errors.New("incomplete Teltonika UDP packet")
+	}
+	r := &teltonikaReader{data: packet, pos: 2}
+	packetID := r.u16()
+	r.u8()
+	avlID := r.u8()
+	imei := r.bytes(int(r.u16()))
+	data := r.bytes(len(packet) - r.pos)
+	if r.err != nil || len(imei) == 0 || len(data) < 3 {
+		return errors.New("invalid Teltonika UDP packet")
+	}
+
+	// UDP 每个数据报都带 IMEI，无握手
+	a.mu.Lock()
+	a.deviceID = string(imei)
+	a.udp = true
+	a.mu.Unlock()
+	msg.DeviceID = string(imei)
+
+	codec := data[0]
+	if codec != TeltonikaCodec8 && codec != TeltonikaCodec8Extended {
+		return fmt.Errorf("unsupported Teltonika UDP codec 0x%02X", codec)
+	}
+	if err := a.decodeRecords(data, codec == TeltonikaCodec8Extended, msg); err != nil {
+		return err
+	}
+
+	ack := binary.BigEndian.AppendUint16([]byte{0x00, 0x05}, packetID)
+	a.send(append(ack, 0x01, avlID, data[1]))
+	return nil
+}
+
+// decodeResponse 解析 Codec 12 指令回复: 0x0C + 条数(1) + 0x06 + 长度(4) + 回复 + 条数(1)
+func (a *TeltonikaAdapter) decodeResponse(data []byte, msg *protocol.StandardMessage) error {
+	r := &teltonikaReader{data: data, pos: 2}
+	msgType := r.u8()
+	text := r.bytes(int(r.u32()))
+	if r.err != nil || msgType != teltonikaResponse {
+		return errors.New("invalid Codec 12 response")
+	}
+	msg.Extras["reply"] = string(text)
+
+	a.mu.Lock()
+	var out teltonikaOutgoing
+	ok := len(a.pending) > 0
+	if ok {
+		out = a.pending[0]
+		a.pending = a.pending[1:]
+	}
+	a.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	msg.Extras["command"] = out.Command
+	if out.CommandID == "" {
+		return nil
+	}
+	msg.Response = &protocol.CommandResponse{
+		CommandID: out.CommandID,
+		DeviceID:  msg.DeviceID,
+		Success:   true,
+		Data: map[string]interface{}{
+			"reply": string(text),
+		},
+	}
+	return nil
+}
+
+// 辅助方法
+
+// buildTeltonikaPacket 组装数据包: 前导0(4) + 数据长度(4) + 数据区 + CRC16(4)
+func buildTeltonikaPacket(data []byte) []byte {
+	packet := make([]byte, 4, 12+len(data))
+	packet = binary.BigEndian.AppendUint32(packet, uint32(len(data)))
+	packet = append(packet, data...)
+	return binary.BigEndian.AppendUint32(packet, uint32(crc16ARC(data)))
+}
+
+// isTeltonikaUDP 判断是否为 UDP 数据报: 长度字段超出 IMEI 长度上限，且第5字节为 0x01
+func isTeltonikaUDP(b []byte) bool {
+	return len(b) >= 8 && int(binary.BigEndian.Uint16(b[0:2])) > teltonikaMaxIMEI && b[4] == 0x01
+}
+
+// setTeltonikaIO 按已知 IO 元素换算并写入 Extras
+func setTeltonikaIO(extras map[string]interface{}, id uint16, value uint64) {
+	io, ok := teltonikaIO[id]
+	switch {
+	case !ok:
+		extras[teltonikaIOName(id)] = value
+	case id == 239: // 点火
+		extras["acc_on"] = value != 0
+		extras[io.Name] = value
+	case id == 78: // iButton
+		extras[io.Name] = fmt.Sprintf("%016X", value)
+	case id == 72: // 温度为有符号数
+		extras[io.Name] = float64(int32(value)) * io.Scale
+	case io.Scale != 0:
+		extras[io.Name] = float64(value) * io.Scale
+	default:
+		extras[io.Name] = value
+	}
+}
+
+func teltonikaIOName(id uint16) string {
+	if io, ok := teltonikaIO[id]; ok {
+		return io.Name
+	}
+	return fmt.Sprintf("io%d", id)
+}
+
+// currentDeviceID 返回握手时上报的IMEI
+func (a *TeltonikaAdapter) currentDeviceID() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.deviceID
+}
+
+// send 通过连接写出应答
+func (a *TeltonikaAdapter) send(packet []byte) {
+	a.mu.Lock()
+	write := a.write
+	a.mu.Unlock()
+	if write == nil {
+		return
+	}
+	if err := write(packet); err != nil {
+		log.Printf("[Teltonika] Failed to send ack: %v", err)
+	}
+}
+
+// teltonikaReader 顺序读取大端数据，越界后记录错误并返回 0
+type teltonikaReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *teltonikaReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.pos+n > len(r.data) {
+		r.err = errors.New("unexpected end of data")
+		return nil
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *teltonikaReader) u8() byte {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *teltonikaReader) u16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *teltonikaReader) u32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *teltonikaReader) u64() uint64 {
+	if b := r.bytes(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
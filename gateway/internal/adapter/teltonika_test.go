@@ -0,0 +1,325 @@
+package adapter
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"testing"
+	"time"
+
+	"openfms/gateway/internal/protocol"
+)
+
+// 协议文档中的报文
+const (
+	teltonikaHandshake = "000F333536333037303432343431303133"
+	teltonikaCodec8    = "000000000000003608010000016B40D8EA30010000000000000000000000000000000105021503010101425E0F01F10000601A014E0000000000000000010000C7CF"
+	teltonikaCodec8E   = "000000000000004A8E010000016B412CEE000100000000000000000000000000000000010005000100010100010011001D00010010015E2C880002000B000000003544C87A000E000000001DD7E06A00000100002994"
+	teltonikaGetinfo   = "000000000000000F0C010500000007676574696E666F0100004312"
+	teltonikaInfoReply = "00000000000000900C010600000088494E493A323031392F372F323220373A3232205254433A323031392F372F323220373A3533205253543A32204552523A312053523A302042523A302043463A302046473A3020464C3A302054553A302F302055543A3020534D533A30204E4F4750533A303A3330204750533A31205341543A302052533A332052463A36352053463A31204D443A30010000C78F"
+	teltonikaUDP       = "003DCAFE0105000F33353230393330383634303336353508010000016B4F815B30010000000000000000000000000000000103021503010101425DBC000001"
+	teltonikaUDPAck    = "0005CAFE010501"
+	// 构造的 Codec 8E 变长 IO 报文: 点火(239)=1，IO 256 为 4 字节 DEADBEEF
+	teltonikaCodec8EVar = "00000000000000348E010000017C3F3A8B40000F0C1C3B209CCA80006400B40A003C00EF0002000100EF01000000000000000101000004DEADBEEF010000B93B"
+)
+
+func teltonikaHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("invalid hex %q: %v", s, err)
+	}
+	return b
+}
+
+// newTeltonikaTestAdapter 返回完成握手的适配器及其写出的报文
+func newTeltonikaTestAdapter(t *testing.T) (*TeltonikaAdapter, *[][]byte) {
+	t.Helper()
+	a := NewTeltonikaAdapter()
+	sent := &[][]byte{}
+	a.SetWriter(func(packet []byte) error {
+		*sent = append(*sent, packet)
+		return nil
+	})
+	msg, err := a.Decode(teltonikaHex(t, teltonikaHandshake))
+	if err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	if msg.Type != protocol.MsgTypeAuth || msg.DeviceID != "356307042441013" {
+		t.Fatalf("handshake = %s from %q", msg.Type, msg.DeviceID)
+	}
+	if len(*sent) != 1 || !bytes.Equal((*sent)[0], []byte{0x01}) {
+		t.Fatalf("handshake ack = %X", *sent)
+	}
+	*sent = nil
+	return a, sent
+}
+
+func TestCRC16ARC(t *testing.T) {
+	tests := []struct {
+		frame string
+		want  uint16
+	}{
+		{teltonikaCodec8, 0xC7CF},
+		{teltonikaCodec8E, 0x2994},
+		{teltonikaGetinfo, 0x4312},
+		{teltonikaInfoReply, 0xC78F},
+	}
+	for _, tt := range tests {
+		packet := teltonikaHex(t, tt.frame)
+		if got := crc16ARC(packet[8 : len(packet)-4]); got != tt.want {
+			t.Errorf("crc16ARC(%s...) = %04X, want %04X", tt.frame[:24], got, tt.want)
+		}
+	}
+	if got := crc16ARC([]byte("123456789")); got != 0xBB3D {
+		t.Errorf("crc16ARC(123456789) = %04X, want BB3D", got)
+	}
+}
+
+func TestTeltonikaDecodeRecords(t *testing.T) {
+	tests := []struct {
+		name      string
+		frame     string
+		timestamp time.Time
+		lat, lon  float64
+		extras    map[string]interface{}
+	}{
+		{
+			name:      "codec 8",
+			frame:     teltonikaCodec8,
+			timestamp: time.UnixMilli(0x16B40D8EA30),
+			extras: map[string]interface{}{
+				"event_io_id":      uint16(1),
+				"gsm_signal":       uint64(3),
+				"din1":             uint64(1),
+				"external_voltage": 24.079,
+				"io241":            uint64(0x601A),
+				"ibutton":          "0000000000000000",
+				"priority":         byte(1),
+			},
+		},
+		{
+			name:      "codec 8E",
+			frame:     teltonikaCodec8E,
+			timestamp: time.UnixMilli(0x16B412CEE00),
+			extras: map[string]interface{}{
+				"event_io_id": uint16(1),
+				"din1":        uint64(1),
+				"io17":        uint64(0x1D),
+				"mileage":     22949.0,
+				"io11":        uint64(0x3544C87A),
+				"io14":        uint64(0x1DD7E06A),
+			},
+		},
+		{
+			name:      "codec 8E variable length IO",
+			frame:     teltonikaCodec8EVar,
+			timestamp: time.UnixMilli(0x17C3F3A8B40),
+			lat:       54.7146368,
+			lon:       25.2451899,
+			extras: map[string]interface{}{
+				"event_io_id": uint16(239),
+				"ignition":    uint64(1),
+				"acc_on":      true,
+				"io256":       "deadbeef",
+				"altitude":    int16(100),
+				"satellites":  byte(10),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, sent := newTeltonikaTestAdapter(t)
+			msg, err := a.Decode(teltonikaHex(t, tt.frame))
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if msg.Type != protocol.MsgTypeLocation || msg.DeviceID != "356307042441013" {
+				t.Errorf("message = %s from %q", msg.Type, msg.DeviceID)
+			}
+			if msg.Timestamp != tt.timestamp.Unix() {
+				t.Errorf("timestamp = %d, want %d", msg.Timestamp, tt.timestamp.Unix())
+			}
+			if math.Abs(msg.Lat-tt.lat) > 1e-7 || math.Abs(msg.Lon-tt.lon) > 1e-7 {
+				t.Errorf("position = %f,%f, want %f,%f", msg.Lat, msg.Lon, tt.lat, tt.lon)
+			}
+			for key, want := range tt.extras {
+				got := msg.Extras[key]
+				if f, ok := want.(float64); ok {
+					if g, ok := got.(float64); !ok || math.Abs(g-f) > 1e-9 {
+						t.Errorf("%s = %v, want %v", key, got, want)
+					}
+					continue
+				}
+				if got != want {
+					t.Errorf("%s = %v (%T), want %v (%T)", key, got, got, want, want)
+				}
+			}
+			// 应答接收的记录条数
+			if len(*sent) != 1 || !bytes.Equal((*sent)[0], []byte{0, 0, 0, 1}) {
+				t.Errorf("ack = %X", *sent)
+			}
+		})
+	}
+}
+
+func TestTeltonikaDecodeErrors(t *testing.T) {
+	badCRC := teltonikaHex(t, teltonikaCodec8)
+	badCRC[len(badCRC)-1] ^= 0xFF
+
+	// 变长 IO 的长度超出数据区
+	overrun := teltonikaHex(t, teltonikaCodec8EVar)
+	data := append([]byte(nil), overrun[8:len(overrun)-4]...)
+	data[len(data)-7] = 0x40
+
+	// 尾部条数与头部不一致
+	codec8 := teltonikaHex(t, teltonikaCodec8)
+	mismatch := append([]byte(nil), codec8[8:len(codec8)-4]...)
+	mismatch[len(mismatch)-1] = 2
+
+	tests := []struct {
+		name   string
+		packet []byte
+	}{
+		{"bad crc", badCRC},
+		{"truncated", codec8[:40]},
+		{"variable length IO overrun", buildTeltonikaPacket(data)},
+		{"record count mismatch", buildTeltonikaPacket(mismatch)},
+		{"unsupported codec", buildTeltonikaPacket([]byte{0x10, 0x00, 0x00})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, sent := newTeltonikaTestAdapter(t)
+			if msg, err := a.Decode(tt.packet); err == nil {
+				t.Fatalf("expected an error, got %+v", msg)
+			}
+			// 出错的数据包不应答，终端会重发
+			if len(*sent) != 0 {
+				t.Errorf("unexpected ack %X", *sent)
+			}
+		})
+	}
+}
+
+func TestTeltonikaScannerSplitReads(t *testing.T) {
+	frames := [][]byte{
+		teltonikaHex(t, teltonikaHandshake),
+		teltonikaHex(t, teltonikaCodec8),
+		teltonikaHex(t, teltonikaCodec8E),
+		teltonikaHex(t, teltonikaCodec8EVar),
+	}
+	stream := bytes.Join(frames, nil)
+
+	for _, chunk := range []int{1, 2, 7, 13, len(stream)} {
+		var packets [][]byte
+		var buffer []byte
+		for offset := 0; offset < len(stream); offset += chunk {
+			end := offset + chunk
+			if end > len(stream) {
+				end = len(stream)
+			}
+			buffer = append(buffer, stream[offset:end]...)
+			for {
+				packet, rest, err := TeltonikaScanner{}.Scan(buffer)
+				if err != nil {
+					t.Fatalf("chunk %d: unexpected error: %v", chunk, err)
+				}
+				buffer = rest
+				if packet == nil {
+					break
+				}
+				packets = append(packets, append([]byte(nil), packet...))
+			}
+		}
+		if len(packets) != len(frames) {
+			t.Fatalf("chunk %d: got %d packets, want %d", chunk, len(packets), len(frames))
+		}
+		for i := range frames {
+			if !bytes.Equal(packets[i], frames[i]) {
+				t.Errorf("chunk %d: packet %d = %X", chunk, i, packets[i])
+			}
+		}
+	}
+}
+
+func TestTeltonikaScannerResync(t *testing.T) {
+	tests := []struct {
+		name   string
+		buffer []byte
+	}{
+		{"bad preamble", teltonikaHex(t, "0000FFFF00000010")},
+		{"oversized frame", teltonikaHex(t, "0000000000FFFFFF")},
+		{"IMEI too long", teltonikaHex(t, "00FF3335")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packet, rest, err := TeltonikaScanner{}.Scan(tt.buffer)
+			if err == nil || packet != nil || len(rest) != len(tt.buffer)-1 {
+				t.Errorf("Scan = %X, %d bytes left, %v; want one byte skipped with an error", packet, len(rest), err)
+			}
+		})
+	}
+}
+
+func TestTeltonikaCodec12(t *testing.T) {
+	a, _ := newTeltonikaTestAdapter(t)
+	packet, err := a.Encode(protocol.StandardCommand{
+		CommandID: "cmd-1",
+		Type:      protocol.CmdCustom,
+		Params:    map[string]interface{}{"command": "getinfo"},
+	})
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if !bytes.Equal(packet, teltonikaHex(t, teltonikaGetinfo)) {
+		t.Errorf("getinfo = %X, want %s", packet, teltonikaGetinfo)
+	}
+
+	msg, err := a.Decode(teltonikaHex(t, teltonikaInfoReply))
+	if err != nil {
+		t.Fatalf("decode reply: %v", err)
+	}
+	if msg.Type != protocol.MsgTypeCommandResponse || msg.Response == nil {
+		t.Fatalf("reply = %s, response %+v", msg.Type, msg.Response)
+	}
+	if msg.Response.CommandID != "cmd-1" || !msg.Response.Success {
+		t.Errorf("response = %+v", msg.Response)
+	}
+	if reply, _ := msg.Extras["reply"].(string); len(reply) != 0x88 || reply[:4] != "INI:" {
+		t.Errorf("reply text = %q", reply)
+	}
+}
+
+func TestTeltonikaUDP(t *testing.T) {
+	a := NewTeltonikaAdapter()
+	var sent []byte
+	a.SetWriter(func(packet []byte) error {
+		sent = packet
+		return nil
+	})
+
+	datagram := teltonikaHex(t, teltonikaUDP)
+	if !a.Match(datagram) {
+		t.Fatal("UDP datagram not matched")
+	}
+	packet, rest, err := TeltonikaScanner{}.Scan(datagram)
+	if err != nil || !bytes.Equal(packet, datagram) || len(rest) != 0 {
+		t.Fatalf("Scan = %X, %X, %v", packet, rest, err)
+	}
+
+	msg, err := a.Decode(datagram)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if msg.Type != protocol.MsgTypeLocation || msg.DeviceID != "352093086403655" {
+		t.Errorf("message = %s from %q", msg.Type, msg.DeviceID)
+	}
+	if !bytes.Equal(sent, teltonikaHex(t, teltonikaUDPAck)) {
+		t.Errorf("ack = %X, want %s", sent, teltonikaUDPAck)
+	}
+
+	// UDP 会话不能下发 TCP 格式的指令
+	if _, err := a.Encode(protocol.StandardCommand{Type: protocol.CmdLocationQuery}); err == nil {
+		t.Error("expected commands to be rejected on UDP sessions")
+	}
+}
@@ -0,0 +1,372 @@
+// TK103 协议适配器
+// Coban/Xexun 等 TK102/TK103 终端的文本协议 (又称 GPS103)，报文以 ; 结尾:
+// 登录 ##,imei:IMEI,A;  心跳 IMEI;  位置/报警 imei:IMEI,关键字,...;
+
+package adapter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"openfms/gateway/internal/protocol"
+)
+
+// tk103MaxFrame 单个报文的最大长度
+const tk103MaxFrame = 1024
+
+// tk103Alarms 位置报文关键字与平台报警类型的对应关系
+var tk103Alarms = map[string]string{
+	"help me":        protocol.AlarmSOS,
+	"low battery":    protocol.AlarmLowBattery,
+	"ac alarm":       protocol.AlarmPowerCut,
+	"move":           protocol.AlarmIllegalMove,
+	"speed":          protocol.AlarmOverspeed,
+	"stockade":       protocol.AlarmAreaInOut,
+	"door alarm":     protocol.AlarmIllegalDoorOpen,
+	"sensor alarm":   protocol.AlarmVibration,
+	"accident alarm": protocol.AlarmCollision,
+}
+
+// TK103Adapter TK103协议适配器，每个连接一个实例
+type TK103Adapter struct {
+	clock DeviceClock
+
+	mu       sync.Mutex
+	deviceID string // 报文中的IMEI，下发指令时使用
+	write    func(packet []byte) error
+}
+
+func init() {
+	Register(Registration{
+		Name:   "TK103",
+		Detect: (&TK103Adapter{}).Match,
+		New: func(opts Options) protocol.ProtocolAdapter {
+			return NewTK103AdapterWithClock(opts.TK103Clock)
+		},
+	})
+}
+
+// NewTK103Adapter 创建TK103适配器，设备时间为 UTC
+func NewTK103Adapter() *TK103Adapter {
+	return NewTK103AdapterWithClock(UTCDeviceClock())
+}
+
+// NewTK103AdapterWithClock 创建TK103适配器并指定设备时区
+func NewTK103AdapterWithClock(clock DeviceClock) *TK103Adapter {
+	return &TK103Adapter{clock: clock}
+}
+
+// SetWriter 实现 protocol.Outbound，用于应答登录
+func (a *TK103Adapter) SetWriter(write func(packet []byte) error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.write = write
+}
+
+// Match 匹配TK103报文: 登录 ##、位置 imei: 或心跳的 IMEI 数字
+func (a *TK103Adapter) Match(header []byte) bool {
+	if len(header) < 2 {
+		return false
+	}
+	return (header[0] == '#' && header[1] == '#') ||
+		(header[0] == 'i' && header[1] == 'm') ||
+		(isDigit(header[0]) && isDigit(header[1]))
+}
+
+// Decode 解码TK103报文
+func (a *TK103Adapter) Decode(packet []byte) (*protocol.StandardMessage, error) {
+	body := strings.TrimSuffix(string(packet), ";")
+	receivedAt := time.Now().Unix()
+	msg := &protocol.StandardMessage{
+		Timestamp: receivedAt,
+		Extras:    make(map[string]interface{}),
+	}
+	msg.Extras["received_at"] = receivedAt
+
+	switch {
+	case strings.HasPrefix(body, "##"):
+		// 登录: ##,imei:IMEI,A，应答 LOAD
+		fields := strings.Split(body, ",")
+		if len(fields) < 2 || !strings.HasPrefix(fields[1], "imei:") {
+			return nil, errors.New("invalid TK103 login")
+		}
+		msg.Type = protocol.MsgTypeAuth
+		msg.DeviceID = a.setDeviceID(strings.TrimPrefix(fields[1], "imei:"))
+		a.reply("LOAD")
+
+	case isTK103Heartbeat(body):
+		msg.Type = protocol.MsgTypeHeartbeat
+		msg.DeviceID = a.setDeviceID(body)
+
+	case strings.HasPrefix(body, "imei:"):
+		fields := strings.Split(body, ",")
+		if len(fields) < 2 {
+			return nil, errors.New("TK103 report too short")
+		}
+		msg.DeviceID = a.setDeviceID(strings.TrimPrefix(fields[0], "imei:"))
+		if err := a.decodeReport(fields[1:], msg); err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown TK103 message %q", body)
+	}
+	return msg, nil
+}
+
+// Encode 编码下发指令: **,imei:IMEI,指令[,参数]
+// TK103 通过 GPRS 没有重启指令，需要时可用 CUSTOM 下发终端支持的指令
+func (a *TK103Adapter) Encode(cmd protocol.StandardCommand) ([]byte, error) {
+	var text string
+	switch cmd.Type {
+	case protocol.CmdCustom:
+		text, _ = cmd.Params["command"].(string)
+		if text == "" {
+			return nil, errors.New("empty custom command")
+		}
+
+	case protocol.CmdLocationQuery:
+		text = "B"
+
+	case protocol.CmdVehicleControl:
+		// 断油电
+		if target, _ := cmd.Params["type"].(string); target != "" && target != "oil" {
+			return nil, fmt.Errorf("invalid vehicle control type: %q", target)
+		}
+		switch action, _ := cmd.Params["action"].(string); action {
+		case "lock", "cut":
+			text = "J"
+		case "unlock", "restore":
+			text = "K"
+		default:
+			return nil, fmt.Errorf("invalid vehicle control action: %q", action)
+		}
+
+	case protocol.CmdTempTracking:
+		// 设置上报间隔，单位为 s/m/h，如 C,30s
+		interval, ok := paramUint(cmd.Params, "interval")
+		if !ok || interval == 0 {
+			return nil, fmt.Errorf("invalid tracking interval: %v", cmd.Params["interval"])
+		}
+		text = "C," + formatTK103Interval(interval)
+
+	case protocol.CmdTerminalControl:
+		command, err := terminalControlCommand(cmd.Params)
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("terminal control %d is not available over GPRS on TK103, use a CUSTOM command", command)
+
+	default:
+		return nil, fmt.Errorf("unsupported command: %s", cmd.Type)
+	}
+
+	deviceID := a.currentDeviceID()
+	if deviceID == "" {
+		return nil, errors.New("device has not reported its IMEI yet")
+	}
+	return []byte(fmt.Sprintf("**,imei:%s,%s", deviceID, text)), nil
+}
+
+// IsHeartbeat 判断是否心跳报文 (仅含 IMEI)
+func (a *TK103Adapter) IsHeartbeat(packet []byte) bool {
+	return isTK103Heartbeat(strings.TrimSuffix(string(packet), ";"))
+}
+
+// GenerateHeartbeatAck 心跳应答 ON
+func (a *TK103Adapter) GenerateHeartbeatAck(packet []byte) ([]byte, error) {
+	return []byte("ON"), nil
+}
+
+// Protocol 返回协议标识
+func (a *TK103Adapter) Protocol() string {
+	return "TK103"
+}
+
+// Scanner 返回TK103分包器
+func (a *TK103Adapter) Scanner() protocol.PacketScanner {
+	return TK103Scanner{}
+}
+
+// TK103Scanner TK103分包器，报文以 ; 结尾，报文间的换行被忽略
+type TK103Scanner struct{}
+
+// Scan 提取下一个完整报文，含结尾的 ;
+func (TK103Scanner) Scan(buffer []byte) ([]byte, []byte, error) {
+	trimmed := bytes.TrimLeft(buffer, "\r\n ")
+	end := bytes.IndexByte(trimmed, ';')
+	if end == -1 {
+		if len(trimmed) > tk103MaxFrame {
+			return nil, trimmed[1:], fmt.Errorf("TK103 frame exceeds %d bytes", tk103MaxFrame)
+		}
+		return nil, trimmed, nil
+	}
+	return trimmed[:end+1], trimmed[end+1:], nil
+}
+
+// 解析方法
+
+// decodeReport 解析位置/报警报文 (IMEI 之后的字段):
+// 关键字,YYMMDDHHMM[SS],电话,F/L,HHMMSS.sss,A/V,纬度,N/S,经度,E/W,速度(节),航向[,海拔,...]
+// 无 GPS 定位 (L) 时经纬度字段为 LAC/CellID (十六进制)
+func (a *TK103Adapter) decodeReport(fields []string, msg *protocol.StandardMessage) error {
+	if len(fields) < 6 {
+		return errors.New("TK103 report too short")
+	}
+	keyword := fields[0]
+	msg.Extras["keyword"] = keyword
+	if t, err := a.parseDateTime(fields[1]); err == nil {
+		a.clock.Stamp(msg, t)
+	}
+	if fields[2] != "" {
+		msg.Extras["phone"] = fields[2]
+	}
+
+	switch keyword {
+	case "acc on":
+		msg.Extras["acc_on"] = true
+	case "acc off":
+		msg.Extras["acc_on"] = false
+	}
+
+	if fields[3] == "L" {
+		msg.Type = protocol.MsgTypeLBS
+		if len(fields) >= 9 {
+			if lac, err := strconv.ParseUint(fields[6], 16, 32); err == nil {
+				msg.Extras["lac"] = lac
+			}
+			if cellID, err := strconv.ParseUint(fields[8], 16, 32); err == nil {
+				msg.Extras["cell_id"] = cellID
+			}
+		}
+	} else {
+		msg.Type = protocol.MsgTypeLocation
+		if len(fields) < 11 {
+			return errors.New("TK103 position too short")
+		}
+		lat, err := parseNMEACoord(fields[6], fields[7])
+		if err != nil {
+			return err
+		}
+		lon, err := parseNMEACoord(fields[8], fields[9])
+		if err != nil {
+			return err
+		}
+		msg.Lat = lat
+		msg.Lon = lon
+		msg.Extras["location_valid"] = fields[5] == "A"
+		if speed, err := strconv.ParseFloat(fields[10], 64); err == nil {
+			msg.Speed = speed * 1.852 // kn -> km/h
+		}
+		if len(fields) >= 12 {
+			if dir, err := strconv.ParseFloat(fields[11], 64); err == nil {
+				msg.Direction = dir
+			}
+		}
+		if len(fields) >= 13 {
+			if altitude, err := strconv.ParseFloat(fields[12], 64); err == nil {
+				msg.Extras["altitude"] = altitude
+			}
+		}
+	}
+
+	// 报警关键字为事件上报，只有触发沿
+	if alarmType, ok := tk103Alarms[keyword]; ok {
+		alarm := &protocol.StandardMessage{
+			DeviceID:  msg.DeviceID,
+			Type:      protocol.MsgTypeAlarm,
+			Timestamp: msg.Timestamp,
+			Lat:       msg.Lat,
+			Lon:       msg.Lon,
+			Speed:     msg.Speed,
+			Direction: msg.Direction,
+			Extras:    make(map[string]interface{}),
+		}
+		alarm.Extras["alarm_type"] = alarmType
+		alarm.Extras["alarm_edge"] = protocol.AlarmEdgeRising
+		alarm.Extras["keyword"] = keyword
+		alarm.Extras["received_at"] = msg.Extras["received_at"]
+		msg.Alarms = append(msg.Alarms, alarm)
+	}
+	return nil
+}
+
+// 辅助方法
+
+// isTK103Heartbeat 判断报文是否只有 IMEI
+func isTK103Heartbeat(body string) bool {
+	if body == "" {
+		return false
+	}
+	for i := 0; i < len(body); i++ {
+		if !isDigit(body[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+// formatTK103Interval 将秒数转为 TK103 间隔格式，优先使用较大的单位
+func formatTK103Interval(seconds uint64) string {
+	switch {
+	case seconds%3600 == 0:
+		return fmt.Sprintf("%02dh", seconds/3600)
+	case seconds%60 == 0:
+		return fmt.Sprintf("%02dm", seconds/60)
+	default:
+		return fmt.Sprintf("%02ds", seconds)
+	}
+}
+
+func (a *TK103Adapter) setDeviceID(deviceID string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.deviceID = deviceID
+	return deviceID
+}
+
+// currentDeviceID 返回报文中上报的IMEI
+func (a *TK103Adapter) currentDeviceID() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.deviceID
+}
+
+// reply 向终端发送应答
+func (a *TK103Adapter) reply(ack string) {
+	a.mu.Lock()
+	write := a.write
+	a.mu.Unlock()
+	if write == nil {
+		return
+	}
+	if err := write([]byte(ack)); err != nil {
+		log.Printf("[TK103] Failed to send %s: %v", ack, err)
+	}
+}
+
+// parseDateTime 解析本地时间字段 YYMMDDHHMM 或 YYMMDDHHMMSS
+func (a *TK103Adapter) parseDateTime(s string) (time.Time, error) {
+	if len(s) != 10 && len(s) != 12 {
+		return time.Time{}, fmt.Errorf("invalid datetime: %s", s)
+	}
+	var f [6]int
+	for i := 0; i*2 < len(s); i++ {
+		n, err := strconv.Atoi(s[i*2 : i*2+2])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid datetime: %s", s)
+		}
+		f[i] = n
+	}
+	return a.clock.Date(2000+f[0], f[1], f[2], f[3], f[4], f[5])
+}
@@ -1,128 +1,179 @@
 // Wialon IPS 协议适配器
-// Wialon IPS 是 Wialon 平台的通用协议
+// Wialon IPS 是 Wialon 平台的通用协议，支持 1.1 和 2.0 (带 CRC16 校验)
 
 package adapter
 
 import (
-	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
+	"log"
+	"math"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"openfms/gateway/internal/protocol"
 )
 
-// WialonAdapter Wialon IPS协议适配器
-type WialonAdapter struct{}
+// Wialon 数据包应答码 (#ASD# / #AD#)
+const (
+	wialonAckStructure   = "-1" // 报文结构错误
+	wialonAckTime        = "0"  // 时间错误
+	wialonAckOK          = "1"  // 成功
+	wialonAckCoordinates = "10" // 坐标错误
+	wialonAckMotion      = "11" // 速度、航向或高度错误
+	wialonAckSatellites  = "12" // 卫星数或 HDOP 错误
+	wialonAckIO          = "13" // 输入输出错误
+	wialonAckADC         = "14" // 模拟量错误
+	wialonAckParams      = "15" // 附加参数错误
+)
+
+// Wialon 数据包字段数
+const (
+	wialonShortFields    = 10 // #SD#: 日期;时间;纬度;N/S;经度;E/W;速度;航向;高度;卫星数
+	wialonExtendedFields = 16 // #D#: 短数据 + HDOP;输入;输出;模拟量;iButton;参数
+)
+
+// wialonMaxImageParts 图片最多分包数
+const wialonMaxImageParts = 256
+
+// WialonAdapter Wialon IPS协议适配器，每个连接一个实例
+type WialonAdapter struct {
+	clock DeviceClock
+
+	mu       sync.Mutex
+	deviceID string // 登录包中的IMEI，其余数据包不携带
+	version  string // 协议版本，"1.1" 或 "2.0"
+	write    func(packet []byte) error
+	image    *wialonImage // 正在接收的图片
+}
+
+// wialonImage 分包接收中的图片
+type wialonImage struct {
+	Name  string
+	Count int
+	Parts map[int][]byte
+}
+
+func init() {
+	Register(Registration{
+		Name:   "WIALON",
+		Detect: (&WialonAdapter{}).Match,
+		New: func(opts Options) protocol.ProtocolAdapter {
+			return NewWialonAdapterWithClock(opts.Clock)
+		},
+	})
+}
 
-// NewWialonAdapter 创建Wialon适配器
+// NewWialonAdapter 创建Wialon适配器，设备时间为 UTC
 func NewWialonAdapter() *WialonAdapter {
-	return &WialonAdapter{}
+	return NewWialonAdapterWithClock(UTCDeviceClock())
 }
 
-// Match 匹配Wialon协议 (文本协议，以 # 开头)
+// NewWialonAdapterWithClock 创建Wialon适配器并指定设备时区
+func NewWialonAdapterWithClock(clock DeviceClock) *WialonAdapter {
+	return &WialonAdapter{clock: clock, version: "1.1"}
+}
+
+// SetWriter 实现 protocol.Outbound，用于发送各类应答
+func (a *WialonAdapter) SetWriter(write func(packet []byte) error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.write = write
+}
+
+// Match 匹配Wialon协议 (文本协议，以 # 开头)，## 为 TK103 登录
 func (a *WialonAdapter) Match(header []byte) bool {
-	return len(header) > 0 && (header[0] == '#' || header[0] == '$')
+	return len(header) >= 2 && ((header[0] == '#' && header[1] != '#') || header[0] == '$')
 }
 
 // Decode 解码Wialon数据包
 func (a *WialonAdapter) Decode(packet []byte) (*protocol.StandardMessage, error) {
-	packetStr := string(packet)
-	packetStr = strings.TrimSpace(packetStr)
+	// 图片包的报文头之后是二进制数据
+	var imageData []byte
+	if bytes.HasPrefix(packet, []byte("#I#")) {
+		if end := bytes.IndexByte(packet, '\n'); end != -1 {
+			packet, imageData = packet[:end], packet[end+1:]
+		}
+	}
+	packetStr := strings.TrimSpace(string(packet))
 
+	receivedAt := time.Now().Unix()
 	msg := &protocol.StandardMessage{
-		Timestamp: time.Now().Unix(),
+		DeviceID:  a.currentDeviceID(),
+		Timestamp: receivedAt,
 		Extras:    make(map[string]interface{}),
 	}
+	msg.Extras["received_at"] = receivedAt
 
-	// 解析消息类型
-	if strings.HasPrefix(packetStr, "#L#") {
-		// 登录消息
-		msg.Type = "AUTH"
-		parts := strings.Split(packetStr[3:], ";")
-		if len(parts) >= 1 {
-			msg.DeviceID = parts[0]
-		}
+	// 报文格式: #类型#内容
+	end := -1
+	if strings.HasPrefix(packetStr, "#") {
+		end = strings.IndexByte(packetStr[1:], '#')
+	}
+	if end == -1 {
+		msg.Type = "UNKNOWN"
 		return msg, nil
 	}
+	packetType, body := packetStr[1:end+1], packetStr[end+2:]
 
-	if strings.HasPrefix(packetStr, "#SD#") || strings.HasPrefix(packetStr, "#D#") {
-		// 短数据或数据消息
-		msg.Type = "LOCATION"
-		prefix := "#SD#"
-		if strings.HasPrefix(packetStr, "#D#") {
-			prefix = "#D#"
-		}
-		
-		parts := strings.Split(packetStr[len(prefix):], ";")
-		if len(parts) >= 6 {
-			msg.DeviceID = parts[0]
-			
-			// 解析日期时间
-			if len(parts) >= 2 {
-				msg.Timestamp = a.parseDateTime(parts[1])
-			}
-			
-			// 解析经纬度
-			if len(parts) >= 5 {
-				lat, _ := strconv.ParseFloat(parts[2], 64)
-				lon, _ := strconv.ParseFloat(parts[4], 64)
-				
-				// Wialon 使用度分格式，需要转换
-				msg.Lat = a.convertCoord(lat)
-				msg.Lon = a.convertCoord(lon)
-			}
-			
-			// 解析速度和方向
-			if len(parts) >= 7 {
-				speed, _ := strconv.ParseFloat(parts[5], 64)
-				msg.Speed = speed
-			}
-			if len(parts) >= 8 {
-				direction, _ := strconv.ParseFloat(parts[6], 64)
-				msg.Direction = direction
-			}
-			
-			// 解析扩展数据
-			if len(parts) >= 9 {
-				extras := parts[8]
-				// 解析 IO 数据，如：IO=ignition:1;fuel:50
-				if strings.HasPrefix(extras, "IO=") {
-					ioData := extras[3:]
-					ioParts := strings.Split(ioData, ",")
-					for _, io := range ioParts {
-						kv := strings.Split(io, ":")
-						if len(kv) == 2 {
-							msg.Extras[kv[0]] = kv[1]
-						}
-					}
-				}
+	switch packetType {
+	case "L": // 登录
+		msg.Type = protocol.MsgTypeAuth
+		return a.decodeLogin(body, msg)
+
+	case "P": // 心跳，由网关通过 GenerateHeartbeatAck 应答
+		msg.Type = protocol.MsgTypeHeartbeat
+		return msg, nil
+
+	case "SD", "D": // 短数据 / 扩展数据
+		msg.Type = protocol.MsgTypeLocation
+		extended := packetType == "D"
+		var code string
+		if data, ok := a.checkCRC(body); !ok {
+			// CRC 错误: #ASD#13 / #AD#16
+			code = "13"
+			if extended {
+				code = "16"
 			}
+		} else {
+			code = a.decodeData(data, extended, msg)
+		}
+		a.reply("#A" + packetType + "#" + code)
+		if code != wialonAckOK {
+			return nil, fmt.Errorf("rejected Wialon #%s# message (code %s)", packetType, code)
 		}
 		return msg, nil
-	}
 
-	if strings.HasPrefix(packetStr, "#P#") {
-		// 心跳包
-		msg.Type = "HEARTBEAT"
-		parts := strings.Split(packetStr[3:], ";")
-		if len(parts) >= 1 {
-			msg.DeviceID = parts[0]
+	case "B": // 黑匣子 (盲区补传)
+		msg.Type = protocol.MsgTypeBatch
+		return a.decodeBlackBox(body, msg)
+
+	case "M": // 司机消息
+		msg.Type = "DRIVER_MESSAGE"
+		text, ok := a.checkCRC(body)
+		switch {
+		case !ok:
+			a.reply("#AM#01")
+			return nil, errors.New("Wialon #M# checksum mismatch")
+		case text == "":
+			a.reply("#AM#0")
+			return nil, errors.New("empty Wialon driver message")
 		}
+		a.reply("#AM#1")
+		msg.Extras["text"] = text
 		return msg, nil
-	}
 
-	if strings.HasPrefix(packetStr, "#B#") {
-		// 批量数据
-		msg.Type = "BATCH"
-		// 批量数据解析逻辑...
+	case "I": // 图片
+		return a.decodeImage(body, imageData, msg)
+
+	default:
+		msg.Type = "UNKNOWN"
+		msg.Extras["packet_type"] = packetType
 		return msg, nil
 	}
-
-	msg.Type = "UNKNOWN"
-	return msg, nil
 }
 
 // Encode 编码Wialon响应
@@ -130,13 +181,26 @@ func (a *WialonAdapter) Encode(cmd protocol.StandardCommand) ([]byte, error) {
 	switch cmd.Type {
 	case "AUTH_ACK":
 		return []byte("#AL#1\r\n"), nil
-	
+
 	case "HEARTBEAT_ACK":
 		return []byte("#AP#\r\n"), nil
-	
+
 	case "DATA_ACK":
 		return []byte("#AD#1\r\n"), nil
-	
+
+	case protocol.CmdTextMessage:
+		// 发送给司机的消息
+		text, _ := cmd.Params["text"].(string)
+		if text == "" {
+			return nil, errors.New("empty text message")
+		}
+		if a.isV2() {
+			// 2.0: #M#消息;CRC16，校验范围含分隔符
+			body := text + ";"
+			return []byte(fmt.Sprintf("#M#%s%04X\r\n", body, crc16ARC([]byte(body)))), nil
+		}
+		return []byte("#M#" + text + "\r\n"), nil
+
 	default:
 		return nil, fmt.Errorf("unsupported command: %s", cmd.Type)
 	}
@@ -148,43 +212,433 @@ func (a *WialonAdapter) IsHeartbeat(packet []byte) bool {
 }
 
 // GenerateHeartbeatAck 生成心跳响应
-func (a *WialonAdapter) GenerateHeartbeatAck(packet []byte) []byte {
-	ack, _ := a.Encode(protocol.StandardCommand{Type: "HEARTBEAT_ACK"})
-	return ack
+func (a *WialonAdapter) GenerateHeartbeatAck(packet []byte) ([]byte, error) {
+	return a.Encode(protocol.StandardCommand{Type: "HEARTBEAT_ACK"})
+}
+
+// Protocol 返回协议标识
+func (a *WialonAdapter) Protocol() string {
+	return "WIALON"
 }
 
-// ReadPacket 从reader读取一个完整包
-func (a *WialonAdapter) ReadPacket(reader *bufio.Reader) ([]byte, error) {
-	// Wialon 是文本协议，以 \r\n 结尾
-	return reader.ReadBytes('\n')
+// Scanner 返回Wialon分包器
+func (a *WialonAdapter) Scanner() protocol.PacketScanner {
+	return WialonScanner{}
+}
+
+// WialonScanner Wialon分包器，文本协议每行一包，以 \r\n 结尾
+// 图片包 #I#sz;... 的报文头之后紧跟 sz 字节二进制数据，与报文头一起作为一包
+type WialonScanner struct{}
+
+// Scan 提取下一行，不含行尾
+func (WialonScanner) Scan(buffer []byte) ([]byte, []byte, error) {
+	end := bytes.IndexByte(buffer, '\n')
+	if end == -1 {
+		return nil, buffer, nil
+	}
+	line := bytes.TrimRight(buffer[:end], "\r")
+	if !bytes.HasPrefix(line, []byte("#I#")) {
+		return line, buffer[end+1:], nil
+	}
+
+	size := line[3:]
+	if i := bytes.IndexByte(size, ';'); i != -1 {
+		size = size[:i]
+	}
+	n, err := strconv.Atoi(string(size))
+	if err != nil || n < 0 {
+		return nil, buffer[end+1:], fmt.Errorf("invalid Wialon image size: %q", size)
+	}
+	total := end + 1 + n
+	if len(buffer) < total {
+		return nil, buffer, nil
+	}
+	return buffer[:total], buffer[total:], nil
+}
+
+// 解析方法
+
+// decodeLogin 解析登录包
+// 1.1: IMEI;密码
+// 2.0: 2.0;IMEI;密码;CRC16
+func (a *WialonAdapter) decodeLogin(body string, msg *protocol.StandardMessage) (*protocol.StandardMessage, error) {
+	if strings.HasPrefix(body, "2.0;") {
+		a.mu.Lock()
+		a.version = "2.0"
+		a.mu.Unlock()
+
+		data, ok := a.checkCRC(body)
+		if !ok {
+			a.reply("#AL#10")
+			return nil, errors.New("Wialon login checksum mismatch")
+		}
+		body = strings.TrimPrefix(data, "2.0;")
+	}
+
+	parts := strings.Split(body, ";")
+	deviceID := strings.TrimSpace(parts[0])
+	if deviceID == "" {
+		a.reply("#AL#0")
+		return nil, errors.New("Wialon login without IMEI")
+	}
+	a.mu.Lock()
+	a.deviceID = deviceID
+	version := a.version
+	a.mu.Unlock()
+
+	msg.DeviceID = deviceID
+	msg.Extras["protocol_version"] = version
+	a.reply("#AL#1")
+	return msg, nil
+}
+
+// decodeData 解析 #SD# / #D# 数据，返回应答码
+func (a *WialonAdapter) decodeData(data string, extended bool, msg *protocol.StandardMessage) string {
+	fields := strings.Split(data, ";")
+	need := wialonShortFields
+	if extended {
+		need = wialonExtendedFields
+	}
+	if len(fields) < need {
+		return wialonAckStructure
+	}
+
+	// 日期时间 (DDMMYY;HHMMSS，UTC)，NA 表示无时间
+	var deviceTime time.Time
+	if fields[0] != "NA" && fields[1] != "NA" {
+		t, err := a.parseDateTime(fields[0], fields[1])
+		if err != nil {
+			return wialonAckTime
+		}
+		deviceTime = t
+	}
+
+	// 经纬度: DDMM.MMMM;N/S;DDDMM.MMMM;E/W
+	if wialonNA(fields[2], fields[3], fields[4], fields[5]) {
+		msg.Extras["location_valid"] = false
+	} else {
+		lat, err1 := strconv.ParseFloat(fields[2], 64)
+		lon, err2 := strconv.ParseFloat(fields[4], 64)
+		if err1 != nil || err2 != nil ||
+			(fields[3] != "N" && fields[3] != "S") || (fields[5] != "E" && fields[5] != "W") {
+			return wialonAckCoordinates
+		}
+		// Wialon 使用度分格式，需要转换
+		msg.Lat = a.convertCoord(lat)
+		msg.Lon = a.convertCoord(lon)
+		if fields[3] == "S" {
+			msg.Lat = -msg.Lat
+		}
+		if fields[5] == "W" {
+			msg.Lon = -msg.Lon
+		}
+		if math.Abs(msg.Lat) > 90 || math.Abs(msg.Lon) > 180 {
+			return wialonAckCoordinates
+		}
+		msg.Extras["location_valid"] = true
+	}
+
+	// 速度 (km/h)、航向、高度 (m)
+	speed, err1 := wialonFloat(fields[6])
+	course, err2 := wialonFloat(fields[7])
+	altitude, err3 := wialonFloat(fields[8])
+	if err1 != nil || err2 != nil || err3 != nil {
+		return wialonAckMotion
+	}
+	msg.Speed = speed
+	msg.Direction = course
+	if fields[8] != "NA" {
+		msg.Extras["altitude"] = altitude
+	}
+
+	if fields[9] != "NA" {
+		satellites, err := strconv.Atoi(fields[9])
+		if err != nil {
+			return wialonAckSatellites
+		}
+		msg.Extras["satellites"] = satellites
+	}
+
+	if extended {
+		if code := a.decodeExtended(fields[10:], msg); code != wialonAckOK {
+			return code
+		}
+	}
+
+	if !deviceTime.IsZero() {
+		a.clock.Stamp(msg, deviceTime)
+	}
+	return wialonAckOK
+}
+
+// decodeExtended 解析 #D# 扩展字段: HDOP;输入;输出;模拟量;iButton;参数
+func (a *WialonAdapter) decodeExtended(fields []string, msg *protocol.StandardMessage) string {
+	if fields[0] != "NA" {
+		hdop, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return wialonAckSatellites
+		}
+		msg.Extras["hdop"] = hdop
+	}
+
+	// 数字输入输出为位掩码
+	for i, name := range []string{"inputs", "outputs"} {
+		if fields[1+i] == "NA" {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1+i], 10, 32)
+		if err != nil {
+			return wialonAckIO
+		}
+		msg.Extras[name] = value
+	}
+
+	// 模拟量: 逗号分隔的浮点数
+	if fields[3] != "" && fields[3] != "NA" {
+		adc := []float64{}
+		for _, s := range strings.Split(fields[3], ",") {
+			value, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return wialonAckADC
+			}
+			adc = append(adc, value)
+		}
+		msg.Extras["adc"] = adc
+	}
+
+	if fields[4] != "" && fields[4] != "NA" {
+		msg.Extras["ibutton"] = fields[4]
+	}
+
+	// 附加参数: 名称:类型:值，类型 1=整数 2=浮点 3=字符串
+	params := strings.Join(fields[5:], ";")
+	if params == "" || params == "NA" {
+		return wialonAckOK
+	}
+	for _, param := range strings.Split(params, ",") {
+		parts := strings.SplitN(param, ":", 3)
+		if len(parts) != 3 || parts[0] == "" {
+			return wialonAckParams
+		}
+		var value interface{}
+		switch parts[1] {
+		case "1":
+			n, err := strconv.ParseInt(parts[2], 10, 64)
+			if err != nil {
+				return wialonAckParams
+			}
+			value = n
+		case "2":
+			f, err := strconv.ParseFloat(parts[2], 64)
+			if err != nil {
+				return wialonAckParams
+			}
+			value = f
+		case "3":
+			value = parts[2]
+		default:
+			return wialonAckParams
+		}
+		// 不覆盖网关自身的字段
+		if _, exists := msg.Extras[parts[0]]; !exists {
+			msg.Extras[parts[0]] = value
+		}
+	}
+	return wialonAckOK
+}
+
+// decodeBlackBox 解析黑匣子数据，各条数据以 | 分隔，格式同 #SD# 或 #D#
+// 2.0 最后一个 | 之后为 CRC16，应答 #AB#已接收条数
+func (a *WialonAdapter) decodeBlackBox(body string, msg *protocol.StandardMessage) (*protocol.StandardMessage, error) {
+	data, ok := a.checkCRC(body)
+	if !ok {
+		a.reply("#AB#")
+		return nil, errors.New("Wialon #B# checksum mismatch")
+	}
+
+	for _, record := range strings.Split(data, "|") {
+		if record == "" {
+			continue
+		}
+		item := &protocol.StandardMessage{
+			DeviceID:  msg.DeviceID,
+			Type:      protocol.MsgTypeLocation,
+			Timestamp: msg.Timestamp,
+			Extras:    make(map[string]interface{}),
+		}
+		item.Extras["received_at"] = msg.Extras["received_at"]
+		item.Extras["blind_area"] = true
+		extended := len(strings.Split(record, ";")) >= wialonExtendedFields
+		if code := a.decodeData(record, extended, item); code != wialonAckOK {
+			continue
+		}
+		msg.Items = append(msg.Items, item)
+	}
+	msg.Extras["batch_count"] = len(msg.Items)
+	a.reply(fmt.Sprintf("#AB#%d", len(msg.Items)))
+	return msg, nil
+}
+
+// decodeImage 解析图片分包: 大小;序号;总包数;日期;时间;文件名[;CRC16]，报文头后为图片数据
+// 每个分包应答 #AI#序号;1，收齐后应答 #AI#1 并输出 MEDIA 消息
+func (a *WialonAdapter) decodeImage(body string, data []byte, msg *protocol.StandardMessage) (*protocol.StandardMessage, error) {
+	fields := strings.Split(body, ";")
+	if len(fields) < 6 {
+		a.reply("#AI#0")
+		return nil, errors.New("invalid Wialon image header")
+	}
+	size, err1 := strconv.Atoi(fields[0])
+	index, err2 := strconv.Atoi(fields[1])
+	count, err3 := strconv.Atoi(fields[2])
+	if err1 != nil || err2 != nil || err3 != nil || size != len(data) ||
+		count < 1 || count > wialonMaxImageParts || index < 0 || index >= count {
+		a.reply(fmt.Sprintf("#AI#%s;0", fields[1]))
+		return nil, errors.New("invalid Wialon image part")
+	}
+	if a.isV2() && len(fields) >= 7 {
+		// 2.0 的 CRC16 针对图片数据
+		crc, err := strconv.ParseUint(fields[6], 16, 16)
+		if err != nil || uint16(crc) != crc16ARC(data) {
+			a.reply(fmt.Sprintf("#AI#%d;0", index))
+			return nil, errors.New("Wialon image checksum mismatch")
+		}
+	}
+	name := fields[5]
+
+	a.mu.Lock()
+	image := a.image
+	if image == nil || image.Name != name || image.Count != count {
+		image = &wialonImage{Name: name, Count: count, Parts: make(map[int][]byte)}
+		a.image = image
+	}
+	image.Parts[index] = append([]byte(nil), data...)
+	complete := len(image.Parts) == image.Count
+	if complete {
+		a.image = nil
+	}
+	a.mu.Unlock()
+
+	a.reply(fmt.Sprintf("#AI#%d;1", index))
+	if !complete {
+		// 等待其余分包
+		return nil, nil
+	}
+
+	var content []byte
+	for i := 0; i < image.Count; i++ {
+		content = append(content, image.Parts[i]...)
+	}
+	a.reply("#AI#1")
+
+	msg.Type = protocol.MsgTypeMedia
+	msg.Extras["media_name"] = name
+	msg.Extras["media_size"] = len(content)
+	msg.Extras["media_data"] = content
+	if fields[3] != "NA" && fields[4] != "NA" {
+		if deviceTime, err := a.parseDateTime(fields[3], fields[4]); err == nil {
+			a.clock.Stamp(msg, deviceTime)
+		}
+	}
+	return msg, nil
 }
 
 // 辅助方法
 
-func (a *WialonAdapter) parseDateTime(dt string) int64 {
+// currentDeviceID 返回连接登录时上报的IMEI
+func (a *WialonAdapter) currentDeviceID() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.deviceID
+}
+
+// isV2 判断连接是否使用 IPS 2.0
+func (a *WialonAdapter) isV2() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.version == "2.0"
+}
+
+// checkCRC 校验 IPS 2.0 报文末尾的 CRC16 (十六进制)，返回去掉校验字段的报文体
+// 校验范围为类型之后到 CRC 前的分隔符 (含)；1.1 无校验，原样返回
+func (a *WialonAdapter) checkCRC(body string) (string, bool) {
+	if !a.isV2() {
+		return body, true
+	}
+	i := strings.LastIndexAny(body, ";|")
+	if i == -1 {
+		return body, false
+	}
+	crc, err := strconv.ParseUint(body[i+1:], 16, 16)
+	if err != nil || uint16(crc) != crc16ARC([]byte(body[:i+1])) {
+		return body, false
+	}
+	return body[:i], true
+}
+
+// crc16ARC 计算 Wialon IPS 2.0 与 Teltonika 使用的 CRC-16 (ARC/IBM, 多项式 0x8005)
+func crc16ARC(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&0x0001 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// reply 向终端发送应答
+func (a *WialonAdapter) reply(ack string) {
+	a.mu.Lock()
+	write := a.write
+	a.mu.Unlock()
+	if write == nil {
+		return
+	}
+	if err := write([]byte(ack + "\r\n")); err != nil {
+		log.Printf("[Wialon] Failed to send %s: %v", ack, err)
+	}
+}
+
+// wialonNA 判断字段是否包含 NA (无数据)
+func wialonNA(fields ...string) bool {
+	for _, f := range fields {
+		if f == "NA" {
+			return true
+		}
+	}
+	return false
+}
+
+// wialonFloat 解析数值字段，NA 视为 0
+func wialonFloat(s string) (float64, error) {
+	if s == "NA" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+func (a *WialonAdapter) parseDateTime(dateStr, timeStr string) (time.Time, error) {
 	// 格式: DDMMYY;HHMMSS
-	parts := strings.Split(dt, ";")
-	if len(parts) != 2 {
-		return time.Now().Unix()
-	}
-	
-	dateStr := parts[0] // DDMMYY
-	timeStr := parts[1] // HHMMSS
-	
 	if len(dateStr) != 6 || len(timeStr) != 6 {
-		return time.Now().Unix()
-	}
-	
-	day, _ := strconv.Atoi(dateStr[0:2])
-	month, _ := strconv.Atoi(dateStr[2:4])
-	year, _ := strconv.Atoi(dateStr[4:6])
-	
-	hour, _ := strconv.Atoi(timeStr[0:2])
-	minute, _ := strconv.Atoi(timeStr[2:4])
-	second, _ := strconv.Atoi(timeStr[4:6])
-	
-	t := time.Date(2000+year, time.Month(month), day, hour, minute, second, 0, time.UTC)
-	return t.Unix()
+		return time.Time{}, fmt.Errorf("invalid datetime: %s;%s", dateStr, timeStr)
+	}
+
+	var f [6]int
+	for i, s := range []string{dateStr, timeStr} {
+		for j := 0; j < 3; j++ {
+			n, err := strconv.Atoi(s[j*2 : j*2+2])
+			if err != nil {
+				return time.Time{}, fmt.Errorf("invalid datetime: %s;%s", dateStr, timeStr)
+			}
+			f[i*3+j] = n
+		}
+	}
+	return a.clock.Date(2000+f[2], f[1], f[0], f[3], f[4], f[5])
 }
 
 func (a *WialonAdapter) convertCoord(coord float64) float64 {
@@ -1,8 +1,11 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Config holds all configuration for the gateway
@@ -12,6 +15,98 @@ type Config struct {
 	HTTPPort    int
 	RedisURL    string
 	NATSURL     string
+
+	// Protocols limits the adapters auto-detected on the gateway port;
+	// empty enables every registered protocol
+	Protocols []string
+
+	// Listeners lists the TCP ports devices connect to as comma separated
+	// PROTOCOL:PORT[:READ_TIMEOUT_SECONDS[:MAX_CONNECTIONS]] entries, where
+	// PROTOCOL "auto" detects the protocol, e.g. "auto:8080,GT06:5023:600".
+	// Empty listens on GatewayPort in auto-detect mode.
+	Listeners string
+	// ReadTimeout closes connections idle for longer, unless the listener
+	// sets its own
+	ReadTimeout time.Duration
+	// MaxConnections caps the concurrent connections of a listener, unless
+	// it sets its own; 0 is unlimited
+	MaxConnections int
+	// WriteQueueSize is how many packets may wait to be written to a device
+	WriteQueueSize int
+	// WriteTimeout bounds a single write to a device; a device that does
+	// not take its packet in time is disconnected
+	WriteTimeout time.Duration
+	// WriteQueuePolicy is what happens to a packet for a device whose write
+	// queue is full: WriteQueueDrop drops it, WriteQueueClose disconnects
+	// the device
+	WriteQueuePolicy string
+	// UDPListeners lists the UDP ports of connectionless devices in the
+	// Listeners format; the read timeout expires idle device sessions and
+	// the connection limit caps the sessions. Empty disables UDP.
+	UDPListeners string
+
+	// TLSListeners lists the TLS ports in the Listeners format
+	TLSListeners string
+	// TLSCertFile and TLSKeyFile are the PEM server certificate and key of
+	// the TLS listeners
+	TLSCertFile string
+	TLSKeyFile  string
+	// TLSClientCAFile requires devices to present a client certificate
+	// signed by these PEM CAs; the certificate CN is the device ID
+	TLSClientCAFile string
+	// TLSReloadInterval is how often the certificate files are checked for
+	// changes; 0 reloads only on SIGHUP
+	TLSReloadInterval time.Duration
+
+	// OsmAndPort serves HTTP location reports of phone apps (OsmAnd,
+	// Traccar Client); 0 disables it
+	OsmAndPort int
+
+	// MQTTBrokerURL is the broker devices publish to, e.g.
+	// "tcp://localhost:1883"; empty disables MQTT ingestion
+	MQTTBrokerURL string
+	MQTTClientID  string
+	MQTTUsername  string
+	MQTTPassword  string
+	// MQTTTopics maps device topics to message types as comma separated
+	// PATTERN=TYPE entries, where the {id} level of PATTERN is the device ID
+	MQTTTopics string
+	// MQTTStatusTopic carries the "online"/"offline" birth and last will
+	// messages of devices
+	MQTTStatusTopic string
+	// MQTTCommandTopic is where commands are published to devices
+	MQTTCommandTopic string
+	// MQTTIdleTimeout ends the session of a device that published nothing
+	// for this long, for devices gone without their last will; 0 keeps
+	// sessions until the device reports offline
+	MQTTIdleTimeout time.Duration
+
+	// ScriptDir holds the JavaScript protocol decoders (*.js), each
+	// registered as a protocol named after its file; empty disables them
+	ScriptDir string
+	// ScriptTimeout bounds every call into a decoder script
+	ScriptTimeout time.Duration
+	// ScriptReloadInterval is how often the script directory is checked for
+	// changes; 0 reloads only on SIGHUP
+	ScriptReloadInterval time.Duration
+
+	// JT808RSAKeyFile is the PEM private key used for JT808 RSA body
+	// encryption; empty generates an ephemeral key at startup
+	JT808RSAKeyFile string
+
+	// JT808Timezone is the clock timezone of JT808 terminals, a fixed
+	// offset ("+08:00") or an IANA name
+	JT808Timezone string
+	// TK103Timezone is the clock timezone of TK103/Coban terminals, which
+	// report local time; the factory setting is GMT+8
+	TK103Timezone string
+	// MaxClockSkew flags positions whose device time differs more than
+	// this from the receive time; 0 disables the check
+	MaxClockSkew time.Duration
+
+	// JT808AuthGrace is how long a JT808 terminal may stay connected without
+	// authenticating with its issued auth code; 0 disables enforcement
+	JT808AuthGrace time.Duration
 }
 
 // Load loads configuration from environment variables
@@ -22,7 +117,209 @@ func Load() *Config {
 		HTTPPort:    getEnvAsInt("HTTP_PORT", 8081),
 		RedisURL:    getEnv("REDIS_URL", "localhost:6379"),
 		NATSURL:     getEnv("NATS_URL", "nats://localhost:4222"),
+
+		Protocols: getEnvAsList("GATEWAY_PROTOCOLS"),
+
+		Listeners:      getEnv("GATEWAY_LISTENERS", ""),
+		ReadTimeout:    time.Duration(getEnvAsInt("READ_TIMEOUT_SECONDS", 300)) * time.Second,
+		MaxConnections: getEnvAsInt("MAX_CONNECTIONS", 0),
+		UDPListeners:   getEnv("GATEWAY_UDP_LISTENERS", ""),
+
+		WriteQueueSize:   getEnvAsInt("WRITE_QUEUE_SIZE", 64),
+		WriteTimeout:     time.Duration(getEnvAsInt("WRITE_TIMEOUT_SECONDS", 10)) * time.Second,
+		WriteQueuePolicy: getEnv("WRITE_QUEUE_POLICY", WriteQueueDrop),
+
+		TLSListeners:      getEnv("GATEWAY_TLS_LISTENERS", ""),
+		TLSCertFile:       getEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:        getEnv("TLS_KEY_FILE", ""),
+		TLSClientCAFile:   getEnv("TLS_CLIENT_CA_FILE", ""),
+		TLSReloadInterval: time.Duration(getEnvAsInt("TLS_RELOAD_SECONDS", 60)) * time.Second,
+
+		OsmAndPort: getEnvAsInt("OSMAND_PORT", 0),
+
+		MQTTBrokerURL:    getEnv("MQTT_BROKER_URL", ""),
+		MQTTClientID:     getEnv("MQTT_CLIENT_ID", ""),
+		MQTTUsername:     getEnv("MQTT_USERNAME", ""),
+		MQTTPassword:     getEnv("MQTT_PASSWORD", ""),
+		MQTTTopics:       getEnv("MQTT_TOPICS", "devices/{id}/location=LOCATION,devices/{id}/alarm=ALARM,devices/{id}/heartbeat=HEARTBEAT,devices/{id}/cmd/response=COMMAND_RESPONSE"),
+		MQTTStatusTopic:  getEnv("MQTT_STATUS_TOPIC", "devices/{id}/status"),
+		MQTTCommandTopic: getEnv("MQTT_COMMAND_TOPIC", "devices/{id}/cmd"),
+		MQTTIdleTimeout:  time.Duration(getEnvAsInt("MQTT_IDLE_TIMEOUT_SECONDS", 300)) * time.Second,
+
+		ScriptDir:            getEnv("SCRIPT_DIR", ""),
+		ScriptTimeout:        time.Duration(getEnvAsInt("SCRIPT_TIMEOUT_MS", 50)) * time.Millisecond,
+		ScriptReloadInterval: time.Duration(getEnvAsInt("SCRIPT_RELOAD_SECONDS", 10)) * time.Second,
+
+		JT808RSAKeyFile: getEnv("JT808_RSA_KEY_FILE", ""),
+
+		JT808Timezone: getEnv("JT808_TIMEZONE", "+08:00"),
+		TK103Timezone: getEnv("TK103_TIMEZONE", "+08:00"),
+		MaxClockSkew:  time.Duration(getEnvAsInt("MAX_CLOCK_SKEW_SECONDS", 300)) * time.Second,
+
+		JT808AuthGrace: time.Duration(getEnvAsInt("JT808_AUTH_GRACE_SECONDS", 60)) * time.Second,
+	}
+}
+
+// Write queue policies
+const (
+	WriteQueueDrop  = "drop"
+	WriteQueueClose = "close"
+)
+
+// AutoDetect is the listener protocol that detects the protocol of each
+// connection from its first bytes
+const AutoDetect = "auto"
+
+// Listener is a TCP or UDP port devices report to
+type Listener struct {
+	Port int
+	// Protocol binds the port to one adapter, skipping detection, or is
+	// AutoDetect
+	Protocol       string
+	ReadTimeout    time.Duration
+	MaxConnections int
+	// TLS terminates TLS on the port
+	TLS bool
+}
+
+// ListenerConfigs parses Listeners, falling back to a single auto-detect
+// listener on GatewayPort, followed by the TLSListeners
+func (c *Config) ListenerConfigs() ([]Listener, error) {
+	listeners := []Listener{{
+		Port:           c.GatewayPort,
+		Protocol:       AutoDetect,
+		ReadTimeout:    c.ReadTimeout,
+		MaxConnections: c.MaxConnections,
+	}}
+	if strings.TrimSpace(c.Listeners) != "" {
+		var err error
+		if listeners, err = c.parseListeners(c.Listeners); err != nil {
+			return nil, err
+		}
+	}
+	if strings.TrimSpace(c.TLSListeners) == "" {
+		return listeners, nil
+	}
+
+	secure, err := c.parseListeners(c.TLSListeners)
+	if err != nil {
+		return nil, err
+	}
+	if c.TLSCertFile == "" || c.TLSKeyFile == "" {
+		return nil, fmt.Errorf("TLS listeners require TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+	for _, l := range secure {
+		for _, other := range listeners {
+			if other.Port == l.Port {
+				return nil, fmt.Errorf("duplicate listener port %d", l.Port)
+			}
+		}
+		l.TLS = true
+		listeners = append(listeners, l)
 	}
+	return listeners, nil
+}
+
+// UDPListenerConfigs parses UDPListeners; none are configured by default
+func (c *Config) UDPListenerConfigs() ([]Listener, error) {
+	if strings.TrimSpace(c.UDPListeners) == "" {
+		return nil, nil
+	}
+	return c.parseListeners(c.UDPListeners)
+}
+
+// parseListeners parses comma separated
+// PROTOCOL:PORT[:READ_TIMEOUT_SECONDS[:MAX_CONNECTIONS]] entries
+func (c *Config) parseListeners(spec string) ([]Listener, error) {
+	var listeners []Listener
+	ports := make(map[int]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		fields := strings.Split(entry, ":")
+		if len(fields) < 2 || len(fields) > 4 || fields[0] == "" {
+			return nil, fmt.Errorf("invalid listener %q", entry)
+		}
+		l := Listener{
+			Protocol:       fields[0],
+			ReadTimeout:    c.ReadTimeout,
+			MaxConnections: c.MaxConnections,
+		}
+		var err error
+		if l.Port, err = strconv.Atoi(fields[1]); err != nil || l.Port <= 0 || l.Port > 65535 {
+			return nil, fmt.Errorf("invalid listener port %q", entry)
+		}
+		if len(fields) > 2 {
+			seconds, err := strconv.Atoi(fields[2])
+			if err != nil || seconds <= 0 {
+				return nil, fmt.Errorf("invalid listener read timeout %q", entry)
+			}
+			l.ReadTimeout = time.Duration(seconds) * time.Second
+		}
+		if len(fields) > 3 {
+			if l.MaxConnections, err = strconv.Atoi(fields[3]); err != nil || l.MaxConnections < 0 {
+				return nil, fmt.Errorf("invalid listener connection limit %q", entry)
+			}
+		}
+		if ports[l.Port] {
+			return nil, fmt.Errorf("duplicate listener port %d", l.Port)
+		}
+		ports[l.Port] = true
+		listeners = append(listeners, l)
+	}
+	if len(listeners) == 0 {
+		return nil, fmt.Errorf("no listener in %q", spec)
+	}
+	return listeners, nil
+}
+
+// MQTTIDLevel is the topic level holding the device ID in MQTT patterns
+const MQTTIDLevel = "{id}"
+
+// MQTTTopic routes the messages of an MQTT topic pattern
+type MQTTTopic struct {
+	Pattern string
+	Type    string
+}
+
+// MQTTTopicConfigs parses MQTTTopics
+func (c *Config) MQTTTopicConfigs() ([]MQTTTopic, error) {
+	var topics []MQTTTopic
+	for _, entry := range strings.Split(c.MQTTTopics, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		pattern, msgType, ok := strings.Cut(entry, "=")
+		if !ok || msgType == "" || !validMQTTPattern(pattern) {
+			return nil, fmt.Errorf("invalid MQTT topic %q", entry)
+		}
+		topics = append(topics, MQTTTopic{Pattern: pattern, Type: strings.ToUpper(msgType)})
+	}
+	if len(topics) == 0 {
+		return nil, fmt.Errorf("no MQTT topic in %q", c.MQTTTopics)
+	}
+	if !validMQTTPattern(c.MQTTStatusTopic) || !validMQTTPattern(c.MQTTCommandTopic) {
+		return nil, fmt.Errorf("MQTT status and command topics need one %s level", MQTTIDLevel)
+	}
+	return topics, nil
+}
+
+// validMQTTPattern reports whether a pattern has exactly one {id} level
+// and no multi-level wildcard
+func validMQTTPattern(pattern string) bool {
+	ids := 0
+	for _, level := range strings.Split(pattern, "/") {
+		switch level {
+		case MQTTIDLevel:
+			ids++
+		case "#":
+			return false
+		}
+	}
+	return ids == 1
 }
 
 func getEnv(key, defaultValue string) string {
@@ -32,6 +329,17 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getEnvAsList splits a comma separated variable, dropping empty entries
+func getEnvAsList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
 func getEnvAsInt(key string, defaultValue int) int {
 	if value := os.Getenv(key); value != "" {
 		if intVal, err := strconv.Atoi(value); err == nil {
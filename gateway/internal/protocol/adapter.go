@@ -3,8 +3,10 @@ package protocol
 // PacketScanner handles packet boundary detection from TCP stream
 type PacketScanner interface {
 	// Scan extracts complete packet from buffer
-	// completePacket: the extracted packet (without escape sequences, checksum)
-	// restBuffer: remaining unprocessed bytes
+	// completePacket: the extracted frame as passed to Decode, nil if the
+	// buffer does not hold a complete one yet
+	// restBuffer: remaining unprocessed bytes, garbage before a frame dropped
+	// err: the buffer started with a malformed frame, restBuffer skips it
 	Scan(buffer []byte) (completePacket []byte, restBuffer []byte, err error)
 }
 
@@ -24,6 +26,9 @@ type ProtocolAdapter interface {
 
 	// Protocol returns protocol identifier
 	Protocol() string
+
+	// Scanner returns the scanner framing this protocol's byte stream
+	Scanner() PacketScanner
 }
 
 // Detector identifies protocol type from initial bytes
@@ -32,3 +37,32 @@ type Detector interface {
 	// Returns adapter and true if matched
 	Match(headerBytes []byte) (ProtocolAdapter, bool)
 }
+
+// Outbound is implemented by adapters that need to send frames to the device
+// on their own, e.g. retransmission requests raised by a reassembly timeout
+type Outbound interface {
+	// SetWriter hands the adapter a function writing raw frames to the device
+	SetWriter(write func(packet []byte) error)
+}
+
+// Closer is implemented by adapters holding resources beyond the connection,
+// e.g. timers; the gateway calls Close once the connection has ended
+type Closer interface {
+	Close()
+}
+
+// AlarmStore keeps the alarm flags of each device across its connections
+type AlarmStore interface {
+	// LoadAlarmFlags returns the flags saved for a device by name; flags
+	// never saved are missing
+	LoadAlarmFlags(deviceID string) map[string]uint32
+	// SaveAlarmFlag records a flag of a device
+	SaveAlarmFlag(deviceID, name string, flag uint32)
+}
+
+// AlarmTracker is implemented by adapters raising alarms on the edges of a
+// device's alarm flags; with a store, the edges carry over reconnects
+type AlarmTracker interface {
+	// SetAlarmStore hands the adapter the store of the last flags per device
+	SetAlarmStore(store AlarmStore)
+}
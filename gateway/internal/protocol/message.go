@@ -10,19 +10,100 @@ type StandardMessage struct {
 	Speed     float64                `json:"speed"`
 	Direction float64                `json:"direction"`
 	Extras    map[string]interface{} `json:"extras"`    // 扩展字段：油量、温度、门开关
+
+	// Response is set when the message answers a command sent by the platform
+	Response *CommandResponse `json:"response,omitempty"`
+
+	// Items holds the individual messages of a MsgTypeBatch message; the
+	// gateway publishes them one by one instead of the batch itself
+	Items []*StandardMessage `json:"items,omitempty"`
+
+	// Alarms holds the ALARM messages raised by this message; the gateway
+	// publishes them after the message itself
+	Alarms []*StandardMessage `json:"-"`
 }
 
 // StandardCommand represents a command to be sent to a device
 type StandardCommand struct {
-	Type   string                 `json:"type"`
-	Params map[string]interface{} `json:"params"`
+	CommandID string                 `json:"command_id,omitempty"` // API command ID, echoed in the response
+	Type      string                 `json:"type"`
+	Params    map[string]interface{} `json:"params"`
+}
+
+// CommandResponse is published on device.<id>.command.response when a device
+// answers a command, matching the API's model.CommandResponse
+type CommandResponse struct {
+	CommandID string                 `json:"command_id"`
+	DeviceID  string                 `json:"device_id"`
+	Success   bool                   `json:"success"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	Error     string                 `json:"error,omitempty"`
+	Offline   bool                   `json:"offline,omitempty"` // not delivered, device not connected
 }
 
 // Message types
 const (
 	MsgTypeAuth      = "AUTH"
+	MsgTypeRegister  = "REGISTER"
 	MsgTypeLocation  = "LOCATION"
 	MsgTypeHeartbeat = "HEARTBEAT"
 	MsgTypeAlarm     = "ALARM"
+	MsgTypeLBS       = "LBS" // cell tower report without a GPS fix
 	MsgTypeMedia     = "MEDIA"
+	MsgTypeBatch     = "BATCH"
+
+	MsgTypeCommandResponse = "COMMAND_RESPONSE"
+)
+
+// Command types, shared with the API's model.Cmd* constants
+const (
+	CmdLocationQuery   = "LOCATION_QUERY"   // 位置查询
+	CmdSetParams       = "SET_PARAMS"       // 设置参数
+	CmdGetParams       = "GET_PARAMS"       // 查询全部参数
+	CmdQueryParams     = "QUERY_PARAMS"     // 查询指定参数
+	CmdTerminalControl = "TERMINAL_CONTROL" // 终端控制
+	CmdTempTracking    = "TEMP_TRACKING"    // 临时位置跟踪
+	CmdVehicleControl  = "VEHICLE_CONTROL"  // 车辆控制
+	CmdTextMessage     = "TEXT_MESSAGE"     // 文本信息下发
+	CmdCustom          = "CUSTOM"           // 自定义文本指令
+)
+
+// Alarm types carried in Extras["alarm_type"] of ALARM messages, shared with
+// the API's model.AlarmType values
+const (
+	AlarmSOS               = "SOS"                // 紧急报警
+	AlarmOverspeed         = "OVERSPEED"          // 超速报警
+	AlarmFatigueDriving    = "FATIGUE_DRIVING"    // 疲劳驾驶
+	AlarmDangerWarning     = "DANGER_WARNING"     // 危险预警
+	AlarmGNSSFault         = "GNSS_FAULT"         // GNSS 模块故障
+	AlarmGNSSAntennaCut    = "GNSS_ANTENNA_CUT"   // GNSS 天线未接或被剪断
+	AlarmGNSSAntennaShort  = "GNSS_ANTENNA_SHORT" // GNSS 天线短路
+	AlarmPowerUndervoltage = "POWER_UNDERVOLTAGE" // 主电源欠压
+	AlarmPowerCut          = "POWER_CUT"          // 主电源掉电
+	AlarmLCDFault          = "LCD_FAULT"          // LCD 故障
+	AlarmTTSFault          = "TTS_FAULT"          // TTS 故障
+	AlarmCameraFault       = "CAMERA_FAULT"       // 摄像头故障
+	AlarmICCardFault       = "IC_CARD_FAULT"      // IC 卡模块故障
+	AlarmOverspeedWarning  = "OVERSPEED_WARNING"  // 超速预警
+	AlarmFatigueWarning    = "FATIGUE_WARNING"    // 疲劳驾驶预警
+	AlarmDrivingTimeout    = "DRIVING_TIMEOUT"    // 当天累计驾驶超时
+	AlarmParkingTimeout    = "PARKING_TIMEOUT"    // 超时停车
+	AlarmAreaInOut         = "AREA_IN_OUT"        // 进出区域
+	AlarmRouteInOut        = "ROUTE_IN_OUT"       // 进出路线
+	AlarmRouteDrivingTime  = "ROUTE_DRIVING_TIME" // 路段行驶时间不足/过长
+	AlarmRouteDeviation    = "ROUTE_DEVIATION"    // 路线偏离
+	AlarmVSSFault          = "VSS_FAULT"          // VSS 故障
+	AlarmFuelAbnormal      = "FUEL_ABNORMAL"      // 油量异常
+	AlarmTheft             = "THEFT"              // 车辆被盗
+	AlarmIllegalIgnition   = "ILLEGAL_IGNITION"   // 非法点火
+	AlarmIllegalMove       = "ILLEGAL_MOVE"       // 非法位移
+	AlarmCollision         = "COLLISION"          // 碰撞
+	AlarmRollover          = "ROLLOVER"           // 侧翻
+	AlarmIllegalDoorOpen   = "ILLEGAL_DOOR_OPEN"  // 非法开门
+	AlarmLowBattery        = "LOW_BATTERY"        // 低电量
+	AlarmVibration         = "VIBRATION"          // 震动
+
+	// Alarm edges in Extras["alarm_edge"]
+	AlarmEdgeRising  = "rising"  // alarm raised
+	AlarmEdgeFalling = "falling" // alarm cleared
 )
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// alarmStore keeps the last alarm flags of each device in the Redis hash
+// fms:alarm:<device>, so alarm edges carry over reconnects and gateway nodes;
+// the API deletes the hash with the device
+type alarmStore struct {
+	s *TCPServer
+}
+
+// LoadAlarmFlags implements protocol.AlarmStore
+func (a alarmStore) LoadAlarmFlags(deviceID string) map[string]uint32 {
+	values, err := a.s.redis.HGetAll(a.s.ctx, alarmKey(deviceID)).Result()
+	if err != nil {
+		log.Printf("[Gateway] Failed to load alarm flags of %s: %v", deviceID, err)
+		return nil
+	}
+	flags := make(map[string]uint32, len(values))
+	for name, value := range values {
+		if flag, err := strconv.ParseUint(value, 10, 32); err == nil {
+			flags[name] = uint32(flag)
+		}
+	}
+	return flags
+}
+
+// SaveAlarmFlag implements protocol.AlarmStore
+func (a alarmStore) SaveAlarmFlag(deviceID, name string, flag uint32) {
+	if err := a.s.redis.HSet(a.s.ctx, alarmKey(deviceID), name, flag).Err(); err != nil {
+		log.Printf("[Gateway] Failed to save alarm flags of %s: %v", deviceID, err)
+	}
+}
+
+func alarmKey(deviceID string) string {
+	return fmt.Sprintf("fms:alarm:%s", deviceID)
+}
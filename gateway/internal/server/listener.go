@@ -0,0 +1,111 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"openfms/gateway/internal/adapter"
+	"openfms/gateway/internal/config"
+	"openfms/gateway/internal/protocol"
+)
+
+// listener is a TCP or TLS port accepting device connections, either
+// dedicated to one protocol or detecting the protocol of each connection
+type listener struct {
+	config   config.Listener
+	ln       net.Listener
+	detector protocol.Detector
+	conns    atomic.Int32 // connections currently open
+}
+
+// newDetector returns the detector of a listener: every enabled protocol in
+// auto-detect mode, or the one it is dedicated to
+func newDetector(cfg config.Listener, opts adapter.Options, protocols []string) (*adapter.Detector, error) {
+	if cfg.Protocol == config.AutoDetect {
+		return adapter.NewDetector(opts, protocols...)
+	}
+	return adapter.NewFixedDetector(opts, cfg.Protocol)
+}
+
+// openListener binds a configured port with the detector serving it;
+// tlsConfig is set for TLS listeners
+func openListener(cfg config.Listener, opts adapter.Options, protocols []string, tlsConfig *tls.Config) (*listener, error) {
+	detector, err := newDetector(cfg, opts, protocols)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := fmt.Sprintf(":%d", cfg.Port)
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	network := "TCP"
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+		network = "TLS"
+	}
+
+	mode := "auto-detect"
+	if cfg.Protocol != config.AutoDetect {
+		mode = "dedicated"
+	}
+	log.Printf("[Gateway] %s server listening on %s (%s: %v, read timeout %s, max connections %d)",
+		network, addr, mode, detector.Protocols(), cfg.ReadTimeout, cfg.MaxConnections)
+	return &listener{config: cfg, ln: ln, detector: detector}, nil
+}
+
+// acquire reserves a connection slot, failing when the listener is full
+func (l *listener) acquire() bool {
+	n := l.conns.Add(1)
+	if l.config.MaxConnections > 0 && n > int32(l.config.MaxConnections) {
+		l.conns.Add(-1)
+		return false
+	}
+	return true
+}
+
+// release frees the slot of a closed connection
+func (l *listener) release() {
+	l.conns.Add(-1)
+}
+
+func (s *TCPServer) acceptLoop(l *listener) {
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			select {
+			case <-s.ctx.Done():
+				return
+			default:
+				log.Printf("[Gateway] Accept error on port %d: %v", l.config.Port, err)
+				continue
+			}
+		}
+
+		if !l.acquire() {
+			log.Printf("[Gateway] Port %d reached %d connections, rejecting %s",
+				l.config.Port, l.config.MaxConnections, conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+
+		session := &Session{
+			ConnID:     fmt.Sprintf("%s-%d", s.config.GatewayID, s.connSeq.Add(1)),
+			Conn:       conn,
+			GatewayID:  s.config.GatewayID,
+			ClientIP:   conn.RemoteAddr().String(),
+			LastActive: time.Now(),
+		}
+		s.startWriter(session)
+
+		go func() {
+			defer l.release()
+			s.handleConnection(l, session)
+		}()
+	}
+}
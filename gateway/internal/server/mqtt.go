@@ -0,0 +1,338 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"openfms/gateway/internal/adapter"
+	"openfms/gateway/internal/config"
+)
+
+const (
+	// mqttQoS is the QoS of subscriptions and commands
+	mqttQoS = 1
+	// mqttPublishTimeout bounds the wait for a command to reach the broker
+	mqttPublishTimeout = 10 * time.Second
+)
+
+// mqttBridge connects the gateway to the MQTT broker of devices that
+// publish JSON instead of speaking a TCP protocol. Every device gets a
+// session whose connection publishes to its command topic, so downlink
+// commands reach it like any TCP device.
+type mqttBridge struct {
+	client  mqtt.Client
+	clock   adapter.DeviceClock
+	routes  []mqttRoute
+	status  mqttPattern
+	command mqttPattern
+
+	mu sync.Mutex // serializes session creation
+}
+
+// mqttRoute maps the messages of a topic pattern to a message type
+type mqttRoute struct {
+	pattern mqttPattern
+	msgType string
+}
+
+// mqttPattern is a topic pattern with a {id} level holding the device ID
+type mqttPattern []string
+
+func parseMQTTPattern(pattern string) mqttPattern {
+	return strings.Split(pattern, "/")
+}
+
+// filter returns the subscription filter of the pattern
+func (p mqttPattern) filter() string {
+	levels := make([]string, len(p))
+	for i, level := range p {
+		if level == config.MQTTIDLevel {
+			level = "+"
+		}
+		levels[i] = level
+	}
+	return strings.Join(levels, "/")
+}
+
+// match returns the device ID of a topic matching the pattern
+func (p mqttPattern) match(topic string) (string, bool) {
+	levels := strings.Split(topic, "/")
+	if len(levels) != len(p) {
+		return "", false
+	}
+	var deviceID string
+	for i, level := range p {
+		switch level {
+		case config.MQTTIDLevel:
+			deviceID = levels[i]
+		case "+":
+		default:
+			if levels[i] != level {
+				return "", false
+			}
+		}
+	}
+	return deviceID, deviceID != ""
+}
+
+// topic returns the topic of a device
+func (p mqttPattern) topic(deviceID string) string {
+	levels := make([]string, len(p))
+	for i, level := range p {
+		if level == config.MQTTIDLevel {
+			level = deviceID
+		}
+		levels[i] = level
+	}
+	return strings.Join(levels, "/")
+}
+
+// startMQTT connects to the MQTT broker; the client keeps reconnecting in
+// the background and subscribes again on every connection
+func (s *TCPServer) startMQTT(clock adapter.DeviceClock) error {
+	topics, err := s.config.MQTTTopicConfigs()
+	if err != nil {
+		return err
+	}
+	b := &mqttBridge{
+		clock:   clock,
+		status:  parseMQTTPattern(s.config.MQTTStatusTopic),
+		command: parseMQTTPattern(s.config.MQTTCommandTopic),
+	}
+	for _, t := range topics {
+		b.routes = append(b.routes, mqttRoute{pattern: parseMQTTPattern(t.Pattern), msgType: t.Type})
+	}
+
+	clientID := s.config.MQTTClientID
+	if clientID == "" {
+		clientID = "openfms-" + s.config.GatewayID
+	}
+	opts := mqtt.NewClientOptions().
+		AddBroker(s.config.MQTTBrokerURL).
+		SetClientID(clientID).
+		SetUsername(s.config.MQTTUsername).
+		SetPassword(s.config.MQTTPassword).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOnConnectHandler(func(c mqtt.Client) {
+			log.Printf("[MQTT] Connected to %s", s.config.MQTTBrokerURL)
+			s.subscribeMQTT(b, c)
+		}).
+		SetConnectionLostHandler(func(c mqtt.Client, err error) {
+			log.Printf("[MQTT] Connection lost: %v", err)
+		})
+	b.client = mqtt.NewClient(opts)
+	b.client.Connect()
+	s.mqtt = b
+	if s.config.MQTTIdleTimeout > 0 {
+		go s.expireMQTTSessions(s.config.MQTTIdleTimeout)
+	}
+	return nil
+}
+
+// expireMQTTSessions closes the sessions of devices that stopped publishing
+// without an offline status, so their commands get queued again
+func (s *TCPServer) expireMQTTSessions(idle time.Duration) {
+	ticker := time.NewTicker(idle / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var expired []*Session
+		s.sessions.Range(func(key, value interface{}) bool {
+			session := value.(*Session)
+			if conn, ok := session.Conn.(*mqttConn); ok && conn.idle() > idle {
+				expired = append(expired, session)
+			}
+			return true
+		})
+
+		for _, session := range expired {
+			log.Printf("[MQTT] Device %s idle for %s, expiring", session.DeviceID, idle)
+			session.Conn.Close()
+		}
+	}
+}
+
+// stopMQTT disconnects from the broker
+func (s *TCPServer) stopMQTT() {
+	if s.mqtt != nil {
+		s.mqtt.client.Disconnect(250)
+	}
+}
+
+func (s *TCPServer) subscribeMQTT(b *mqttBridge, c mqtt.Client) {
+	filters := map[string]byte{b.status.filter(): mqttQoS}
+	for _, route := range b.routes {
+		filters[route.pattern.filter()] = mqttQoS
+	}
+	token := c.SubscribeMultiple(filters, func(_ mqtt.Client, m mqtt.Message) {
+		s.handleMQTTMessage(b, m.Topic(), m.Payload())
+	})
+	if token.Wait() && token.Error() != nil {
+		log.Printf("[MQTT] Failed to subscribe: %v", token.Error())
+	}
+}
+
+// handleMQTTMessage handles a device status change or routes a message
+// to its type
+func (s *TCPServer) handleMQTTMessage(b *mqttBridge, topic string, payload []byte) {
+	if deviceID, ok := b.status.match(topic); ok {
+		s.handleMQTTStatus(b, deviceID, payload)
+		return
+	}
+
+	for _, route := range b.routes {
+		deviceID, ok := route.pattern.match(topic)
+		if !ok {
+			continue
+		}
+		session := s.mqttSession(b, deviceID)
+		if session == nil {
+			return
+		}
+		session.Conn.(*mqttConn).touch()
+		msg, err := session.Adapter.(*adapter.MQTTAdapter).DecodePayload(route.msgType, payload)
+		if err != nil {
+			log.Printf("[MQTT] Decode error on %s: %v", topic, err)
+			return
+		}
+		session.LastActive = time.Now()
+		s.handleMessage(session, payload, msg)
+		s.updateSessionTTL(session)
+		return
+	}
+}
+
+// handleMQTTStatus treats "online" and "offline" status messages, as
+// published on connect and as last will, as the device connecting and
+// disconnecting
+func (s *TCPServer) handleMQTTStatus(b *mqttBridge, deviceID string, payload []byte) {
+	switch strings.ToLower(strings.Trim(strings.TrimSpace(string(payload)), `"`)) {
+	case "online", "1", "true":
+		if session := s.mqttSession(b, deviceID); session != nil {
+			session.Conn.(*mqttConn).touch()
+		}
+	case "offline", "0", "false":
+		if value, ok := s.sessions.Load(deviceID); ok {
+			if session := value.(*Session); isMQTTSession(session) {
+				session.Conn.Close()
+			}
+		}
+	default:
+		log.Printf("[MQTT] Unknown status %q from %s", payload, deviceID)
+	}
+}
+
+// mqttSession returns the session of a device, registering it on its first
+// message; nil if the device is connected to the gateway otherwise
+func (s *TCPServer) mqttSession(b *mqttBridge, deviceID string) *Session {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if value, ok := s.sessions.Load(deviceID); ok {
+		if session := value.(*Session); isMQTTSession(session) {
+			return session
+		}
+		log.Printf("[MQTT] Device %s is connected over another transport, ignoring", deviceID)
+		return nil
+	}
+
+	conn := &mqttConn{client: b.client, topic: b.command.topic(deviceID), lastActive: time.Now()}
+	session := &Session{
+		ConnID:        fmt.Sprintf("%s-%d", s.config.GatewayID, s.connSeq.Add(1)),
+		DeviceID:      deviceID,
+		Conn:          conn,
+		Adapter:       adapter.NewMQTTAdapter(deviceID, b.clock),
+		GatewayID:     s.config.GatewayID,
+		ClientIP:      "mqtt",
+		LastActive:    time.Now(),
+		authenticated: true,
+	}
+	conn.onClose = func() { s.cleanupSession(session) }
+	s.startWriter(session)
+	s.bindSession(session)
+	log.Printf("[MQTT] Device %s online", deviceID)
+	return session
+}
+
+func isMQTTSession(session *Session) bool {
+	_, ok := session.Conn.(*mqttConn)
+	return ok
+}
+
+var errMQTTRead = errors.New("read on MQTT session: messages are received by subscription")
+
+// mqttConn is the net.Conn of an MQTT session: writes are published to the
+// device's command topic and Close ends the session
+type mqttConn struct {
+	client  mqtt.Client
+	topic   string
+	onClose func()
+	once    sync.Once
+
+	mu         sync.Mutex
+	lastActive time.Time
+}
+
+// touch records a message from the device
+func (c *mqttConn) touch() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastActive = time.Now()
+}
+
+// idle returns the time since the device last published
+func (c *mqttConn) idle() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Since(c.lastActive)
+}
+
+func (c *mqttConn) Read(b []byte) (int, error) {
+	return 0, errMQTTRead
+}
+
+func (c *mqttConn) Write(b []byte) (int, error) {
+	token := c.client.Publish(c.topic, mqttQoS, false, b)
+	if !token.WaitTimeout(mqttPublishTimeout) {
+		return 0, fmt.Errorf("publish to %s timed out", c.topic)
+	}
+	if err := token.Error(); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *mqttConn) Close() error {
+	c.once.Do(func() {
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+	return nil
+}
+
+func (c *mqttConn) LocalAddr() net.Addr                { return mqttAddr("gateway") }
+func (c *mqttConn) RemoteAddr() net.Addr               { return mqttAddr(c.topic) }
+func (c *mqttConn) SetDeadline(t time.Time) error      { return nil }
+func (c *mqttConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *mqttConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// mqttAddr names the endpoints of an MQTT session
+type mqttAddr string
+
+func (a mqttAddr) Network() string { return "mqtt" }
+func (a mqttAddr) String() string  { return string(a) }
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"time"
+
+	"openfms/gateway/internal/adapter"
+	"openfms/gateway/internal/protocol"
+)
+
+// maxOsmAndBody bounds the body of an OsmAnd report
+const maxOsmAndBody = 64 * 1024
+
+// startOsmAndServer accepts HTTP location reports of phone apps (OsmAnd,
+// Traccar Client) and publishes them like TCP device messages
+func (s *TCPServer) startOsmAndServer(decoder *adapter.OsmAndDecoder) {
+	addr := fmt.Sprintf(":%d", s.config.OsmAndPort)
+	log.Printf("[Gateway] OsmAnd HTTP server listening on %s", addr)
+
+	server := &http.Server{
+		Addr: addr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.handleOsmAnd(decoder, w, r)
+		}),
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("[Gateway] OsmAnd HTTP server error: %v", err)
+		}
+	}()
+
+	<-s.ctx.Done()
+	server.Shutdown(context.Background())
+}
+
+// handleOsmAnd decodes a report from the query string, a form or a JSON
+// body; only devices known to the device registry are accepted
+func (s *TCPServer) handleOsmAnd(decoder *adapter.OsmAndDecoder, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxOsmAndBody)
+
+	var msg *protocol.StandardMessage
+	var err error
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if r.Method == http.MethodPost && mediaType == "application/json" {
+		var body []byte
+		if body, err = io.ReadAll(r.Body); err == nil {
+			msg, err = decoder.DecodeJSON(body)
+		}
+	} else if err = r.ParseForm(); err == nil {
+		msg, err = decoder.DecodeQuery(r.Form)
+	}
+	if err != nil {
+		log.Printf("[Gateway] OsmAnd decode error from %s: %v", r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	exists, err := s.redis.Exists(r.Context(), deviceKey(msg.DeviceID)).Result()
+	if err != nil {
+		log.Printf("[Gateway] OsmAnd device lookup failed: %v", err)
+		http.Error(w, "Device registry unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if exists == 0 {
+		log.Printf("[Gateway] OsmAnd report from unknown device %s (%s)", msg.DeviceID, r.RemoteAddr)
+		http.Error(w, "Unknown device", http.StatusForbidden)
+		return
+	}
+
+	s.publishMessage(msg)
+	s.publishAlarms(msg)
+	log.Printf("[Gateway] Published %s message from device %s", msg.Type, msg.DeviceID)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"openfms/gateway/internal/adapter"
+	"openfms/gateway/internal/protocol"
+)
+
+// JT808 terminal registration results answered in 0x8100
+const (
+	registerSuccess        = 0
+	registerVehicleExists  = 1 // vehicle already registered
+	registerNoSuchTerminal = 4
+)
+
+// deviceKey is the device registry entry mirrored by the API from the
+// devices table; the gateway adds the issued auth code to it
+func deviceKey(deviceID string) string {
+	return fmt.Sprintf("fms:device:%s", deviceID)
+}
+
+// plateKey binds a plate number to the terminal registered for it
+func plateKey(plate string) string {
+	return fmt.Sprintf("fms:plate:%s", plate)
+}
+
+// requiresAuth reports whether the session must authenticate before its
+// messages are accepted; only JT808 terminals use issued auth codes
+func (s *TCPServer) requiresAuth(session *Session) bool {
+	if s.config.JT808AuthGrace <= 0 {
+		return false
+	}
+	_, ok := session.Adapter.(*adapter.JT808Adapter)
+	return ok
+}
+
+// startAuthTimer closes the connection if the terminal has not authenticated
+// within the grace period
+func (s *TCPServer) startAuthTimer(session *Session) {
+	time.AfterFunc(s.config.JT808AuthGrace, func() {
+		if session.IsAuthenticated() {
+			return
+		}
+		log.Printf("[Gateway] Closing unauthenticated connection %s (%s)", session.ConnID, session.DeviceID)
+		session.Conn.Close()
+	})
+}
+
+// handleRegister answers a JT808 terminal registration (0x0100) with 0x8100,
+// issuing an auth code to terminals known to the device registry
+func (s *TCPServer) handleRegister(session *Session, msg *protocol.StandardMessage) {
+	result, authCode, err := s.registerTerminal(msg)
+	if err != nil {
+		// No answer: the terminal retries the registration
+		log.Printf("[Gateway] Registration of %s failed: %v", msg.DeviceID, err)
+		return
+	}
+
+	s.reply(session, protocol.StandardCommand{
+		Type: "REGISTER_ACK",
+		Params: map[string]interface{}{
+			"serial":    msg.Extras["msg_serial"],
+			"result":    result,
+			"auth_code": authCode,
+		},
+	})
+	log.Printf("[Gateway] Terminal %s registration result: %d", msg.DeviceID, result)
+}
+
+// registerTerminal checks the terminal against the device registry and
+// persists a new auth code on success
+func (s *TCPServer) registerTerminal(msg *protocol.StandardMessage) (int, string, error) {
+	key := deviceKey(msg.DeviceID)
+	exists, err := s.redis.Exists(s.ctx, key).Result()
+	if err != nil {
+		return 0, "", err
+	}
+	if exists == 0 {
+		return registerNoSuchTerminal, "", nil
+	}
+
+	plate, _ := msg.Extras["plate_number"].(string)
+	plate = strings.TrimSpace(plate)
+	if plate != "" {
+		owner, err := s.redis.Get(s.ctx, plateKey(plate)).Result()
+		if err != nil && err != redis.Nil {
+			return 0, "", err
+		}
+		if err == nil && owner != msg.DeviceID {
+			return registerVehicleExists, "", nil
+		}
+	}
+
+	authCode, err := newAuthCode()
+	if err != nil {
+		return 0, "", err
+	}
+	terminalID, _ := msg.Extras["terminal_id"].(string)
+	if err := s.redis.HSet(s.ctx, key,
+		"auth_code", authCode,
+		"terminal_id", terminalID,
+		"plate_number", plate,
+		"registered_at", time.Now().Unix(),
+	).Err(); err != nil {
+		return 0, "", err
+	}
+	if plate != "" {
+		if err := s.redis.Set(s.ctx, plateKey(plate), msg.DeviceID, 0).Err(); err != nil {
+			return 0, "", err
+		}
+	}
+	return registerSuccess, authCode, nil
+}
+
+// handleAuth verifies the auth code of a JT808 terminal authentication
+// (0x0102) against the issued one and answers with 0x8001
+func (s *TCPServer) handleAuth(session *Session, msg *protocol.StandardMessage) {
+	authCode, _ := msg.Extras["auth_code"].(string)
+	issued, err := s.redis.HGet(s.ctx, deviceKey(msg.DeviceID), "auth_code").Result()
+	if err != nil && err != redis.Nil {
+		log.Printf("[Gateway] Authentication of %s failed: %v", msg.DeviceID, err)
+		return
+	}
+
+	result := 0
+	if issued == "" || authCode != issued {
+		result = 1
+	}
+	s.reply(session, protocol.StandardCommand{
+		Type: "GENERAL_ACK",
+		Params: map[string]interface{}{
+			"msg_id": adapter.MsgIDTerminalAuth,
+			"serial": msg.Extras["msg_serial"],
+			"result": result,
+		},
+	})
+
+	if result != 0 {
+		log.Printf("[Gateway] Terminal %s rejected: invalid auth code", msg.DeviceID)
+		return
+	}
+	if !session.IsAuthenticated() {
+		session.SetAuthenticated()
+		s.bindSession(session)
+	}
+	log.Printf("[Gateway] Terminal %s authenticated", msg.DeviceID)
+}
+
+// reply encodes and queues a protocol reply to the device
+func (s *TCPServer) reply(session *Session, cmd protocol.StandardCommand) {
+	data, err := session.Adapter.Encode(cmd)
+	if err != nil {
+		log.Printf("[Gateway] Failed to encode %s: %v", cmd.Type, err)
+		return
+	}
+	if err := session.send(data); err != nil {
+		log.Printf("[Gateway] Failed to send %s to %s: %v", cmd.Type, session.ConnID, err)
+	}
+}
+
+// newAuthCode generates a random auth code
+func newAuthCode() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return strings.ToUpper(hex.EncodeToString(b)), nil
+}
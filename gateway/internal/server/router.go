@@ -0,0 +1,167 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/redis/go-redis/v9"
+)
+
+// commandRouterQueue makes exactly one gateway node handle each API command
+const commandRouterQueue = "gateway-command-router"
+
+// downlinkCommand is the command payload exchanged between the API and the
+// gateway nodes on device.<id>.command and gateway.downlink.<gateway_id>
+type downlinkCommand struct {
+	CommandID string                 `json:"command_id,omitempty"`
+	DeviceID  string                 `json:"device_id"`
+	Type      string                 `json:"type"`
+	Command   string                 `json:"command,omitempty"` // CommandService names the type "command"
+	Params    map[string]interface{} `json:"params,omitempty"`
+}
+
+// normalize fills Type from Command for payloads published by CommandService
+func (c downlinkCommand) normalize() downlinkCommand {
+	if c.Type == "" {
+		c.Type = c.Command
+	}
+	c.Command = ""
+	return c
+}
+
+// routeResult tells what routeCommand did with a command
+type routeResult int
+
+const (
+	routeDelivered routeResult = iota // queued on the local connection
+	routeForwarded                    // published to the owning node
+	routeOffline                      // device not connected, reported offline
+	routeFailed                       // not routed, reported failed
+)
+
+// videoCommand is the JT1078 payload published on device.<id>.command.video
+type videoCommand struct {
+	CommandID string                 `json:"command_id,omitempty"`
+	DeviceID  string                 `json:"device_id"`
+	Channel   int                    `json:"channel"`
+	Command   string                 `json:"command"` // play, stop, pause, resume, speed
+	Params    map[string]interface{} `json:"params,omitempty"`
+}
+
+// startCommandRouter subscribes to the API command subjects and forwards each
+// command to the gateway node holding the device connection, as recorded in
+// the fms:sess:<device> session registry
+func (s *TCPServer) startCommandRouter() {
+	cmdSub, err := s.nats.QueueSubscribe("device.*.command", commandRouterQueue, func(msg *nats.Msg) {
+		var cmd downlinkCommand
+		if err := json.Unmarshal(msg.Data, &cmd); err != nil {
+			log.Printf("[Gateway] Failed to unmarshal routed command: %v", err)
+			return
+		}
+		if cmd.DeviceID == "" {
+			cmd.DeviceID = subjectDeviceID(msg.Subject)
+		}
+		s.routeCommand(cmd.normalize())
+	})
+	if err != nil {
+		log.Printf("[Gateway] Failed to subscribe to device commands: %v", err)
+		return
+	}
+
+	videoSub, err := s.nats.QueueSubscribe("device.*.command.video", commandRouterQueue, func(msg *nats.Msg) {
+		var video videoCommand
+		if err := json.Unmarshal(msg.Data, &video); err != nil {
+			log.Printf("[Gateway] Failed to unmarshal video command: %v", err)
+			return
+		}
+		if video.DeviceID == "" {
+			video.DeviceID = subjectDeviceID(msg.Subject)
+		}
+
+		params := make(map[string]interface{}, len(video.Params)+1)
+		for k, v := range video.Params {
+			params[k] = v
+		}
+		params["channel"] = video.Channel
+		s.routeCommand(downlinkCommand{
+			CommandID: video.CommandID,
+			DeviceID:  video.DeviceID,
+			Type:      "VIDEO_" + strings.ToUpper(video.Command),
+			Params:    params,
+		})
+	})
+	if err != nil {
+		log.Printf("[Gateway] Failed to subscribe to video commands: %v", err)
+		cmdSub.Unsubscribe()
+		return
+	}
+
+	<-s.ctx.Done()
+	cmdSub.Unsubscribe()
+	videoSub.Unsubscribe()
+}
+
+// routeCommand delivers a command locally or forwards it to the owning node.
+// Offline devices are reported back to the caller right away.
+func (s *TCPServer) routeCommand(cmd downlinkCommand) routeResult {
+	if cmd.DeviceID == "" {
+		log.Printf("[Gateway] Dropping command without device ID: %s", cmd.Type)
+		return routeFailed
+	}
+
+	// Fast path: the connection lives on this node
+	if _, ok := s.sessions.Load(cmd.DeviceID); ok {
+		s.deliverCommand(cmd)
+		return routeDelivered
+	}
+
+	owner, err := s.lookupOwner(cmd.DeviceID)
+	if err == redis.Nil {
+		log.Printf("[Gateway] Device offline, command %s not routed: %s", cmd.Type, cmd.DeviceID)
+		s.offlineCommand(cmd.DeviceID, cmd.CommandID)
+		return routeOffline
+	}
+	if err != nil {
+		log.Printf("[Gateway] Failed to resolve gateway for %s: %v", cmd.DeviceID, err)
+		s.failCommand(cmd.DeviceID, cmd.CommandID, "session lookup failed")
+		return routeFailed
+	}
+
+	if owner == s.config.GatewayID {
+		// Registry says us, but the session is gone: stale entry
+		s.offlineCommand(cmd.DeviceID, cmd.CommandID)
+		return routeOffline
+	}
+
+	data, _ := json.Marshal(cmd)
+	subject := fmt.Sprintf("gateway.downlink.%s", owner)
+	if err := s.nats.Publish(subject, data); err != nil {
+		log.Printf("[Gateway] Failed to forward command to %s: %v", owner, err)
+		s.failCommand(cmd.DeviceID, cmd.CommandID, "forward failed")
+		return routeFailed
+	}
+	log.Printf("[Gateway] Command %s for %s forwarded to %s", cmd.Type, cmd.DeviceID, owner)
+	return routeForwarded
+}
+
+// lookupOwner returns the gateway ID holding the device session.
+// Session value format: gateway_id:conn_id:client_ip
+func (s *TCPServer) lookupOwner(deviceID string) (string, error) {
+	value, err := s.redis.Get(s.ctx, fmt.Sprintf("fms:sess:%s", deviceID)).Result()
+	if err != nil {
+		return "", err
+	}
+	return strings.SplitN(value, ":", 2)[0], nil
+}
+
+// subjectDeviceID extracts <id> from device.<id>.command[...]
+func subjectDeviceID(subject string) string {
+	parts := strings.Split(subject, ".")
+	if len(parts) < 3 {
+		return ""
+	}
+	return parts[1]
+}
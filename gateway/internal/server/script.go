@@ -0,0 +1,34 @@
+package server
+
+import (
+	"log"
+	"time"
+)
+
+// ReloadScripts reloads the protocol decoder scripts for new connections
+func (s *TCPServer) ReloadScripts() error {
+	if s.scripts == nil {
+		return nil
+	}
+	return s.scripts.Reload()
+}
+
+// watchScripts reloads the decoder scripts when the script directory changes
+func (s *TCPServer) watchScripts() {
+	ticker := time.NewTicker(s.config.ScriptReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !s.scripts.Changed() {
+			continue
+		}
+		if err := s.ReloadScripts(); err != nil {
+			log.Printf("[Gateway] Failed to reload protocol scripts: %v", err)
+		}
+	}
+}
@@ -3,14 +3,15 @@ package server
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nats-io/nats.go"
@@ -21,15 +22,26 @@ import (
 	"openfms/gateway/internal/protocol"
 )
 
+const (
+	// minDetectBytes is the header length every protocol detector can decide on
+	minDetectBytes = 2
+	// maxPendingBytes bounds the bytes buffered while waiting for a frame
+	maxPendingBytes = 64 * 1024
+)
+
 // TCPServer handles TCP connections from GPS devices
 type TCPServer struct {
 	config    *config.Config
 	redis     *redis.Client
 	nats      *nats.Conn
-	listener  net.Listener
-	adapters  []protocol.ProtocolAdapter
-	detector  *adapter.JT808Detector
-	sessions  sync.Map // map[string]*Session
+	listeners []*listener
+	udp       []*udpListener
+	certs     *certStore         // nil without TLS listeners
+	mqtt      *mqttBridge        // nil without an MQTT broker
+	scripts   *adapter.ScriptSet // nil without a script directory
+	connSeq   atomic.Uint64
+	sessions  sync.Map   // map[string]*Session
+	writes    writeStats // outcomes of the session write queues
 	ctx       context.Context
 	cancel    context.CancelFunc
 }
@@ -40,10 +52,37 @@ type Session struct {
 	DeviceID   string
 	Conn       net.Conn
 	Adapter    protocol.ProtocolAdapter
+	Scanner    protocol.PacketScanner
 	GatewayID  string
 	ClientIP   string
 	LastActive time.Time
 	mu         sync.RWMutex
+	writer     *sessionWriter // serializes writes to Conn
+
+	authenticated bool   // terminal presented a valid auth code or client certificate
+	online        bool   // registered in the session registry
+	certDeviceID  string // device ID of the client certificate, if any
+}
+
+// IsAuthenticated reports whether the terminal has authenticated
+func (sess *Session) IsAuthenticated() bool {
+	sess.mu.RLock()
+	defer sess.mu.RUnlock()
+	return sess.authenticated
+}
+
+// SetAuthenticated marks the terminal as authenticated
+func (sess *Session) SetAuthenticated() {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.authenticated = true
+}
+
+// closeAdapter releases the resources the adapter holds for the connection
+func (sess *Session) closeAdapter() {
+	if closer, ok := sess.Adapter.(protocol.Closer); ok {
+		closer.Close()
+	}
 }
 
 // NewTCPServer creates a new TCP server
@@ -53,7 +92,6 @@ func NewTCPServer(cfg *config.Config, redisClient *redis.Client, natsConn *nats.
 		config:   cfg,
 		redis:    redisClient,
 		nats:     natsConn,
-		detector: adapter.NewJT808Detector(),
 		ctx:      ctx,
 		cancel:   cancel,
 	}
@@ -61,23 +99,114 @@ func NewTCPServer(cfg *config.Config, redisClient *redis.Client, natsConn *nats.
 
 // Start starts the TCP server
 func (s *TCPServer) Start() error {
-	addr := fmt.Sprintf(":%d", s.config.GatewayPort)
-	listener, err := net.Listen("tcp", addr)
+	platformKey, err := adapter.LoadJT808PlatformKey(s.config.JT808RSAKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load JT808 RSA key: %w", err)
+	}
+	if s.config.JT808RSAKeyFile == "" {
+		log.Printf("[Gateway] JT808 RSA: using ephemeral platform key")
+	}
+	jt808Location, err := adapter.ParseTimezone(s.config.JT808Timezone)
+	if err != nil {
+		return fmt.Errorf("invalid JT808 timezone: %w", err)
+	}
+	tk103Location, err := adapter.ParseTimezone(s.config.TK103Timezone)
+	if err != nil {
+		return fmt.Errorf("invalid TK103 timezone: %w", err)
+	}
+	if s.config.WriteQueueSize < 1 {
+		return fmt.Errorf("invalid WRITE_QUEUE_SIZE %d", s.config.WriteQueueSize)
+	}
+	if p := s.config.WriteQueuePolicy; p != config.WriteQueueDrop && p != config.WriteQueueClose {
+		return fmt.Errorf("invalid WRITE_QUEUE_POLICY %q (want %s or %s)", p, config.WriteQueueDrop, config.WriteQueueClose)
+	}
+	if s.config.ScriptDir != "" {
+		if s.scripts, err = adapter.LoadScripts(s.config.ScriptDir, s.config.ScriptTimeout); err != nil {
+			return fmt.Errorf("failed to load protocol scripts: %w", err)
+		}
+	}
+	listenerConfigs, err := s.config.ListenerConfigs()
+	if err != nil {
+		return err
+	}
+	udpConfigs, err := s.config.UDPListenerConfigs()
 	if err != nil {
-		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+		return err
+	}
+	opts := adapter.Options{
+		JT808: adapter.JT808Options{
+			PlatformKey: platformKey,
+			Clock: adapter.DeviceClock{
+				Location: jt808Location,
+				MaxSkew:  s.config.MaxClockSkew,
+			},
+		},
+		Clock:      adapter.DeviceClock{Location: time.UTC, MaxSkew: s.config.MaxClockSkew},
+		TK103Clock: adapter.DeviceClock{Location: tk103Location, MaxSkew: s.config.MaxClockSkew},
+	}
+	for _, cfg := range listenerConfigs {
+		if cfg.TLS && s.certs == nil {
+			if s.certs, err = newCertStore(s.config); err != nil {
+				s.closeListeners()
+				return err
+			}
+		}
+		var tlsConfig *tls.Config
+		if cfg.TLS {
+			tlsConfig = s.certs.serverConfig()
+		}
+		l, err := openListener(cfg, opts, s.config.Protocols, tlsConfig)
+		if err != nil {
+			s.closeListeners()
+			return err
+		}
+		s.listeners = append(s.listeners, l)
+	}
+	for _, cfg := range udpConfigs {
+		l, err := openUDPListener(cfg, opts, s.config.Protocols)
+		if err != nil {
+			s.closeListeners()
+			return err
+		}
+		s.udp = append(s.udp, l)
 	}
-	s.listener = listener
 
-	log.Printf("[Gateway] TCP server listening on %s", addr)
+	// Ingest devices publishing over MQTT
+	if s.config.MQTTBrokerURL != "" {
+		if err := s.startMQTT(opts.Clock); err != nil {
+			s.closeListeners()
+			return err
+		}
+	}
 
 	// Start HTTP server for gateway management
 	go s.startHTTPServer()
 
+	// Accept HTTP location reports of phone apps
+	if s.config.OsmAndPort > 0 {
+		go s.startOsmAndServer(adapter.NewOsmAndDecoder(opts.Clock))
+	}
+
 	// Start downlink consumer
 	go s.startDownlinkConsumer()
 
+	// Route API command subjects to the node owning the connection
+	go s.startCommandRouter()
+
 	// Accept connections
-	go s.acceptLoop()
+	for _, l := range s.listeners {
+		go s.acceptLoop(l)
+	}
+	for _, l := range s.udp {
+		go s.serveUDP(l)
+		go s.expireUDPSessions(l)
+	}
+	if s.certs != nil && s.config.TLSReloadInterval > 0 {
+		go s.watchCertificates()
+	}
+	if s.scripts != nil && s.config.ScriptReloadInterval > 0 {
+		go s.watchScripts()
+	}
 
 	return nil
 }
@@ -85,9 +214,8 @@ func (s *TCPServer) Start() error {
 // Stop stops the TCP server
 func (s *TCPServer) Stop() {
 	s.cancel()
-	if s.listener != nil {
-		s.listener.Close()
-	}
+	s.closeListeners()
+	s.stopMQTT()
 	s.sessions.Range(func(key, value interface{}) bool {
 		if session, ok := value.(*Session); ok {
 			session.Conn.Close()
@@ -96,34 +224,17 @@ func (s *TCPServer) Stop() {
 	})
 }
 
-func (s *TCPServer) acceptLoop() {
-	connID := 0
-	for {
-		conn, err := s.listener.Accept()
-		if err != nil {
-			select {
-			case <-s.ctx.Done():
-				return
-			default:
-				log.Printf("[Gateway] Accept error: %v", err)
-				continue
-			}
-		}
-
-		connID++
-		session := &Session{
-			ConnID:     fmt.Sprintf("%s-%d", s.config.GatewayID, connID),
-			Conn:       conn,
-			GatewayID:  s.config.GatewayID,
-			ClientIP:   conn.RemoteAddr().String(),
-			LastActive: time.Now(),
-		}
-
-		go s.handleConnection(session)
+// closeListeners stops accepting connections and datagrams
+func (s *TCPServer) closeListeners() {
+	for _, l := range s.listeners {
+		l.ln.Close()
+	}
+	for _, l := range s.udp {
+		l.conn.Close()
 	}
 }
 
-func (s *TCPServer) handleConnection(session *Session) {
+func (s *TCPServer) handleConnection(l *listener, session *Session) {
 	defer func() {
 		s.cleanupSession(session)
 		session.Conn.Close()
@@ -131,6 +242,13 @@ func (s *TCPServer) handleConnection(session *Session) {
 
 	log.Printf("[Gateway] New connection: %s from %s", session.ConnID, session.ClientIP)
 
+	if tlsConn, ok := session.Conn.(*tls.Conn); ok {
+		if err := s.handshakeTLS(session, tlsConn); err != nil {
+			log.Printf("[Gateway] %v from %s, closing", err, session.ConnID)
+			return
+		}
+	}
+
 	reader := bufio.NewReader(session.Conn)
 	buffer := make([]byte, 4096)
 	var pending []byte
@@ -142,7 +260,9 @@ func (s *TCPServer) handleConnection(session *Session) {
 		default:
 		}
 
-		session.Conn.SetReadDeadline(time.Now().Add(300 * time.Second))
+		if l.config.ReadTimeout > 0 {
+			session.Conn.SetReadDeadline(time.Now().Add(l.config.ReadTimeout))
+		}
 		n, err := reader.Read(buffer)
 		if err != nil {
 			if err != io.EOF {
@@ -154,103 +274,234 @@ func (s *TCPServer) handleConnection(session *Session) {
 		pending = append(pending, buffer[:n]...)
 		session.LastActive = time.Now()
 
-		// Process packets
+		// Detect the protocol from the first bytes of the connection
+		if session.Adapter == nil {
+			matched, err := s.detectProtocol(session, l.detector, pending)
+			if err != nil {
+				log.Printf("[Gateway] %v from %s, closing", err, session.ConnID)
+				return
+			}
+			if !matched {
+				// Wait for more bytes
+				continue
+			}
+		}
+
+		// Frame the stream with the protocol's scanner
 		for len(pending) > 0 {
-			packet, rest, err := s.extractPacket(pending)
+			packet, rest, err := session.Scanner.Scan(pending)
+			pending = rest
 			if err != nil {
 				log.Printf("[Gateway] Packet extraction error: %v", err)
-				pending = rest
 				continue
 			}
 			if packet == nil {
 				// Incomplete packet, wait for more data
 				break
 			}
-
-			pending = rest
 			s.handlePacket(session, packet)
 		}
+
+		if len(pending) > maxPendingBytes {
+			log.Printf("[Gateway] No complete packet in %d bytes from %s, closing", len(pending), session.ConnID)
+			return
+		}
 	}
 }
 
-func (s *TCPServer) extractPacket(data []byte) ([]byte, []byte, error) {
-	// JT808 packet: starts with 0x7E, ends with 0x7E
-	// Find start marker
-	startIdx := -1
-	for i := 0; i < len(data); i++ {
-		if data[i] == 0x7E {
-			startIdx = i
-			break
+// detectProtocol picks the adapter of a new connection from its first bytes.
+// It returns false while too few bytes have arrived to decide.
+func (s *TCPServer) detectProtocol(session *Session, detector protocol.Detector, header []byte) (bool, error) {
+	adapter, matched := detector.Match(header)
+	if !matched {
+		if len(header) < minDetectBytes {
+			return false, nil
 		}
-	}
-	if startIdx == -1 {
-		// No start marker found, discard all
-		return nil, nil, nil
+		return false, fmt.Errorf("unknown protocol (header % X)", header[:minDetectBytes])
 	}
 
-	// Find end marker
-	endIdx := -1
-	for i := startIdx + 1; i < len(data); i++ {
-		if data[i] == 0x7E {
-			endIdx = i
-			break
-		}
+	session.Adapter = adapter
+	session.Scanner = adapter.Scanner()
+	if outbound, ok := adapter.(protocol.Outbound); ok {
+		outbound.SetWriter(session.send)
 	}
-	if endIdx == -1 {
-		// Incomplete packet
-		return nil, data[startIdx:], nil
+	if tracker, ok := adapter.(protocol.AlarmTracker); ok {
+		tracker.SetAlarmStore(alarmStore{s})
 	}
-
-	packet := data[startIdx : endIdx+1]
-	rest := data[endIdx+1:]
-	return packet, rest, nil
+	log.Printf("[Gateway] Protocol detected: %s for %s", adapter.Protocol(), session.ConnID)
+	if s.requiresAuth(session) {
+		s.startAuthTimer(session)
+	}
+	return true, nil
 }
 
 func (s *TCPServer) handlePacket(session *Session, packet []byte) {
-	// Detect protocol if not set
-	if session.Adapter == nil {
-		adapter, matched := s.detector.Match(packet)
-		if !matched {
-			log.Printf("[Gateway] Unknown protocol from %s", session.ConnID)
-			return
-		}
-		session.Adapter = adapter
-		log.Printf("[Gateway] Protocol detected: %s for %s", adapter.Protocol(), session.ConnID)
-	}
-
 	// Decode packet
 	msg, err := session.Adapter.Decode(packet)
 	if err != nil {
 		log.Printf("[Gateway] Decode error: %v", err)
 		return
 	}
+	s.handleMessage(session, packet, msg)
+}
 
-	// Update session with device ID
-	if msg.DeviceID != "" && session.DeviceID == "" {
+// handleMessage runs the session handshake for a decoded packet and
+// publishes its message; msg is nil for packets that carry none
+func (s *TCPServer) handleMessage(session *Session, packet []byte, msg *protocol.StandardMessage) {
+	// A client certificate binds the connection to its device
+	if msg != nil && session.certDeviceID != "" {
+		if msg.DeviceID == "" {
+			msg.DeviceID = session.certDeviceID
+		} else if msg.DeviceID != session.certDeviceID {
+			log.Printf("[Gateway] %s reported as %s with a certificate for %s, closing",
+				session.ConnID, msg.DeviceID, session.certDeviceID)
+			session.Conn.Close()
+			return
+		}
+	}
+
+	// Update session with device ID; terminals that must authenticate are
+	// registered once their auth code is verified
+	if msg != nil && msg.DeviceID != "" && session.DeviceID == "" {
 		session.DeviceID = msg.DeviceID
-		s.sessions.Store(msg.DeviceID, session)
-		s.registerSession(session)
+		if !s.requiresAuth(session) || session.IsAuthenticated() {
+			s.bindSession(session)
+		}
+	}
+
+	// Registration and authentication handshake
+	if msg != nil {
+		switch {
+		case msg.Type == protocol.MsgTypeRegister:
+			s.handleRegister(session, msg)
+		case msg.Type == protocol.MsgTypeAuth && s.requiresAuth(session):
+			s.handleAuth(session, msg)
+		}
+	}
+
+	// Drop reports of terminals that have not authenticated yet
+	if msg != nil && s.requiresAuth(session) && !session.IsAuthenticated() {
+		switch msg.Type {
+		case protocol.MsgTypeRegister, protocol.MsgTypeAuth, "RSA_KEY":
+		default:
+			log.Printf("[Gateway] Dropping %s from unauthenticated %s", msg.Type, session.ConnID)
+			return
+		}
 	}
 
 	// Handle heartbeat
 	if session.Adapter.IsHeartbeat(packet) {
 		ack, err := session.Adapter.GenerateHeartbeatAck(packet)
 		if err == nil && ack != nil {
-			session.Conn.Write(ack)
+			if err := session.send(ack); err != nil {
+				log.Printf("[Gateway] Failed to send heartbeat ack to %s: %v", session.ConnID, err)
+			}
 		}
 		s.updateSessionTTL(session)
 	}
 
 	// Publish to NATS for processing
 	if msg != nil {
-		msgData, _ := json.Marshal(msg)
-		subject := fmt.Sprintf("fms.uplink.%s", msg.Type)
-		s.nats.Publish(subject, msgData)
-		s.nats.Publish("fms.uplink.all", msgData)
-		log.Printf("[Gateway] Published %s message from device %s", msg.Type, msg.DeviceID)
+		if msg.Type == protocol.MsgTypeBatch {
+			// Batches are expanded so consumers only see regular messages
+			for _, item := range msg.Items {
+				s.publishMessage(item)
+				s.publishAlarms(item)
+			}
+			log.Printf("[Gateway] Published batch of %d messages from device %s", len(msg.Items), msg.DeviceID)
+		} else {
+			s.publishMessage(msg)
+			s.publishAlarms(msg)
+			log.Printf("[Gateway] Published %s message from device %s", msg.Type, msg.DeviceID)
+		}
+
+		// Resolve the API command this message answers
+		if msg.Response != nil {
+			if msg.Response.DeviceID == "" {
+				msg.Response.DeviceID = session.DeviceID
+			}
+			s.publishCommandResponse(msg.Response)
+		}
+	}
+}
+
+// publishMessage publishes an uplink message on fms.uplink.<type> and fms.uplink.all
+func (s *TCPServer) publishMessage(msg *protocol.StandardMessage) {
+	msgData, _ := json.Marshal(msg)
+	subject := fmt.Sprintf("fms.uplink.%s", msg.Type)
+	s.nats.Publish(subject, msgData)
+	s.nats.Publish("fms.uplink.all", msgData)
+}
+
+// publishAlarms publishes the ALARM messages raised by an uplink message
+func (s *TCPServer) publishAlarms(msg *protocol.StandardMessage) {
+	for _, alarm := range msg.Alarms {
+		s.publishMessage(alarm)
+		log.Printf("[Gateway] Alarm %v (%v) from device %s",
+			alarm.Extras["alarm_type"], alarm.Extras["alarm_edge"], alarm.DeviceID)
+	}
+}
+
+// publishCommandResponse reports a command result to the API on
+// device.<id>.command.response
+func (s *TCPServer) publishCommandResponse(resp *protocol.CommandResponse) {
+	if resp.CommandID == "" {
+		return
+	}
+	data, _ := json.Marshal(resp)
+	subject := fmt.Sprintf("device.%s.command.response", resp.DeviceID)
+	if err := s.nats.Publish(subject, data); err != nil {
+		log.Printf("[Gateway] Failed to publish command response: %v", err)
+	}
+}
+
+// failCommand reports a command that could not be delivered
+func (s *TCPServer) failCommand(deviceID, commandID, reason string) {
+	s.publishCommandResponse(&protocol.CommandResponse{
+		CommandID: commandID,
+		DeviceID:  deviceID,
+		Success:   false,
+		Error:     reason,
+	})
+}
+
+// offlineCommand reports a command not delivered because the device is not
+// connected; the API keeps it queued until the device comes back
+func (s *TCPServer) offlineCommand(deviceID, commandID string) {
+	s.publishCommandResponse(&protocol.CommandResponse{
+		CommandID: commandID,
+		DeviceID:  deviceID,
+		Success:   false,
+		Error:     "device offline",
+		Offline:   true,
+	})
+}
+
+// publishSessionEvent announces a device session going online or offline on
+// fms.session.<event>; the API flushes queued commands on "online"
+func (s *TCPServer) publishSessionEvent(event string, session *Session) {
+	data, _ := json.Marshal(map[string]interface{}{
+		"device_id":  session.DeviceID,
+		"gateway_id": session.GatewayID,
+		"conn_id":    session.ConnID,
+		"client_ip":  session.ClientIP,
+		"timestamp":  time.Now().Unix(),
+	})
+	if err := s.nats.Publish("fms.session."+event, data); err != nil {
+		log.Printf("[Gateway] Failed to publish session %s event: %v", event, err)
 	}
 }
 
+// bindSession makes the session reachable for commands on this node and in
+// the session registry
+func (s *TCPServer) bindSession(session *Session) {
+	session.mu.Lock()
+	session.online = true
+	session.mu.Unlock()
+	s.sessions.Store(session.DeviceID, session)
+	s.registerSession(session)
+}
+
 func (s *TCPServer) registerSession(session *Session) {
 	key := fmt.Sprintf("fms:sess:%s", session.DeviceID)
 	value := fmt.Sprintf("%s:%s:%s", session.GatewayID, session.ConnID, session.ClientIP)
@@ -262,6 +513,7 @@ func (s *TCPServer) registerSession(session *Session) {
 	}
 
 	log.Printf("[Gateway] Session registered: %s -> %s", session.DeviceID, value)
+	s.publishSessionEvent("online", session)
 }
 
 func (s *TCPServer) updateSessionTTL(session *Session) {
@@ -280,14 +532,42 @@ func (s *TCPServer) updateSessionTTL(session *Session) {
 
 func (s *TCPServer) cleanupSession(session *Session) {
 	log.Printf("[Gateway] Connection closed: %s", session.ConnID)
+	session.writer.close()
+	session.closeAdapter()
+
+	session.mu.RLock()
+	online := session.online
+	session.mu.RUnlock()
+
+	if session.DeviceID != "" && online {
+		// A reconnected device may already own the entries under its ID
+		s.sessions.CompareAndDelete(session.DeviceID, session)
+		if s.unregisterSession(session) {
+			s.publishSessionEvent("offline", session)
+		}
+	}
+}
 
-	if session.DeviceID != "" {
-		s.sessions.Delete(session.DeviceID)
-
-		// Remove from Redis
-		key := fmt.Sprintf("fms:sess:%s", session.DeviceID)
-		s.redis.Del(s.ctx, key)
+// unregisterScript deletes a session registry entry only while it still
+// names the given connection
+var unregisterScript = redis.NewScript(`
+if string.sub(redis.call("GET", KEYS[1]) or "", 1, string.len(ARGV[1])) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// unregisterSession removes the session registry entry of this connection;
+// it reports false if the device has registered another connection since
+func (s *TCPServer) unregisterSession(session *Session) bool {
+	key := fmt.Sprintf("fms:sess:%s", session.DeviceID)
+	owner := fmt.Sprintf("%s:%s:", session.GatewayID, session.ConnID)
+	deleted, err := unregisterScript.Run(s.ctx, s.redis, []string{key}, owner).Int()
+	if err != nil {
+		log.Printf("[Gateway] Failed to unregister session %s: %v", session.ConnID, err)
+		return false
 	}
+	return deleted > 0
 }
 
 func (s *TCPServer) startHTTPServer() {
@@ -295,6 +575,7 @@ func (s *TCPServer) startHTTPServer() {
 	mux.HandleFunc("/health", s.handleHealth)
 	mux.HandleFunc("/sessions", s.handleSessions)
 	mux.HandleFunc("/send-command", s.handleSendCommand)
+	mux.HandleFunc("/metrics", s.handleMetrics)
 
 	addr := fmt.Sprintf(":%d", s.config.HTTPPort)
 	log.Printf("[Gateway] HTTP server listening on %s", addr)
@@ -333,6 +614,7 @@ func (s *TCPServer) handleSessions(w http.ResponseWriter, r *http.Request) {
 				"client_ip":   session.ClientIP,
 				"protocol":    session.Adapter.Protocol(),
 				"last_active": session.LastActive,
+				"write_queue": session.QueueDepth(),
 			})
 		}
 		return true
@@ -349,9 +631,10 @@ func (s *TCPServer) handleSendCommand(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		DeviceID string                 `json:"device_id"`
-		Type     string                 `json:"type"`
-		Params   map[string]interface{} `json:"params"`
+		CommandID string                 `json:"command_id"`
+		DeviceID  string                 `json:"device_id"`
+		Type      string                 `json:"type"`
+		Params    map[string]interface{} `json:"params"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -359,10 +642,39 @@ func (s *TCPServer) handleSendCommand(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Find session
+	// Find session; devices connected to another node get the command
+	// forwarded, offline devices get it queued by the API
 	value, ok := s.sessions.Load(req.DeviceID)
 	if !ok {
-		http.Error(w, "Device not connected", http.StatusNotFound)
+		cmd := downlinkCommand{
+			CommandID: req.CommandID,
+			DeviceID:  req.DeviceID,
+			Type:      req.Type,
+			Params:    req.Params,
+		}
+		status := "forwarded"
+		switch s.routeCommand(cmd) {
+		case routeDelivered:
+			// Connected since the lookup above
+			status = "sent"
+		case routeOffline:
+			// Commands the API sent are queued on the offline response;
+			// others are queued under the caller's command ID
+			data, _ := json.Marshal(cmd)
+			if err := s.nats.Publish("fms.command.queue", data); err != nil {
+				http.Error(w, "Device not connected", http.StatusNotFound)
+				return
+			}
+			status = "queued"
+		case routeFailed:
+			http.Error(w, "Failed to route command", http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{
+			"status": status,
+		})
 		return
 	}
 
@@ -373,8 +685,9 @@ func (s *TCPServer) handleSendCommand(w http.ResponseWriter, r *http.Request) {
 	}
 
 	cmd := protocol.StandardCommand{
-		Type:   req.Type,
-		Params: req.Params,
+		CommandID: req.CommandID,
+		Type:      req.Type,
+		Params:    req.Params,
 	}
 
 	data, err := session.Adapter.Encode(cmd)
@@ -383,9 +696,20 @@ func (s *TCPServer) handleSendCommand(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = session.Conn.Write(data)
+	// Wait for the writer so the caller learns whether the device took it
+	result := make(chan error, 1)
+	session.sendAsync(data, func(err error) { result <- err })
+	select {
+	case err = <-result:
+	case <-r.Context().Done():
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		status := http.StatusInternalServerError
+		if err == errQueueFull {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), status)
 		return
 	}
 
@@ -398,52 +722,58 @@ func (s *TCPServer) handleSendCommand(w http.ResponseWriter, r *http.Request) {
 func (s *TCPServer) startDownlinkConsumer() {
 	subject := fmt.Sprintf("gateway.downlink.%s", s.config.GatewayID)
 	sub, err := s.nats.Subscribe(subject, func(msg *nats.Msg) {
-		var cmd struct {
-			DeviceID string                 `json:"device_id"`
-			Type     string                 `json:"type"`
-			Params   map[string]interface{} `json:"params"`
-		}
-
+		var cmd downlinkCommand
 		if err := json.Unmarshal(msg.Data, &cmd); err != nil {
 			log.Printf("[Gateway] Failed to unmarshal command: %v", err)
 			return
 		}
+		s.deliverCommand(cmd.normalize())
+	})
 
-		value, ok := s.sessions.Load(cmd.DeviceID)
-		if !ok {
-			log.Printf("[Gateway] Device not connected: %s", cmd.DeviceID)
-			return
-		}
+	if err != nil {
+		log.Printf("[Gateway] Failed to subscribe to downlink: %v", err)
+		return
+	}
 
-		session := value.(*Session)
-		if session.Adapter == nil {
-			log.Printf("[Gateway] Protocol not determined for: %s", cmd.DeviceID)
-			return
-		}
+	<-s.ctx.Done()
+	sub.Unsubscribe()
+}
 
-		data, err := session.Adapter.Encode(protocol.StandardCommand{
-			Type:   cmd.Type,
-			Params: cmd.Params,
-		})
-		if err != nil {
-			log.Printf("[Gateway] Failed to encode command: %v", err)
-			return
-		}
+// deliverCommand encodes a command and queues it on the local device connection
+func (s *TCPServer) deliverCommand(cmd downlinkCommand) {
+	value, ok := s.sessions.Load(cmd.DeviceID)
+	if !ok {
+		log.Printf("[Gateway] Device not connected: %s", cmd.DeviceID)
+		s.offlineCommand(cmd.DeviceID, cmd.CommandID)
+		return
+	}
 
-		_, err = session.Conn.Write(data)
-		if err != nil {
-			log.Printf("[Gateway] Failed to send command: %v", err)
-			return
-		}
+	session := value.(*Session)
+	if session.Adapter == nil {
+		log.Printf("[Gateway] Protocol not determined for: %s", cmd.DeviceID)
+		s.failCommand(cmd.DeviceID, cmd.CommandID, "protocol not determined")
+		return
+	}
 
-		log.Printf("[Gateway] Command sent to %s: %s", cmd.DeviceID, cmd.Type)
+	data, err := session.Adapter.Encode(protocol.StandardCommand{
+		CommandID: cmd.CommandID,
+		Type:      cmd.Type,
+		Params:    cmd.Params,
 	})
-
 	if err != nil {
-		log.Printf("[Gateway] Failed to subscribe to downlink: %v", err)
+		log.Printf("[Gateway] Failed to encode command: %v", err)
+		s.failCommand(cmd.DeviceID, cmd.CommandID, err.Error())
 		return
 	}
 
-	<-s.ctx.Done()
-	sub.Unsubscribe()
+	// The writer reports the result so a slow device does not hold up the
+	// downlink subscription
+	session.sendAsync(data, func(err error) {
+		if err != nil {
+			log.Printf("[Gateway] Failed to send command: %v", err)
+			s.failCommand(cmd.DeviceID, cmd.CommandID, err.Error())
+			return
+		}
+		log.Printf("[Gateway] Command sent to %s: %s", cmd.DeviceID, cmd.Type)
+	})
 }
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"openfms/gateway/internal/config"
+)
+
+// tlsHandshakeTimeout bounds the TLS handshake of a new connection
+const tlsHandshakeTimeout = 30 * time.Second
+
+// certStore holds the TLS configuration of the TLS listeners. Reloading
+// swaps it for new handshakes; established connections keep theirs.
+type certStore struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	current atomic.Pointer[tls.Config]
+	mu      sync.Mutex // serializes reloads
+	modTime time.Time  // latest modification of the loaded files
+}
+
+// newCertStore loads the configured certificate, key and client CAs
+func newCertStore(cfg *config.Config) (*certStore, error) {
+	c := &certStore{
+		certFile: cfg.TLSCertFile,
+		keyFile:  cfg.TLSKeyFile,
+		caFile:   cfg.TLSClientCAFile,
+	}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// serverConfig is the configuration of the TLS listeners, resolving the
+// current certificates on every handshake
+func (c *certStore) serverConfig() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return c.current.Load(), nil
+		},
+	}
+}
+
+// reload reads the certificate files and swaps the configuration
+func (c *certStore) reload() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	modTime, err := c.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if c.caFile != "" {
+		pem, err := os.ReadFile(c.caFile)
+		if err != nil {
+			return fmt.Errorf("failed to read TLS client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate in TLS client CA %s", c.caFile)
+		}
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	c.current.Store(conf)
+	c.modTime = modTime
+	return nil
+}
+
+// changed reports whether a certificate file was modified since the last load
+func (c *certStore) changed() bool {
+	modTime, err := c.latestModTime()
+	if err != nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return modTime.After(c.modTime)
+}
+
+func (c *certStore) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{c.certFile, c.keyFile, c.caFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// ReloadCertificates reloads the TLS certificates for new connections
+func (s *TCPServer) ReloadCertificates() error {
+	if s.certs == nil {
+		return nil
+	}
+	if err := s.certs.reload(); err != nil {
+		return err
+	}
+	log.Printf("[Gateway] TLS certificates reloaded")
+	return nil
+}
+
+// watchCertificates reloads the TLS certificates when their files change
+func (s *TCPServer) watchCertificates() {
+	ticker := time.NewTicker(s.config.TLSReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !s.certs.changed() {
+			continue
+		}
+		if err := s.ReloadCertificates(); err != nil {
+			log.Printf("[Gateway] Failed to reload TLS certificates: %v", err)
+		}
+	}
+}
+
+// handshakeTLS completes the TLS handshake of a connection and takes the
+// device ID from the CN of a verified client certificate
+func (s *TCPServer) handshakeTLS(session *Session, conn *tls.Conn) error {
+	conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+	if err := conn.HandshakeContext(s.ctx); err != nil {
+		return fmt.Errorf("TLS handshake failed: %w", err)
+	}
+
+	state := conn.ConnectionState()
+	if len(state.VerifiedChains) == 0 {
+		return nil
+	}
+	cn := state.PeerCertificates[0].Subject.CommonName
+	if cn == "" {
+		return errors.New("client certificate without CN")
+	}
+	session.mu.Lock()
+	session.certDeviceID = cn
+	session.authenticated = true
+	session.mu.Unlock()
+	log.Printf("[Gateway] %s presented a client certificate for %s", session.ConnID, cn)
+	return nil
+}
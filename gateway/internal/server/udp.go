@@ -0,0 +1,310 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"openfms/gateway/internal/adapter"
+	"openfms/gateway/internal/config"
+	"openfms/gateway/internal/protocol"
+)
+
+const (
+	// udpIdleTimeout expires sessions of UDP listeners without a read timeout
+	udpIdleTimeout = 5 * time.Minute
+	// maxDatagramSize is the largest UDP payload
+	maxDatagramSize = 64 * 1024
+)
+
+var errUDPRead = errors.New("read on UDP session: datagrams are received by the listener")
+
+// udpListener is a UDP port of connectionless devices. Each device gets a
+// pseudo-session that replies to the address it last reported from, so
+// acks and commands reach it while its NAT mapping is alive.
+type udpListener struct {
+	config   config.Listener
+	conn     *net.UDPConn
+	detector protocol.Detector
+
+	mu      sync.Mutex
+	peers   map[string]*Session // by remote address
+	devices map[string]*Session // by device ID
+}
+
+// openUDPListener binds a configured UDP port with the detector serving it
+func openUDPListener(cfg config.Listener, opts adapter.Options, protocols []string) (*udpListener, error) {
+	detector, err := newDetector(cfg, opts, protocols)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := fmt.Sprintf(":%d", cfg.Port)
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid UDP address %s: %w", addr, err)
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on udp %s: %w", addr, err)
+	}
+
+	log.Printf("[Gateway] UDP server listening on %s (%v, idle timeout %s, max sessions %d)",
+		addr, detector.Protocols(), cfg.ReadTimeout, cfg.MaxConnections)
+	return &udpListener{
+		config:   cfg,
+		conn:     conn,
+		detector: detector,
+		peers:    make(map[string]*Session),
+		devices:  make(map[string]*Session),
+	}, nil
+}
+
+// idleTimeout is how long a session lives without datagrams
+func (l *udpListener) idleTimeout() time.Duration {
+	if l.config.ReadTimeout > 0 {
+		return l.config.ReadTimeout
+	}
+	return udpIdleTimeout
+}
+
+// identify binds a session to the device it reported for. A device already
+// known from another address keeps its session, which is moved to the new
+// address; the returned session handles the device from now on.
+func (l *udpListener) identify(session *Session, deviceID string, addr *net.UDPAddr) *Session {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	existing, ok := l.devices[deviceID]
+	if !ok || existing == session {
+		l.devices[deviceID] = session
+		return session
+	}
+
+	conn := existing.Conn.(*udpConn)
+	if old := conn.RemoteAddr().String(); l.peers[old] == existing {
+		delete(l.peers, old)
+	}
+	conn.setRemote(addr)
+	existing.ClientIP = addr.String()
+	l.peers[addr.String()] = existing
+
+	// Drop the session created for the new address; closing its conn would
+	// run the close hook and unbind the device. Packets it queued, like the
+	// ack of this datagram, go out through the device's session.
+	session.writer.handOver(existing.writer)
+	session.closeAdapter()
+	log.Printf("[Gateway] Device %s moved to %s", deviceID, addr)
+	return existing
+}
+
+// remove drops a closed session from the listener
+func (l *udpListener) remove(session *Session) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if key := session.Conn.RemoteAddr().String(); l.peers[key] == session {
+		delete(l.peers, key)
+	}
+	if l.devices[session.DeviceID] == session {
+		delete(l.devices, session.DeviceID)
+	}
+}
+
+func (s *TCPServer) serveUDP(l *udpListener) {
+	buffer := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := l.conn.ReadFromUDP(buffer)
+		if err != nil {
+			select {
+			case <-s.ctx.Done():
+				return
+			default:
+				log.Printf("[Gateway] UDP read error on port %d: %v", l.config.Port, err)
+				continue
+			}
+		}
+		s.handleDatagram(l, addr, append([]byte(nil), buffer[:n]...))
+	}
+}
+
+// handleDatagram decodes the packets of a datagram in the session of its
+// sender; a datagram carries whole packets, trailing bytes are dropped
+func (s *TCPServer) handleDatagram(l *udpListener, addr *net.UDPAddr, datagram []byte) {
+	session, err := s.udpSession(l, addr, datagram)
+	if err != nil {
+		log.Printf("[Gateway] Dropping datagram from %s: %v", addr, err)
+		return
+	}
+	session.Conn.(*udpConn).touch()
+	session.LastActive = time.Now()
+
+	pending := datagram
+	for len(pending) > 0 {
+		packet, rest, err := session.Scanner.Scan(pending)
+		pending = rest
+		if err != nil {
+			log.Printf("[Gateway] Packet extraction error: %v", err)
+			continue
+		}
+		if packet == nil {
+			break
+		}
+
+		msg, err := session.Adapter.Decode(packet)
+		if err != nil {
+			log.Printf("[Gateway] Decode error: %v", err)
+			continue
+		}
+		if msg != nil && msg.DeviceID != "" && session.DeviceID == "" {
+			session = l.identify(session, msg.DeviceID, addr)
+		}
+		s.handleMessage(session, packet, msg)
+	}
+}
+
+// udpSession returns the session of a remote address, detecting the
+// protocol of a new sender from its first datagram
+func (s *TCPServer) udpSession(l *udpListener, addr *net.UDPAddr, datagram []byte) (*Session, error) {
+	key := addr.String()
+	l.mu.Lock()
+	session, ok := l.peers[key]
+	full := l.config.MaxConnections > 0 && len(l.peers) >= l.config.MaxConnections
+	l.mu.Unlock()
+	if ok {
+		return session, nil
+	}
+	if full {
+		return nil, fmt.Errorf("port %d reached %d sessions", l.config.Port, l.config.MaxConnections)
+	}
+
+	conn := &udpConn{socket: l.conn, remote: addr, lastActive: time.Now()}
+	session = &Session{
+		ConnID:     fmt.Sprintf("%s-%d", s.config.GatewayID, s.connSeq.Add(1)),
+		Conn:       conn,
+		GatewayID:  s.config.GatewayID,
+		ClientIP:   key,
+		LastActive: time.Now(),
+	}
+	conn.onClose = func() {
+		l.remove(session)
+		s.cleanupSession(session)
+	}
+
+	matched, err := s.detectProtocol(session, l.detector, datagram)
+	if err != nil {
+		return nil, err
+	}
+	if !matched {
+		return nil, errors.New("too short to detect the protocol")
+	}
+
+	s.startWriter(session)
+	l.mu.Lock()
+	l.peers[key] = session
+	l.mu.Unlock()
+	log.Printf("[Gateway] New UDP session: %s from %s", session.ConnID, key)
+	return session, nil
+}
+
+// expireUDPSessions closes sessions whose device stopped reporting
+func (s *TCPServer) expireUDPSessions(l *udpListener) {
+	idle := l.idleTimeout()
+	ticker := time.NewTicker(idle / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var expired []*Session
+		l.mu.Lock()
+		for _, session := range l.peers {
+			if session.Conn.(*udpConn).idle() > idle {
+				expired = append(expired, session)
+			}
+		}
+		l.mu.Unlock()
+
+		for _, session := range expired {
+			log.Printf("[Gateway] UDP session %s idle for %s, expiring", session.ConnID, idle)
+			session.Conn.Close()
+		}
+	}
+}
+
+// udpConn is the net.Conn of a UDP session: writes go to the last address
+// the device reported from and Close ends the session
+type udpConn struct {
+	socket  *net.UDPConn
+	onClose func()
+
+	mu         sync.Mutex
+	remote     *net.UDPAddr
+	lastActive time.Time
+	closed     bool
+}
+
+func (c *udpConn) setRemote(addr *net.UDPAddr) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remote = addr
+}
+
+// touch records a datagram from the device
+func (c *udpConn) touch() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastActive = time.Now()
+}
+
+// idle returns the time since the device last reported
+func (c *udpConn) idle() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Since(c.lastActive)
+}
+
+func (c *udpConn) Read(b []byte) (int, error) {
+	return 0, errUDPRead
+}
+
+func (c *udpConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	remote, closed := c.remote, c.closed
+	c.mu.Unlock()
+	if closed {
+		return 0, net.ErrClosed
+	}
+	return c.socket.WriteToUDP(b, remote)
+}
+
+func (c *udpConn) Close() error {
+	c.mu.Lock()
+	closed := c.closed
+	c.closed = true
+	c.mu.Unlock()
+	if !closed && c.onClose != nil {
+		c.onClose()
+	}
+	return nil
+}
+
+func (c *udpConn) LocalAddr() net.Addr {
+	return c.socket.LocalAddr()
+}
+
+func (c *udpConn) RemoteAddr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.remote
+}
+
+func (c *udpConn) SetDeadline(t time.Time) error      { return nil }
+func (c *udpConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *udpConn) SetWriteDeadline(t time.Time) error { return nil }
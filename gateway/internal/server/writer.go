@@ -0,0 +1,218 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"openfms/gateway/internal/config"
+)
+
+var (
+	errSessionClosed = errors.New("session closed")
+	errQueueFull     = errors.New("write queue full")
+)
+
+// writeStats counts outcomes of the session write queues
+type writeStats struct {
+	dropped    atomic.Uint64 // packets dropped on a full queue
+	fullCloses atomic.Uint64 // sessions closed on a full queue
+	errors     atomic.Uint64 // failed or timed out writes
+}
+
+// outbound is a packet waiting to be written; done, if set, receives the
+// result of the write
+type outbound struct {
+	data []byte
+	done func(error)
+}
+
+// sessionWriter serializes the writes to a device connection through a
+// bounded queue drained by a single goroutine, so neither the read loop nor
+// the command paths block on a slow device
+type sessionWriter struct {
+	session *Session
+	queue   chan outbound
+	timeout time.Duration
+	policy  string
+	stats   *writeStats
+
+	mu     sync.Mutex
+	closed bool
+	stop   chan struct{}
+	next   *sessionWriter // takes over the queue left on close
+}
+
+// startWriter gives a session its write queue; the writer stops when the
+// session is cleaned up
+func (s *TCPServer) startWriter(session *Session) {
+	w := &sessionWriter{
+		session: session,
+		queue:   make(chan outbound, s.config.WriteQueueSize),
+		timeout: s.config.WriteTimeout,
+		policy:  s.config.WriteQueuePolicy,
+		stats:   &s.writes,
+		stop:    make(chan struct{}),
+	}
+	session.writer = w
+	go w.run()
+}
+
+// send queues data for the device without waiting for the write
+func (sess *Session) send(data []byte) error {
+	return sess.writer.enqueue(data, nil)
+}
+
+// sendAsync queues data for the device and calls done with the result of
+// the write, or with the reason it was not written
+func (sess *Session) sendAsync(data []byte, done func(error)) {
+	if err := sess.writer.enqueue(data, done); err != nil {
+		done(err)
+	}
+}
+
+// QueueDepth returns the number of packets waiting to be written
+func (sess *Session) QueueDepth() int {
+	return len(sess.writer.queue)
+}
+
+func (w *sessionWriter) enqueue(data []byte, done func(error)) error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return errSessionClosed
+	}
+	select {
+	case w.queue <- outbound{data: data, done: done}:
+		w.mu.Unlock()
+		return nil
+	default:
+	}
+	w.mu.Unlock()
+
+	if w.policy == config.WriteQueueClose {
+		w.stats.fullCloses.Add(1)
+		log.Printf("[Gateway] Write queue of %s full (%d packets), closing", w.session.ConnID, cap(w.queue))
+		w.session.Conn.Close()
+	} else {
+		w.stats.dropped.Add(1)
+		log.Printf("[Gateway] Write queue of %s full (%d packets), dropping %d bytes",
+			w.session.ConnID, cap(w.queue), len(data))
+	}
+	return errQueueFull
+}
+
+func (w *sessionWriter) run() {
+	for {
+		select {
+		case <-w.stop:
+			w.drain()
+			return
+		case out := <-w.queue:
+			err := w.write(out.data)
+			if out.done != nil {
+				out.done(err)
+			}
+			if err != nil {
+				w.stats.errors.Add(1)
+				log.Printf("[Gateway] Write error to %s: %v, closing", w.session.ConnID, err)
+				w.close()
+				w.session.Conn.Close()
+				w.drain()
+				return
+			}
+		}
+	}
+}
+
+func (w *sessionWriter) write(data []byte) error {
+	if w.timeout > 0 {
+		w.session.Conn.SetWriteDeadline(time.Now().Add(w.timeout))
+	}
+	_, err := w.session.Conn.Write(data)
+	return err
+}
+
+// drain fails the packets left in the queue of a closed session, or moves
+// them to the writer the queue was handed over to
+func (w *sessionWriter) drain() {
+	w.mu.Lock()
+	next := w.next
+	w.mu.Unlock()
+	for {
+		select {
+		case out := <-w.queue:
+			err := errSessionClosed
+			if next != nil {
+				err = next.enqueue(out.data, out.done)
+			}
+			if err != nil && out.done != nil {
+				out.done(err)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// close stops the writer; it does not wait, as it runs on the close path
+// of the connection the writer itself may be closing
+func (w *sessionWriter) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.closed {
+		w.closed = true
+		close(w.stop)
+	}
+}
+
+// handOver stops the writer and moves the packets still queued to another
+// writer, in order, instead of failing them
+func (w *sessionWriter) handOver(to *sessionWriter) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.closed {
+		w.closed = true
+		w.next = to
+		close(w.stop)
+	}
+}
+
+// handleMetrics exposes the session and write queue gauges in the
+// Prometheus text format
+func (s *TCPServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var sessions, depth, maxDepth int
+	s.sessions.Range(func(key, value interface{}) bool {
+		if session, ok := value.(*Session); ok {
+			sessions++
+			d := session.QueueDepth()
+			depth += d
+			if d > maxDepth {
+				maxDepth = d
+			}
+		}
+		return true
+	})
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics := []struct {
+		name, kind, help string
+		value            uint64
+	}{
+		{"openfms_gateway_sessions", "gauge", "Device sessions online on this gateway.", uint64(sessions)},
+		{"openfms_gateway_write_queue_depth", "gauge", "Packets waiting to be written to devices.", uint64(depth)},
+		{"openfms_gateway_write_queue_depth_max", "gauge", "Longest write queue of a device session.", uint64(maxDepth)},
+		{"openfms_gateway_write_queue_capacity", "gauge", "Write queue capacity of a device session.", uint64(s.config.WriteQueueSize)},
+		{"openfms_gateway_write_dropped_total", "counter", "Packets dropped on a full write queue.", s.writes.dropped.Load()},
+		{"openfms_gateway_write_queue_full_closes_total", "counter", "Sessions closed on a full write queue.", s.writes.fullCloses.Load()},
+		{"openfms_gateway_write_errors_total", "counter", "Writes to devices that failed or timed out.", s.writes.errors.Load()},
+	}
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s{gateway_id=%q} %d\n",
+			m.name, m.help, m.name, m.kind, m.name, s.config.GatewayID, m.value)
+	}
+}